@@ -0,0 +1,267 @@
+// Command taskgraphctl inspects and operates taskgraph jobs in etcd, so that no
+// one has to read the raw layout with etcdctl.
+//
+//	taskgraphctl [flags] list
+//	taskgraphctl [flags] status <job>
+//	taskgraphctl [flags] kill-task <job> <taskID>
+//	taskgraphctl [flags] cancel <job>
+//	taskgraphctl [flags] cleanup <job>
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/controller"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+const usage = `Usage: taskgraphctl [flags] <command> [args]
+
+Commands:
+  list                      list all jobs
+  status <job>              show epoch, status, and state of each task
+  kill-task <job> <taskID>  report the task failed, i.e. mark it free; a healthy
+                            one needs -force
+  cancel <job>              cancel the job, all tasks exit
+  cleanup <job>             remove everything of the job
+
+Flags:
+`
+
+func main() {
+	etcdUrlList := flag.String("etcd_urls", "http://localhost:2379", "ETCD server lists, sep by a comma.")
+	jsonOutput := flag.Bool("json", false, "Print output in JSON.")
+	reason := flag.String("reason", "cancelled by taskgraphctl", "Reason to cancel a job.")
+	force := flag.Bool("force", false, "Clean up a job even if it has live tasks, or kill a healthy task.")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   strings.Split(*etcdUrlList, ","),
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed connecting etcd: %v", err)
+	}
+	coordinator := etcdutil.NewCoordinator(client)
+	defer coordinator.Close()
+
+	cmd := &command{
+		coordinator: coordinator,
+		out:         os.Stdout,
+		json:        *jsonOutput,
+		reason:      *reason,
+		force:       *force,
+	}
+	if err := cmd.run(flag.Args()); err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+// command runs a subcommand against a coordinator and prints the result to out.
+type command struct {
+	coordinator taskgraph.Coordinator
+	out         io.Writer
+	json        bool
+	reason      string
+	force       bool
+}
+
+// result is printed by commands that change a job.
+type result struct {
+	Job    string
+	Action string
+	TaskID *uint64 `json:",omitempty"`
+	Reason string  `json:",omitempty"`
+}
+
+func (c *command) run(args []string) error {
+	nargs := map[string]int{"list": 1, "status": 2, "kill-task": 3, "cancel": 2, "cleanup": 2}
+	n, ok := nargs[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command")
+	}
+	if len(args) != n {
+		return fmt.Errorf("wrong number of arguments, want %d, get %d", n-1, len(args)-1)
+	}
+	switch args[0] {
+	case "list":
+		return c.list()
+	case "status":
+		return c.status(args[1])
+	case "kill-task":
+		taskID, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad task ID %q", args[2])
+		}
+		return c.killTask(args[1], taskID)
+	case "cancel":
+		return c.cancel(args[1])
+	default:
+		return c.cleanup(args[1])
+	}
+}
+
+func (c *command) list() error {
+	jobs, err := controller.ListJobs(c.coordinator)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(jobs)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tLIVE TASKS\tLAST HEARTBEAT")
+	for _, job := range jobs {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", job.Name, job.Status, job.LiveTasks, formatTime(job.LastHeartbeat))
+	}
+	return w.Flush()
+}
+
+func (c *command) status(job string) error {
+	detail, err := c.coordinator.DescribeJob(job)
+	if err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(detail)
+	}
+	fmt.Fprintf(c.out, "Job:            %s\n", detail.Name)
+	fmt.Fprintf(c.out, "Status:         %s\n", detail.Status)
+	fmt.Fprintf(c.out, "Epoch:          %s\n", formatEpoch(detail.Epoch))
+	if detail.NumOfTasks > 0 {
+		fmt.Fprintf(c.out, "Tasks:          %d\n", detail.NumOfTasks)
+	}
+	fmt.Fprintf(c.out, "Live tasks:     %d\n", detail.LiveTasks)
+	fmt.Fprintf(c.out, "Last heartbeat: %s\n\n", formatTime(detail.LastHeartbeat))
+
+	w := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TASK\tADDRESS\tHEALTHY\tFREE\tBACKUPS\tMETA")
+	for _, task := range detail.Tasks {
+		addr := task.Address
+		if addr == "" {
+			addr = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%t\t%t\t%d\t%s\n", task.ID, addr, task.Healthy, task.Free, len(task.Backups), formatMetas(task.Metas))
+	}
+	return w.Flush()
+}
+
+// killTask reports the task failed as the controller does, i.e. marks it free.
+// A standby bootstrap takes it over once the session of its current node is
+// gone. A node that's still alive keeps the task; kill its process for that.
+// So a healthy task is refused unless forced, and then standbys just drop the
+// free hint.
+func (c *command) killTask(job string, taskID uint64) error {
+	detail, err := c.coordinator.DescribeJob(job)
+	if err != nil {
+		return err
+	}
+	for _, task := range detail.Tasks {
+		if task.ID == taskID && task.Healthy && !c.force {
+			return fmt.Errorf("task %d of job %s is healthy, kill its process or use -force", taskID, job)
+		}
+	}
+	if err := c.coordinator.ReportFailure(job, taskID); err != nil {
+		return err
+	}
+	return c.printResult(result{Job: job, Action: "kill-task", TaskID: &taskID})
+}
+
+func (c *command) cancel(job string) error {
+	if _, err := c.coordinator.DescribeJob(job); err != nil {
+		return err
+	}
+	if err := controller.CancelJob(c.coordinator, job, c.reason); err != nil {
+		return err
+	}
+	return c.printResult(result{Job: job, Action: "cancel", Reason: c.reason})
+}
+
+func (c *command) cleanup(job string) error {
+	detail, err := c.coordinator.DescribeJob(job)
+	if err != nil {
+		return err
+	}
+	if detail.LiveTasks > 0 && !c.force {
+		return fmt.Errorf("job %s has %d live tasks, cancel it first or use -force", job, detail.LiveTasks)
+	}
+	if err := c.coordinator.DestroyJob(job); err != nil {
+		return err
+	}
+	return c.printResult(result{Job: job, Action: "cleanup"})
+}
+
+func (c *command) printResult(r result) error {
+	if c.json {
+		return c.printJSON(r)
+	}
+	switch r.Action {
+	case "kill-task":
+		fmt.Fprintf(c.out, "Reported task %d of job %s failed\n", *r.TaskID, r.Job)
+	case "cancel":
+		fmt.Fprintf(c.out, "Cancelled job %s: %s\n", r.Job, r.Reason)
+	case "cleanup":
+		fmt.Fprintf(c.out, "Removed job %s\n", r.Job)
+	}
+	return nil
+}
+
+func (c *command) printJSON(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(c.out, "%s\n", b)
+	return err
+}
+
+func formatEpoch(epoch uint64) string {
+	if epoch == taskgraph.ExitEpoch {
+		return "exit"
+	}
+	return strconv.FormatUint(epoch, 10)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return t.Format(time.RFC3339)
+}
+
+// formatMetas prints metas as linkType=meta, sorted by link type.
+func formatMetas(metas map[string]string) string {
+	if len(metas) == 0 {
+		return "-"
+	}
+	linkTypes := make([]string, 0, len(metas))
+	for linkType := range metas {
+		linkTypes = append(linkTypes, linkType)
+	}
+	sort.Strings(linkTypes)
+	pairs := make([]string, len(linkTypes))
+	for i, linkType := range linkTypes {
+		pairs[i] = linkType + "=" + metas[linkType]
+	}
+	return strings.Join(pairs, " ")
+}
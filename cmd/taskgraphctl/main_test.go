@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+)
+
+func TestStatus(t *testing.T) {
+	job := "TestStatus"
+	store := memcoord.NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	node := store.NewCoordinator()
+	node.StartSession(time.Second, 3)
+	if ok, err := node.TryOccupyTask(job, 1, "addr1"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+	node.SetMeta(job, "Parents", 1, 3, "ready")
+	ctl.CASEpoch(job, 0, 3)
+
+	out := new(bytes.Buffer)
+	c := &command{coordinator: ctl, out: out}
+	if err := c.run([]string{"status", job}); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	for _, want := range []string{"Epoch:          3", "Live tasks:     1", "addr1", "Parents=3-ready"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status output should contain %q, get:\n%s", want, out)
+		}
+	}
+
+	out.Reset()
+	c.json = true
+	if err := c.run([]string{"status", job}); err != nil {
+		t.Fatalf("status failed: %v", err)
+	}
+	var detail taskgraph.JobDetail
+	if err := json.Unmarshal(out.Bytes(), &detail); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	want := []taskgraph.TaskInfo{
+		{ID: 0, Free: true, Metas: map[string]string{}},
+		{ID: 1, Address: "addr1", Healthy: true, Metas: map[string]string{"Parents": "3-ready"}},
+	}
+	if len(detail.Tasks) != len(want) {
+		t.Fatalf("tasks want = %+v, get = %+v", want, detail.Tasks)
+	}
+	for i := range want {
+		get := detail.Tasks[i]
+		if get.ID != want[i].ID || get.Address != want[i].Address || get.Healthy != want[i].Healthy ||
+			get.Free != want[i].Free || len(get.Metas) != len(want[i].Metas) || get.Metas["Parents"] != want[i].Metas["Parents"] {
+			t.Errorf("#%d: task want = %+v, get = %+v", i, want[i], get)
+		}
+	}
+}
+
+func TestCancelAndCleanup(t *testing.T) {
+	job := "TestCancelAndCleanup"
+	store := memcoord.NewStore()
+	coord := store.NewCoordinator()
+	if err := coord.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	node := store.NewCoordinator()
+	node.StartSession(time.Second, 3)
+	if ok, err := node.TryOccupyTask(job, 0, "addr0"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+
+	c := &command{coordinator: coord, out: new(bytes.Buffer), reason: "by test"}
+	if err := c.run([]string{"cancel", job}); err != nil {
+		t.Fatalf("cancel failed: %v", err)
+	}
+	detail, err := coord.DescribeJob(job)
+	if err != nil {
+		t.Fatalf("DescribeJob failed: %v", err)
+	}
+	cancelled := taskgraph.JobStatus{State: taskgraph.JobCancelled, Reason: "by test"}
+	if detail.Status != cancelled || detail.Epoch != taskgraph.ExitEpoch {
+		t.Errorf("(status, epoch) want = (%v, exit), get = (%v, %d)", cancelled, detail.Status, detail.Epoch)
+	}
+
+	if err := c.run([]string{"cleanup", job}); err == nil {
+		t.Errorf("cleanup of job with live tasks should fail")
+	}
+	c.force = true
+	if err := c.run([]string{"cleanup", job}); err != nil {
+		t.Fatalf("cleanup failed: %v", err)
+	}
+	if _, err := coord.DescribeJob(job); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("DescribeJob after cleanup, error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	job := "TestKillTask"
+	store := memcoord.NewStore()
+	coord := store.NewCoordinator()
+	if err := coord.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	node := store.NewCoordinator()
+	node.StartSession(time.Second, 3)
+	if ok, err := node.TryOccupyTask(job, 0, "addr0"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+
+	c := &command{coordinator: coord, out: new(bytes.Buffer)}
+	if err := c.run([]string{"kill-task", job, "0"}); err == nil {
+		t.Errorf("kill-task of healthy task should fail")
+	}
+	c.force = true
+	if err := c.run([]string{"kill-task", job, "0"}); err != nil {
+		t.Fatalf("kill-task failed: %v", err)
+	}
+	// The node is still alive, so a standby can't take the task, and drops the
+	// stale free hint instead.
+	standby := store.NewCoordinator()
+	standby.StartSession(time.Second, 3)
+	if ok, err := standby.TryOccupyTask(job, 0, "addr1"); ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (false, nil), get = (%v, %v)", ok, err)
+	}
+	detail, err := coord.DescribeJob(job)
+	if err != nil {
+		t.Fatalf("DescribeJob failed: %v", err)
+	}
+	if task := detail.Tasks[0]; !task.Healthy || task.Free || task.Address != "addr0" {
+		t.Errorf("task want = healthy, not free, at addr0, get = %+v", task)
+	}
+}
@@ -1,88 +1,299 @@
 package controller
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 )
 
+// The controller touches its job this often, so that a job without controller
+// or tasks can be told abandoned.
+const jobHeartbeatInterval = 10 * time.Second
+
 // This is the controller of a job.
 // A job needs controller to setup etcd data layout, request
 // cluster containers, etc. to setup framework to run.
 type Controller struct {
 	name           string
-	etcdclient     *etcd.Client
+	coordinator    taskgraph.Coordinator
 	numOfTasks     uint64
+	numOfBackups   uint64
+	epochQuorum    uint64
+	staleness      uint64
+	resume         bool
 	failDetectStop chan bool
+	heartbeatStop  chan bool
+	deadline       time.Time
+	deadlineTimer  *time.Timer
 	logger         *log.Logger
-	jobStatusChan  chan string
+	jobStatusChan  chan taskgraph.JobStatus
+	jobStatusStop  chan bool
 	linkTypes      []string
 }
 
-func New(name string, etcd *etcd.Client, numOfTasks uint64, pLinkTypes []string) *Controller {
+func New(name string, etcd *clientv3.Client, numOfTasks uint64, pLinkTypes []string) *Controller {
+	return NewWithCoordinator(name, etcdutil.NewCoordinator(etcd), numOfTasks, pLinkTypes)
+}
+
+// NewWithCoordinator is like New, but the job is set up on the given coordinator,
+// e.g. a memcoord one shared with bootstraps in the same process. The caller still
+// owns the coordinator.
+func NewWithCoordinator(name string, coordinator taskgraph.Coordinator, numOfTasks uint64, pLinkTypes []string) *Controller {
 	return &Controller{
-		name:       name,
-		etcdclient: etcd,
-		numOfTasks: numOfTasks,
-		logger:     log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate),
-		linkTypes:  pLinkTypes,
+		name:        name,
+		coordinator: coordinator,
+		numOfTasks:  numOfTasks,
+		logger:      log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate),
+		linkTypes:   pLinkTypes,
 	}
 }
 
 // A controller typical workflow:
 // 1. controller sets up etcd layout before any task starts running.
 // 2. Being ready, controller lets other tasks to run and reports any failure found.
+// With SetResume, if the layout of the job exists, i.e. the job was running but all
+// its tasks have died, it's resumed from the last epoch instead.
+// If Start fails, a layout it has set up is destroyed.
 func (c *Controller) Start() error {
-	if err := c.InitEtcdLayout(); err != nil {
+	created, err := c.setupEtcdLayout()
+	if err != nil {
+		return err
+	}
+	if err := c.setConfigs(); err != nil {
+		c.stopWatchOnJobStatus()
+		c.jobStatusChan = nil
+		if created {
+			c.DestroyEtcdLayout()
+		}
 		return err
 	}
 	// Currently no previous changes will be watches before watch is setup.
 	// We assumes that ttl is usually a few seconds. watch is setup before that.
-	go c.startFailureDetection()
+	c.failDetectStop = make(chan bool, 1)
+	go c.startFailureDetection(c.failDetectStop)
+	// unbuffered, so that a stop waits for a touch in progress.
+	c.heartbeatStop = make(chan bool)
+	go c.heartbeat(c.heartbeatStop)
+	if !c.deadline.IsZero() {
+		c.deadlineTimer = time.AfterFunc(c.deadline.Sub(time.Now()), func() {
+			if err := c.Cancel("deadline exceeded"); err != nil {
+				c.logger.Printf("Cancel on deadline failed: %v", err)
+			}
+		})
+	}
 	c.logger.Printf("Controller starting, name: %s, numberOfTask: %d\n", c.name, c.numOfTasks)
 	return nil
 }
 
-func (c *Controller) WaitForJobDone() error {
-	<-c.jobStatusChan
+// setupEtcdLayout sets up the layout of the job, or resumes it, and tells whether
+// it's newly created.
+func (c *Controller) setupEtcdLayout() (bool, error) {
+	if !c.resume {
+		return true, c.InitEtcdLayout()
+	}
+	epoch, err := c.ResumeEtcdLayout()
+	switch {
+	case err == nil:
+		c.logger.Printf("Controller resuming job %s at epoch %d\n", c.name, epoch)
+		return false, nil
+	case err == etcdutil.ErrKeyNotFound:
+		return true, c.InitEtcdLayout()
+	default:
+		return false, err
+	}
+}
+
+func (c *Controller) setConfigs() error {
+	configs := []struct {
+		key   string
+		value uint64
+	}{
+		{etcdutil.Backups, c.numOfBackups},
+		{etcdutil.EpochQuorum, c.epochQuorum},
+		{etcdutil.Staleness, c.staleness},
+	}
+	for _, config := range configs {
+		if config.value == 0 {
+			continue
+		}
+		if err := c.coordinator.SetConfig(c.name, config.key, strconv.FormatUint(config.value, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetDeadline makes the job cancelled if it isn't done by the deadline. It should
+// be called before Start.
+func (c *Controller) SetDeadline(deadline time.Time) {
+	c.deadline = deadline
+}
+
+// SetResume makes Start resume the existing layout of the job, if any, see
+// ResumeEtcdLayout. Otherwise Start fails if the layout exists. It should be called
+// before Start.
+func (c *Controller) SetResume(resume bool) {
+	c.resume = resume
+}
+
+// SetBackups makes each task have n hot-standby backups, i.e. bootstraps started
+// with framework.AsBackup. It should be called before Start.
+func (c *Controller) SetBackups(n uint64) {
+	c.numOfBackups = n
+}
+
+// SetEpochQuorum makes the job move to the next epoch once n tasks are done with
+// the epoch, see Framework.EpochDone, instead of all of them. Tasks left behind
+// have their epoch cancelled. It should be called before Start.
+func (c *Controller) SetEpochQuorum(n uint64) {
+	c.epochQuorum = n
+}
+
+// SetStaleness makes the job stale-synchronous: each task moves to its next epoch
+// once it's done with the current one, see Framework.EpochDone, and runs ahead of
+// the slowest task by at most s epochs. The epoch of the job is that of the slowest
+// task. Metas are kept by the epoch they are flagged at, so a slower neighbor gets
+// them once it's there. Zero s, the default, keeps all tasks at the same epoch. It
+// should be called before Start.
+func (c *Controller) SetStaleness(s uint64) {
+	c.staleness = s
+}
+
+// Cancel stops the running job. All tasks exit as if the job was shut down, but the
+// job is cancelled with the reason. If the job is already done, its status is kept,
+// but tasks still exit.
+func (c *Controller) Cancel(reason string) error {
+	if err := CancelJob(c.coordinator, c.name, reason); err != nil {
+		return err
+	}
+	c.logger.Printf("Controller cancelled job %s: %s\n", c.name, reason)
 	return nil
 }
 
+// WaitForJobDone blocks until the job is done, and returns its terminal status.
+// Error is returned if the job has failed or been cancelled, or the controller isn't
+// started.
+func (c *Controller) WaitForJobDone() (taskgraph.JobStatus, error) {
+	if c.jobStatusChan == nil {
+		return taskgraph.JobStatus{}, fmt.Errorf("controller: job %s is not started", c.name)
+	}
+	status := <-c.jobStatusChan
+	return status, status.Err()
+}
+
+// Stop stops the controller and destroys the job. Error is returned if the
+// controller isn't started, and the job is left as is.
 func (c *Controller) Stop() error {
-	c.DestroyEtcdLayout()
+	if c.heartbeatStop == nil {
+		return fmt.Errorf("controller: job %s is not started", c.name)
+	}
+	// Stop everything that writes to the job first, or e.g. a heartbeat might
+	// bring part of it back after it's destroyed.
+	c.heartbeatStop <- true
+	c.heartbeatStop = nil
+	if c.deadlineTimer != nil {
+		c.deadlineTimer.Stop()
+	}
 	c.stopFailureDetection()
+	c.stopWatchOnJobStatus()
+	c.DestroyEtcdLayout()
 	c.logger.Printf("Controller stoping...\n")
 	return nil
 }
 
+// InitEtcdLayout sets up the layout of a new job. It's left to nobody if the job
+// status can't be watched, so it's destroyed then.
 func (c *Controller) InitEtcdLayout() error {
-	// Initilize the job epoch to 0
-	etcdutil.MustCreate(c.etcdclient, c.logger, etcdutil.EpochPath(c.name), "0", 0)
-	c.setupWatchOnJobStatus()
-	// initiate etcd data layout for tasks
-	// currently it creates as many unassigned tasks as task masters.
-	for i := uint64(0); i < c.numOfTasks; i++ {
-		key := etcdutil.FreeTaskPath(c.name, strconv.FormatUint(i, 10))
-		etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
-		for _, linkType := range c.linkTypes {
-			key = etcdutil.MetaPath(linkType, c.name, i)
-			etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
-		}
+	if err := c.coordinator.InitJob(c.name, c.numOfTasks); err != nil {
+		return err
+	}
+	if err := c.setupWatchOnJobStatus(); err != nil {
+		c.DestroyEtcdLayout()
+		return err
 	}
 	return nil
 }
 
+// ResumeEtcdLayout keeps the epoch of the existing job and marks all its tasks free,
+// so that new tasks continue from where the job stopped, given that they reload
+// state of the epoch. If the job has committed a checkpoint, it goes back to the
+// epoch of the latest one instead, which every task has a snapshot of. It should
+// only be used when no task of the job is running. etcdutil.ErrKeyNotFound is
+// returned if the job doesn't exist. A job that's finished, e.g. done or cancelled,
+// isn't resumed; destroy it first to run it again.
+func (c *Controller) ResumeEtcdLayout() (uint64, error) {
+	detail, err := c.coordinator.DescribeJob(c.name)
+	if err != nil {
+		return 0, err
+	}
+	if detail.Status.Done() || detail.Epoch == taskgraph.ExitEpoch {
+		return 0, fmt.Errorf("controller: job %s is finished, status %s", c.name, detail.Status)
+	}
+	epoch, err := c.coordinator.ResumeJob(c.name, c.numOfTasks, c.linkTypes)
+	if err != nil {
+		return 0, err
+	}
+	checkpoint, err := c.coordinator.LatestCheckpoint(c.name)
+	switch {
+	case err == nil && checkpoint.Epoch < epoch:
+		c.logger.Printf("Controller rolling job %s back to checkpoint of epoch %d\n", c.name, checkpoint.Epoch)
+		if err := c.coordinator.SetEpoch(c.name, checkpoint.Epoch); err != nil {
+			return 0, err
+		}
+		// Tasks at the epoch of the checkpoint might differ, if the job has
+		// been resized since.
+		if epoch, err = c.coordinator.ResumeJob(c.name, c.numOfTasks, c.linkTypes); err != nil {
+			return 0, err
+		}
+	case err != nil && err != etcdutil.ErrKeyNotFound:
+		return 0, err
+	}
+	// The job might have been resized.
+	if n, err := c.coordinator.GetJobSize(c.name, epoch); err == nil {
+		c.numOfTasks = n
+	}
+	return epoch, c.setupWatchOnJobStatus()
+}
+
+// Resize makes the job have numOfTasks tasks from the next epoch on. New tasks are
+// set up free at once, and join the job at the next epoch. Tasks beyond the new
+// size leave it then. If the epoch moves on meanwhile, the resize is retried
+// against the new one. The topology has to be a taskgraph.ResizableTopology, or
+// tasks get a framework error at the next epoch.
+func (c *Controller) Resize(numOfTasks uint64) error {
+	for {
+		detail, err := c.coordinator.DescribeJob(c.name)
+		if err != nil {
+			return err
+		}
+		if detail.Epoch == taskgraph.ExitEpoch {
+			return fmt.Errorf("controller: job %s is done", c.name)
+		}
+		err = c.coordinator.ResizeJob(c.name, detail.Epoch, numOfTasks)
+		if err == etcdutil.ErrCompareFailed {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.logger.Printf("Controller resized job %s to %d tasks from epoch %d\n", c.name, numOfTasks, detail.Epoch+1)
+		c.numOfTasks = numOfTasks
+		return nil
+	}
+}
+
 func (c *Controller) DestroyEtcdLayout() error {
-	_, err := c.etcdclient.Delete("/", true)
-	return err
+	return c.coordinator.DestroyJob(c.name)
 }
 
-func (c *Controller) startFailureDetection() error {
-	c.failDetectStop = make(chan bool, 1)
-	err := etcdutil.DetectFailure(c.etcdclient, c.name, c.failDetectStop)
+func (c *Controller) startFailureDetection(stop chan bool) error {
+	err := c.coordinator.DetectFailure(c.name, stop)
 	if err != nil {
 		// We currently didn't handle outside. So we do some logging at least.
 		c.logger.Printf("DetectFailure returns error: %v", err)
@@ -90,20 +301,83 @@ func (c *Controller) startFailureDetection() error {
 	return err
 }
 
-func (c *Controller) setupWatchOnJobStatus() {
-	c.jobStatusChan = make(chan string, 1)
-	key := etcdutil.JobStatusPath(c.name)
-	resp := etcdutil.MustCreate(c.etcdclient, c.logger, key, "", 0)
-	go func() {
-		resp, err := c.etcdclient.Watch(key, resp.EtcdIndex+1, false, nil, nil)
-		if err != nil {
-			c.logger.Panicf("Watch on job status (%v) failed: %v", key, err)
-		}
-		c.jobStatusChan <- resp.Node.Value
-	}()
+func (c *Controller) setupWatchOnJobStatus() error {
+	c.stopWatchOnJobStatus()
+	c.jobStatusChan = make(chan taskgraph.JobStatus, 1)
+	c.jobStatusStop = make(chan bool)
+	err := c.coordinator.WatchJobStatus(c.name, c.jobStatusStop, func(status taskgraph.JobStatus) {
+		c.jobStatusChan <- status
+	})
+	if err != nil {
+		c.stopWatchOnJobStatus()
+		c.jobStatusChan = nil
+	}
+	return err
+}
+
+func (c *Controller) stopWatchOnJobStatus() {
+	if c.jobStatusStop != nil {
+		close(c.jobStatusStop)
+		c.jobStatusStop = nil
+	}
 }
 
 func (c *Controller) stopFailureDetection() error {
-	c.failDetectStop <- true
+	if c.failDetectStop != nil {
+		c.failDetectStop <- true
+		c.failDetectStop = nil
+	}
 	return nil
 }
+
+func (c *Controller) heartbeat(stop chan bool) {
+	for {
+		select {
+		case <-time.After(jobHeartbeatInterval):
+			if err := c.coordinator.TouchJob(c.name); err != nil {
+				c.logger.Printf("TouchJob failed: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ListJobs returns all jobs on the coordinator, which might be shared by many jobs.
+func ListJobs(coordinator taskgraph.Coordinator) ([]taskgraph.JobInfo, error) {
+	return coordinator.ListJobs()
+}
+
+// CancelJob cancels the job from outside of its controller. Like Cancel, it keeps
+// the status of a job already done. Tasks are still made to exit then, as the
+// status might be set by an earlier try that failed to, or the status key is
+// missing in a layout of an older version.
+func CancelJob(coordinator taskgraph.Coordinator, job, reason string) error {
+	err := coordinator.SetJobStatus(job, taskgraph.JobStatus{State: taskgraph.JobCancelled, Reason: reason})
+	if err != nil && err != etcdutil.ErrCompareFailed {
+		return err
+	}
+	return coordinator.SetEpoch(job, taskgraph.ExitEpoch)
+}
+
+// GCJobs destroys abandoned jobs, i.e. jobs that have no live task and haven't been
+// touched for maxAge, and returns their names. A running controller touches its job
+// every 10 seconds, and finishing a job touches it too, so maxAge should be much
+// longer than that.
+func GCJobs(coordinator taskgraph.Coordinator, maxAge time.Duration) ([]string, error) {
+	jobs, err := coordinator.ListJobs()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, job := range jobs {
+		if job.LiveTasks > 0 || time.Since(job.LastHeartbeat) < maxAge {
+			continue
+		}
+		if err := coordinator.DestroyJob(job.Name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, job.Name)
+	}
+	return removed, nil
+}
@@ -1,16 +1,24 @@
 package controller
 
 import (
+	"errors"
+	"reflect"
 	"strconv"
 	"testing"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
 )
 
 // etcd needs to be initialized beforehand
 func TestControllerInitEtcdLayout(t *testing.T) {
-	etcdClient := etcd.NewClient([]string{"http://localhost:4001"})
+	etcdClient, err := clientv3.New(clientv3.Config{Endpoints: []string{"http://localhost:2379"}})
+	if err != nil {
+		t.Fatalf("clientv3.New failed: %v", err)
+	}
 
 	tests := []struct {
 		name          string
@@ -26,19 +34,267 @@ func TestControllerInitEtcdLayout(t *testing.T) {
 
 		for taskID := uint64(0); taskID < tt.numberOfTasks; taskID++ {
 			key := etcdutil.FreeTaskPath(c.name, strconv.FormatUint(taskID, 10))
-			if _, err := etcdClient.Get(key, false, false); err != nil {
-				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
-			}
-			key = etcdutil.MetaPath("Parents", c.name, taskID)
-			if _, err := etcdClient.Get(key, false, false); err != nil {
-				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
-			}
-			key = etcdutil.MetaPath("Children", c.name, taskID)
-			if _, err := etcdClient.Get(key, false, false); err != nil {
-				t.Errorf("task %d: etcdClient.Get %v failed: %v", i, key, err)
+			if _, _, err := etcdutil.GetValue(etcdClient, key); err != nil {
+				t.Errorf("task %d: etcdutil.GetValue %v failed: %v", i, key, err)
 			}
 		}
 
 		c.DestroyEtcdLayout()
 	}
 }
+
+func TestControllerResume(t *testing.T) {
+	job := "TestControllerResume"
+	store := memcoord.NewStore()
+	c := NewWithCoordinator(job, store.NewCoordinator(), 2, []string{"Parents"})
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	c.stopFailureDetection()
+
+	// the job runs to epoch 3 and then loses all tasks.
+	task := store.NewCoordinator()
+	task.StartSession(time.Hour, 3)
+	if ok, err := task.TryOccupyTask(job, 1, "addr"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+	task.SetMeta(job, "Parents", 1, 3, "meta")
+	if err := task.CASEpoch(job, 0, 3); err != nil {
+		t.Fatalf("CASEpoch failed: %v", err)
+	}
+
+	c = NewWithCoordinator(job, store.NewCoordinator(), 2, []string{"Parents"})
+	epoch, err := c.ResumeEtcdLayout()
+	if err != nil {
+		t.Fatalf("ResumeEtcdLayout failed: %v", err)
+	}
+	if epoch != 3 {
+		t.Errorf("epoch want = 3, get = %d", epoch)
+	}
+	if _, err := task.GetAddress(job, 1); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("GetAddress error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	newTask := store.NewCoordinator()
+	newTask.StartSession(time.Hour, 3)
+	if ok, err := newTask.TryOccupyTask(job, 1, "newAddr"); !ok || err != nil {
+		t.Errorf("TryOccupyTask after resume want = (true, nil), get = (%v, %v)", ok, err)
+	}
+
+	if _, err := NewWithCoordinator("NoSuchJob", store.NewCoordinator(), 2, nil).ResumeEtcdLayout(); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("ResumeEtcdLayout of new job, error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+}
+
+func TestControllerStartExistingJob(t *testing.T) {
+	job := "TestControllerStartExistingJob"
+	store := memcoord.NewStore()
+	c := NewWithCoordinator(job, store.NewCoordinator(), 2, nil)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	c.stopFailureDetection()
+	task := store.NewCoordinator()
+	if err := task.CASEpoch(job, 0, 3); err != nil {
+		t.Fatalf("CASEpoch failed: %v", err)
+	}
+
+	// resuming is opted in.
+	c = NewWithCoordinator(job, store.NewCoordinator(), 2, nil)
+	if err := c.Start(); err == nil {
+		t.Errorf("Start on existing job without resume should fail")
+	}
+	c.SetResume(true)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start with resume failed: %v", err)
+	}
+	c.stopFailureDetection()
+	if detail, _ := task.DescribeJob(job); detail.Epoch != 3 {
+		t.Errorf("epoch of job want = 3, get = %d", detail.Epoch)
+	}
+
+	// a finished job isn't resumed.
+	if err := CancelJob(task, job, "by test"); err != nil {
+		t.Fatalf("CancelJob failed: %v", err)
+	}
+	c = NewWithCoordinator(job, store.NewCoordinator(), 2, nil)
+	if _, err := c.ResumeEtcdLayout(); err == nil {
+		t.Errorf("ResumeEtcdLayout of cancelled job should fail")
+	}
+	if detail, _ := task.DescribeJob(job); detail.Epoch != taskgraph.ExitEpoch {
+		t.Errorf("epoch of job want = exit, get = %d", detail.Epoch)
+	}
+}
+
+func TestControllerResumeFromCheckpoint(t *testing.T) {
+	job := "TestControllerResumeFromCheckpoint"
+	store := memcoord.NewStore()
+	c := NewWithCoordinator(job, store.NewCoordinator(), 2, nil)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	c.stopFailureDetection()
+
+	// both tasks checkpoint epoch 2, only one of them epoch 4, and the job
+	// stops at epoch 5.
+	task := store.NewCoordinator()
+	for _, taskID := range []uint64{0, 1} {
+		task.ReportCheckpoint(job, 2, taskgraph.TaskCheckpoint{TaskID: taskID})
+	}
+	if err := task.CommitCheckpoint(job, 2); err != nil {
+		t.Fatalf("CommitCheckpoint failed: %v", err)
+	}
+	task.ReportCheckpoint(job, 4, taskgraph.TaskCheckpoint{TaskID: 0})
+	if err := task.SetEpoch(job, 5); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+
+	c = NewWithCoordinator(job, store.NewCoordinator(), 2, nil)
+	epoch, err := c.ResumeEtcdLayout()
+	if err != nil {
+		t.Fatalf("ResumeEtcdLayout failed: %v", err)
+	}
+	if epoch != 2 {
+		t.Errorf("epoch want = 2, get = %d", epoch)
+	}
+	if detail, _ := task.DescribeJob(job); detail.Epoch != 2 {
+		t.Errorf("epoch of job want = 2, get = %d", detail.Epoch)
+	}
+	if manifest, _ := task.GetCheckpoint(job, 4); len(manifest.Tasks) != 0 {
+		t.Errorf("checkpoint not committed want dropped, get = %+v", manifest)
+	}
+}
+
+func TestControllerGCJobs(t *testing.T) {
+	store := memcoord.NewStore()
+	coord := store.NewCoordinator()
+	for _, job := range []string{"job", "job-running", "other"} {
+		if err := NewWithCoordinator(job, coord, 1, nil).InitEtcdLayout(); err != nil {
+			t.Fatalf("InitEtcdLayout failed: %v", err)
+		}
+	}
+	task := store.NewCoordinator()
+	task.StartSession(time.Hour, 3)
+	if ok, err := task.TryOccupyTask("job-running", 0, "addr"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+
+	jobs, err := ListJobs(coord)
+	if err != nil {
+		t.Fatalf("ListJobs failed: %v", err)
+	}
+	if len(jobs) != 3 || jobs[1].Name != "job-running" || jobs[1].LiveTasks != 1 {
+		t.Fatalf("ListJobs get = %+v", jobs)
+	}
+
+	// nothing is old enough.
+	if removed, err := GCJobs(coord, time.Hour); err != nil || len(removed) != 0 {
+		t.Errorf("GCJobs want = ([], nil), get = (%v, %v)", removed, err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	removed, err := GCJobs(coord, time.Millisecond)
+	if err != nil {
+		t.Fatalf("GCJobs failed: %v", err)
+	}
+	if want := []string{"job", "other"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("GCJobs removed want = %v, get = %v", want, removed)
+	}
+	// "job" is a prefix of "job-running", which shouldn't be touched.
+	if jobs, _ := ListJobs(coord); len(jobs) != 1 || jobs[0].Name != "job-running" {
+		t.Errorf("jobs left want = [job-running], get = %+v", jobs)
+	}
+	if _, err := task.GetAddress("job-running", 0); err != nil {
+		t.Errorf("running job is destroyed: %v", err)
+	}
+}
+
+func TestControllerCancel(t *testing.T) {
+	store := memcoord.NewStore()
+	tests := []struct {
+		cancel   func(c *Controller)
+		deadline time.Time
+		reason   string
+	}{
+		{func(c *Controller) { c.Cancel("by user") }, time.Time{}, "by user"},
+		{func(c *Controller) {}, time.Now().Add(20 * time.Millisecond), "deadline exceeded"},
+	}
+	for i, tt := range tests {
+		job := "TestControllerCancel-" + strconv.Itoa(i)
+		c := NewWithCoordinator(job, store.NewCoordinator(), 1, nil)
+		c.SetDeadline(tt.deadline)
+		if err := c.Start(); err != nil {
+			t.Fatalf("#%d: Start failed: %v", i, err)
+		}
+		epochC := make(chan uint64, 1)
+		stop := make(chan bool)
+		if _, err := store.NewCoordinator().GetAndWatchEpoch(job, epochC, stop); err != nil {
+			t.Fatalf("#%d: GetAndWatchEpoch failed: %v", i, err)
+		}
+
+		tt.cancel(c)
+		status, err := c.WaitForJobDone()
+		want := taskgraph.JobStatus{State: taskgraph.JobCancelled, Reason: tt.reason}
+		if status != want || err == nil {
+			t.Errorf("#%d: WaitForJobDone want = (%v, error), get = (%v, %v)", i, want, status, err)
+		}
+		if epoch := <-epochC; epoch != taskgraph.ExitEpoch {
+			t.Errorf("#%d: epoch want = exit epoch, get = %d", i, epoch)
+		}
+		close(stop)
+		c.Stop()
+	}
+}
+
+func TestControllerCancelDoneJob(t *testing.T) {
+	job := "TestControllerCancelDoneJob"
+	store := memcoord.NewStore()
+	c := NewWithCoordinator(job, store.NewCoordinator(), 1, nil)
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+	succeeded := taskgraph.JobStatus{State: taskgraph.JobSucceeded}
+	if err := store.NewCoordinator().SetJobStatus(job, succeeded); err != nil {
+		t.Fatalf("SetJobStatus failed: %v", err)
+	}
+	if err := c.Cancel("too late"); err != nil {
+		t.Errorf("Cancel failed: %v", err)
+	}
+	if status, err := c.WaitForJobDone(); status != succeeded || err != nil {
+		t.Errorf("WaitForJobDone want = (%v, nil), get = (%v, %v)", succeeded, status, err)
+	}
+	// tasks still exit, e.g. if an earlier cancel failed to make them.
+	if epoch, err := store.NewCoordinator().GetEpoch(job); err != nil || epoch != taskgraph.ExitEpoch {
+		t.Errorf("epoch want = (exit epoch, nil), get = (%d, %v)", epoch, err)
+	}
+}
+
+// failConfigCoordinator fails to set any job config.
+type failConfigCoordinator struct {
+	taskgraph.Coordinator
+}
+
+func (c failConfigCoordinator) SetConfig(job, key, value string) error {
+	return errors.New("SetConfig failed")
+}
+
+func TestControllerStartFailed(t *testing.T) {
+	job := "TestControllerStartFailed"
+	store := memcoord.NewStore()
+	c := NewWithCoordinator(job, failConfigCoordinator{store.NewCoordinator()}, 1, nil)
+	if err := c.Stop(); err == nil {
+		t.Errorf("Stop before Start should fail")
+	}
+	c.SetBackups(1)
+	if err := c.Start(); err == nil {
+		t.Fatalf("Start should fail")
+	}
+	if _, err := store.NewCoordinator().DescribeJob(job); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("layout of failed Start should be destroyed, DescribeJob error = %v", err)
+	}
+	if _, err := c.WaitForJobDone(); err == nil {
+		t.Errorf("WaitForJobDone after failed Start should fail")
+	}
+	if err := c.Stop(); err == nil {
+		t.Errorf("Stop after failed Start should fail")
+	}
+}
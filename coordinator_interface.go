@@ -0,0 +1,209 @@
+package taskgraph
+
+import (
+	"log"
+	"time"
+)
+
+// Coordinator is the coordination backend shared by framework and controller. It
+// keeps the job's global state, e.g. epoch, task ownership, meta and address of each
+// task, and notifies changes. Implementations include etcd (etcdutil.NewCoordinator)
+// and in memory (memcoord), which lets a whole job run in one process.
+// A Coordinator is used by one process. Tasks it occupies are bound to its session.
+type Coordinator interface {
+	// StartSession starts the liveness session of the process. The session expires
+	// if it misses ttlMultiplier heartbeats, and tasks occupied are released.
+	StartSession(heartbeatInterval time.Duration, ttlMultiplier uint64) error
+	// Heartbeat keeps the session alive until stop. It returns error if the session
+	// has expired.
+	Heartbeat(stop chan struct{}) error
+
+	// Epoch
+	// GetAndWatchEpoch returns current epoch, and sends later ones to epochC until stop.
+	GetAndWatchEpoch(job string, epochC chan uint64, stop chan bool) (uint64, error)
+	// GetEpoch returns current epoch.
+	GetEpoch(job string) (uint64, error)
+	// CASEpoch sets epoch only if current one is prevEpoch.
+	CASEpoch(job string, prevEpoch, epoch uint64) error
+	SetEpoch(job string, epoch uint64) error
+	// ReportEpochDone records that the task is done with the epoch, only if the
+	// job is still at the epoch. Otherwise ErrCompareFailed of etcdutil is returned.
+	// Once a quorum of the tasks at the epoch have reported, the job moves to the
+	// next epoch. The quorum is the epochQuorum config, all tasks by default.
+	ReportEpochDone(job string, epoch, taskID uint64) error
+	// ReportClock sets the epoch the task is at in a stale-synchronous job, see
+	// the staleness config. The job's epoch moves on to the slowest clock of the
+	// tasks at it, and never goes back. Tasks that never report are at the job's
+	// epoch.
+	ReportClock(job string, taskID, clock uint64) error
+
+	// Task ownership
+	// WaitFreeTask blocks until it gets a hint of free task, or stop is closed,
+	// when ErrStopped of etcdutil is returned.
+	WaitFreeTask(job string, stop chan struct{}, logger *log.Logger) (uint64, error)
+	// TryOccupyTask registers the process as the task with its address, if no one
+	// else has done so. Otherwise the free hint of the task is stale and dropped.
+	TryOccupyTask(job string, taskID uint64, addr string) (bool, error)
+	// DetectFailure marks tasks free once their sessions expire, unless they have
+	// been taken over already. It blocks until stop.
+	DetectFailure(job string, stop chan bool) error
+	// ReportFailure marks the task free so that another process can take over.
+	ReportFailure(job string, taskID uint64) error
+
+	// Backup
+	// TryOccupyBackup registers the process as the replica of the task with its
+	// address, if no one else has done so. replicaID starts from 1.
+	TryOccupyBackup(job string, taskID, replicaID uint64, addr string) (bool, error)
+	// ReleaseBackup frees the replica of the task, e.g. once the backup has taken
+	// over the task.
+	ReleaseBackup(job string, taskID, replicaID uint64) error
+
+	// Master and workers
+	// RegisterMaster sets the address of the master, by which workers find it.
+	RegisterMaster(job, addr string) error
+	// GetMasterAddress returns the address of the master.
+	GetMasterAddress(job string) (string, error)
+	// TryOccupyWorker registers the process as the worker with its address, if no
+	// one else has done so, like TryOccupyTask.
+	TryOccupyWorker(job string, workerID uint64, addr string) (bool, error)
+	// GetWorkerAddress returns the address of the worker.
+	GetWorkerAddress(job string, workerID uint64) (string, error)
+	// WaitFreeWorker is WaitFreeTask for worker IDs.
+	WaitFreeWorker(job string, stop chan struct{}, logger *log.Logger) (uint64, error)
+	// WatchWorkers returns the workers registered and alive, and calls handler with
+	// each worker registered later until stop.
+	WatchWorkers(job string, stop chan bool, handler func(workerID uint64)) ([]uint64, error)
+	// DetectWorkerFailure marks workers free once their sessions expire, and calls
+	// handler with each. It blocks until stop.
+	DetectWorkerFailure(job string, stop chan bool, handler func(workerID uint64)) error
+	// ReportWorkerFailure marks the worker free so that a standby can take over.
+	ReportWorkerFailure(job string, workerID uint64) error
+
+	// Checkpoint
+	// ReportCheckpoint records the snapshot that the task has saved at the epoch.
+	ReportCheckpoint(job string, epoch uint64, checkpoint TaskCheckpoint) error
+	// GetCheckpoint returns the snapshots reported at the epoch so far.
+	GetCheckpoint(job string, epoch uint64) (CheckpointManifest, error)
+	// CommitCheckpoint marks the checkpoint of the epoch committed, only if it's
+	// later than the one committed. Otherwise ErrCompareFailed of etcdutil is
+	// returned. Reports of earlier epochs are dropped.
+	CommitCheckpoint(job string, epoch uint64) error
+	// LatestCheckpoint returns the latest committed checkpoint. ErrKeyNotFound of
+	// etcdutil is returned if there is none.
+	LatestCheckpoint(job string) (CheckpointManifest, error)
+
+	// Meta
+	// SetMeta sets the meta on (linkType, taskID) at the epoch. Metas set on it
+	// before the job's epoch are dropped.
+	SetMeta(job, linkType string, taskID, epoch uint64, meta string) error
+	// WatchMeta calls handler with the meta set on (linkType, taskID) at the epoch,
+	// including the one set before the watch, until stop. ErrKeyNotFound of
+	// etcdutil is returned if there is no such job.
+	WatchMeta(job, linkType string, taskID, epoch uint64, stop chan bool, handler func(taskID uint64, meta string)) error
+
+	// Address registry
+	GetAddress(job string, taskID uint64) (string, error)
+	// WatchTaskAddress calls handler when a task's address changes until stop. Address
+	// is empty if the task has failed and no one takes over yet.
+	WatchTaskAddress(job string, stop chan bool, handler func(taskID uint64, addr string)) error
+
+	// Job
+	// InitJob sets up the layout of a new job: epoch 0, and all tasks free.
+	InitJob(job string, numOfTasks uint64) error
+	// ResumeJob takes over the layout left by a job that has lost all its tasks. It
+	// keeps the epoch, which is returned, and marks all tasks free with metas cleared.
+	// Checkpoints not committed, reports of epoch done, and clocks of tasks are
+	// dropped.
+	// ErrKeyNotFound of etcdutil is returned if there is no such job. numOfTasks is
+	// only used if the job never records its size.
+	ResumeJob(job string, numOfTasks uint64, linkTypes []string) (uint64, error)
+	// SetConfig sets the job config of key, e.g. the number of backups.
+	SetConfig(job, key, value string) error
+	// GetConfig returns the job config of key. ErrKeyNotFound of etcdutil is
+	// returned if it's never set.
+	GetConfig(job, key string) (string, error)
+	// ResizeJob makes the job have numOfTasks tasks from epoch+1 on, only if the
+	// job is still at epoch. Otherwise ErrCompareFailed of etcdutil is returned.
+	ResizeJob(job string, epoch, numOfTasks uint64) error
+	// GetJobSize returns the number of tasks at the epoch. ExitEpoch gives the
+	// latest one, pending or not. ErrKeyNotFound of etcdutil is returned if the job
+	// never records its size.
+	GetJobSize(job string, epoch uint64) (uint64, error)
+	// GetAndWatchJobSizes returns the recorded sizes of the job as keys to values,
+	// see JobSizeAt of etcdutil, and calls handler with each size recorded later
+	// until stop. The map is empty if the job never records its size.
+	GetAndWatchJobSizes(job string, stop chan bool, handler func(key, value string)) (map[string]string, error)
+	// DestroyJob removes everything of the job, and nothing else.
+	DestroyJob(job string) error
+	// TouchJob records now as the last time the job is known alive.
+	TouchJob(job string) error
+	// ListJobs returns all jobs, running or abandoned, sorted by name.
+	ListJobs() ([]JobInfo, error)
+	// DescribeJob returns a snapshot of the job down to each task. ErrKeyNotFound
+	// of etcdutil is returned if there is no such job.
+	DescribeJob(job string) (JobDetail, error)
+	// SetJobStatus sets the terminal status of the job. The first one wins: if the
+	// job is already done, ErrCompareFailed of etcdutil is returned.
+	SetJobStatus(job string, status JobStatus) error
+	// WatchJobStatus calls handler once the job is done, i.e. its status becomes
+	// terminal, unless stop comes first.
+	WatchJobStatus(job string, stop chan bool, handler func(status JobStatus)) error
+
+	// Close releases resources held by the coordinator.
+	Close() error
+}
+
+// JobInfo is what a coordinator knows about a job, which helps to tell whether
+// it's abandoned.
+type JobInfo struct {
+	Name string
+	// number of tasks and workers whose sessions are alive.
+	LiveTasks int
+	Status    JobStatus
+	// last time the job is touched. Zero if never.
+	LastHeartbeat time.Time
+}
+
+// JobDetail is a snapshot of a job and its tasks, which is what an operator
+// looks at.
+type JobDetail struct {
+	JobInfo
+	Epoch uint64
+	// number of tasks at the epoch. Zero if the job never records its size.
+	NumOfTasks uint64
+	// sorted by ID.
+	Tasks []TaskInfo
+}
+
+// TaskInfo is the state of a task.
+type TaskInfo struct {
+	ID uint64
+	// address of the node working for the task. Empty if none.
+	Address string
+	// whether the session of the node is alive.
+	Healthy bool
+	// whether the task is waiting to be taken over.
+	Free bool
+	// addresses of the backups of the task, by replica ID.
+	Backups map[uint64]string
+	// latest meta of each link type, prefixed by the epoch it's set at, e.g.
+	// 3-ready. Link types without meta are left out.
+	Metas map[string]string
+}
+
+// TaskCheckpoint is the snapshot of a task saved at an epoch.
+type TaskCheckpoint struct {
+	TaskID uint64
+	// where the snapshot is in the filesystem.
+	Path string
+	// CRC-32 (IEEE) of the snapshot, in hex.
+	Checksum string
+}
+
+// CheckpointManifest is a global checkpoint at the start of an epoch. It's
+// committed once every task at the epoch has reported its snapshot.
+type CheckpointManifest struct {
+	Epoch uint64
+	// sorted by task ID.
+	Tasks []TaskCheckpoint
+}
@@ -8,7 +8,7 @@ import (
 	"os"
 	"strings"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/taskgraph/taskgraph/controller"
 	"github.com/taskgraph/taskgraph/example/bwmf"
 	"github.com/taskgraph/taskgraph/example/topo"
@@ -23,6 +23,7 @@ func main() {
 	jobType := flag.String("job_type", "c", "Job type, either 'c' for controller or 't' for task.")
 	numTasks := flag.Int("num_tasks", 1, "Num of tasks.")
 	taskConfigFile := flag.String("task_config", "", "Path to task config json file.")
+	checkpointDir := flag.String("checkpoint_dir", "", "Where tasks save checkpoints, on the filesystem of task config. No checkpoint if empty.")
 
 	flag.Parse()
 
@@ -46,11 +47,23 @@ func main() {
 	etcdUrls := strings.Split(*etcdUrlList, ",")
 	log.Println("etcd urls: ", etcdUrls)
 
-	topo := topo.NewFullTopology(uint64(*numTasks))
+	topo := topo.NewRowColumnTopology(uint64(*numTasks))
 
 	switch *jobType {
 	case "t":
-		bootstrap := framework.NewBootStrap(*jobName, etcdUrls, createListener(), nil)
+		var opts []framework.Option
+		if *checkpointDir != "" {
+			config, err := bwmf.Parse(confData)
+			if err != nil {
+				log.Fatalf("Failed parsing task config. %s", err)
+			}
+			fsClient, err := bwmf.NewFsClient(config)
+			if err != nil {
+				log.Fatalf("Failed creating filesystem client. %s", err)
+			}
+			opts = append(opts, framework.WithCheckpoint(fsClient, *checkpointDir))
+		}
+		bootstrap := framework.NewBootStrapWithOptions(*jobName, etcdUrls, createListener(), nil, opts...)
 		taskBuilder := &bwmf.BWMFTaskBuilder{
 			NumOfTasks: uint64(*numTasks),
 			ConfBytes:  confData,
@@ -58,13 +71,23 @@ func main() {
 		bootstrap.SetTaskBuilder(taskBuilder)
 		bootstrap.SetTopology(topo)
 		log.Println("Starting task..")
-		bootstrap.Start()
+		if err := bootstrap.Start(); err != nil {
+			log.Fatalf("bootstrap.Start failed: %v", err)
+		}
 	case "c":
-		controller := controller.New(*jobName, etcd.NewClient(etcdUrls), uint64(*numTasks), topo.GetLinkTypes())
+		etcdClient, err := clientv3.New(clientv3.Config{Endpoints: etcdUrls})
+		if err != nil {
+			log.Fatalf("connect to etcd failed: %v", err)
+		}
+		controller := controller.New(*jobName, etcdClient, uint64(*numTasks), topo.GetLinkTypes())
 		controller.Start()
 		log.Println("Controller started.")
-		controller.WaitForJobDone()
+		status, err := controller.WaitForJobDone()
 		controller.Stop()
+		if err != nil {
+			log.Fatalf("Job %s: %v", *jobName, err)
+		}
+		log.Printf("Job %s %s.", *jobName, status)
 	default:
 		log.Fatal("Please choose a type via '-jobtype': (c) controller, (t) task")
 	}
@@ -28,15 +28,16 @@ will read two copies of the data: one row shard and one column shard of A. It ei
 one shard of D and a full copy of T, or one shard of T and a full copy of D, depending on
 the epoch of iteration. "A full copy" consists of computation results from itself and
 all "children".
-Topology: the topology is different from task to task. Each task will consider itself parent
-and all others children.
+Topology: the row/column topology. At even epochs each task links to all tasks by "Rows",
+and fetches their D shards; at odd epochs by "Columns", and fetches their T shards.
 */
 
 // bwmfTasks holds two shards of original matrices (row and column), one shard of D,
 // and one shard of T. It works differently for odd and even epoch:
 // During odd epoch, 1. it fetch all T from other slaves, and finding better value for
-// local shard of D; 2. after it is done, it let every one knows. Vice versa for even epoch.
-// Task 0 will monitor the progress and responsible for starting the work of new epoch.
+// local shard of D; 2. after it is done, it tells the framework, which starts the next
+// epoch once all tasks are done. Vice versa for even epoch. Task 0 shuts the job down
+// once the last iteration is done.
 type bwmfTask struct {
 	framework  taskgraph.Framework
 	epoch      uint64
@@ -50,8 +51,7 @@ type bwmfTask struct {
 	dShard      *pb.MatrixShard
 	tShard      *pb.MatrixShard
 
-	peerShards  map[uint64]*pb.MatrixShard
-	peerUpdated map[uint64]bool
+	peerShards map[uint64]*pb.MatrixShard
 
 	dims   *dimensions
 	config *Config
@@ -72,7 +72,6 @@ type bwmfTask struct {
 	getD        chan *event
 	dataReady   chan *event
 	updateDone  chan *event
-	metaReady   chan *event
 	exitChan    chan *event
 }
 
@@ -159,11 +158,7 @@ func (t *bwmfTask) initOptUtil() {
 		}
 	}
 	// assign loaded tshard to tparam
-	for r, m := range t.tShard.GetRow() {
-		for c, v := range m.At {
-			t.tParam.Set(r*t.dims.k+int(c), v)
-		}
-	}
+	copyShardToParam(t.tShard, t.tParam, t.dims.k)
 
 	if t.dShard == nil {
 		// XXX: Initial at 0.0.
@@ -182,11 +177,7 @@ func (t *bwmfTask) initOptUtil() {
 		}
 	}
 	// assign loaded dshard to dparam
-	for r, m := range t.dShard.GetRow() {
-		for c, v := range m.At {
-			t.dParam.Set(r*t.dims.k+int(c), v)
-		}
-	}
+	copyShardToParam(t.dShard, t.dParam, t.dims.k)
 
 	projLen := 0
 	if tParamLen > dParamLen {
@@ -220,11 +211,33 @@ func (t *bwmfTask) Init(taskID uint64, framework taskgraph.Framework) {
 	t.getD = make(chan *event, 1)
 	t.dataReady = make(chan *event, t.numOfTasks)
 	t.updateDone = make(chan *event, 1)
-	t.metaReady = make(chan *event, 1)
 	t.exitChan = make(chan *event)
 	go t.run()
 }
 
+// Snapshot saves the learned shards, which are all that's carried across epochs.
+func (t *bwmfTask) Snapshot() ([]byte, error) {
+	return proto.Marshal(&pb.Checkpoint{DShard: t.dShard, TShard: t.tShard})
+}
+
+// Restore takes the learned shards of a checkpoint in place of the initial ones.
+func (t *bwmfTask) Restore(snapshot []byte) {
+	checkpoint := new(pb.Checkpoint)
+	if err := proto.Unmarshal(snapshot, checkpoint); err != nil {
+		t.logger.Printf("Restore failed, starting from initial shards: %v", err)
+		return
+	}
+	if len(checkpoint.DShard.GetRow()) != t.dims.m || len(checkpoint.TShard.GetRow()) != t.dims.n {
+		t.logger.Printf("Restore failed, dimension mismatch. Starting from initial shards.")
+		return
+	}
+	t.dShard, t.tShard = checkpoint.DShard, checkpoint.TShard
+	t.dParam = op.NewVecParameter(t.dims.m * t.dims.k)
+	t.tParam = op.NewVecParameter(t.dims.n * t.dims.k)
+	copyShardToParam(t.dShard, t.dParam, t.dims.k)
+	copyShardToParam(t.tShard, t.tParam, t.dims.k)
+}
+
 func (t *bwmfTask) getDShard() *pb.Response {
 	return &pb.Response{
 		BlockId: t.taskID,
@@ -264,9 +277,7 @@ func (t *bwmfTask) run() {
 		case dataReady := <-t.dataReady:
 			t.doDataReady(dataReady.ctx, dataReady.fromID, dataReady.method, dataReady.output)
 		case done := <-t.updateDone:
-			t.notifyMaster(done.ctx)
-		case notify := <-t.metaReady:
-			t.notifyUpdate(notify.ctx, notify.fromID)
+			t.epochDone(done.ctx)
 		case <-t.exitChan:
 			return
 		}
@@ -300,8 +311,17 @@ func (t *bwmfTask) EnterEpoch(ctx context.Context, epoch uint64) {
 func (t *bwmfTask) doEnterEpoch(ctx context.Context, epoch uint64) {
 	t.logger.Printf("doEnterEpoch, task %d, epoch %d", t.taskID, epoch)
 	t.peerShards = make(map[uint64]*pb.MatrixShard)
-	t.peerUpdated = make(map[uint64]bool)
 	t.epoch = epoch
+	// all tasks are done with the last iteration.
+	if epoch > 2*t.config.OptConf.NumIters {
+		if t.taskID == 0 {
+			t.logger.Printf("All iterations done, epoch %d", epoch)
+			if err := t.framework.ShutdownJob(); err != nil {
+				t.logger.Printf("ShutdownJob failed: %v", err)
+			}
+		}
+		return
+	}
 	t.stopCriteria = op.MakeComposedCriterion(
 		op.MakeFixCountStopCriteria(t.config.OptConf.FixedCnt),
 		op.MakeGradientNormStopCriteria(t.config.OptConf.GradTol),
@@ -309,14 +329,14 @@ func (t *bwmfTask) doEnterEpoch(ctx context.Context, epoch uint64) {
 	)
 
 	if epoch%2 == 0 {
-		t.fetchShards(ctx, "/proto.BlockData/GetDShard")
+		t.fetchShards(ctx, "Rows", "/proto.BlockData/GetDShard")
 	} else {
-		t.fetchShards(ctx, "/proto.BlockData/GetTShard")
+		t.fetchShards(ctx, "Columns", "/proto.BlockData/GetTShard")
 	}
 }
 
-func (t *bwmfTask) fetchShards(ctx context.Context, method string) {
-	peers := t.framework.GetTopology().GetNeighbors("Neighbors", t.epoch)
+func (t *bwmfTask) fetchShards(ctx context.Context, linkType, method string) {
+	peers := t.framework.GetTopology().GetNeighbors(linkType, t.epoch)
 	for _, peer := range peers {
 		t.framework.DataRequest(ctx, peer, method, &pb.Request{})
 	}
@@ -326,6 +346,14 @@ func (t *bwmfTask) DataReady(ctx context.Context, fromID uint64, method string,
 	t.dataReady <- &event{ctx: ctx, fromID: fromID, method: method, output: output}
 }
 
+func (t *bwmfTask) DataFailed(ctx context.Context, toID uint64, method string, err error) {
+	t.logger.Printf("data request %s to task %d failed: %v", method, toID, err)
+}
+
+func (t *bwmfTask) OnFrameworkError(err error) {
+	t.logger.Printf("framework error: %v", err)
+}
+
 func (t *bwmfTask) doDataReady(ctx context.Context, fromID uint64, method string, output proto.Message) {
 	t.logger.Printf("doDataReady, task %d, from %d, epoch %d, method %s", t.taskID, fromID, t.epoch, method)
 	resp, bOk := output.(*pb.Response)
@@ -363,9 +391,8 @@ func (t *bwmfTask) updateDShard(ctx context.Context) {
 
 	loss, optErr := t.optimizer.Minimize(t.dLoss, t.stopCriteria, t.dParam)
 	if optErr != nil {
-		t.logger.Panicf("Failed minimizing over dShard: %s", optErr)
-		// handle re-run
-		// XXX(baigang) just kill the framework and wait for restarting?
+		t.failJob(fmt.Sprintf("failed minimizing over dShard: %v", optErr))
+		return
 	}
 
 	// save dParam to dShard
@@ -392,7 +419,8 @@ func (t *bwmfTask) updateTShard(ctx context.Context) {
 
 	loss, optErr := t.optimizer.Minimize(t.tLoss, t.stopCriteria, t.tParam)
 	if optErr != nil {
-		t.logger.Panicf("Failed minimizing over tShard:", optErr)
+		t.failJob(fmt.Sprintf("failed minimizing over tShard: %v", optErr))
+		return
 	}
 
 	// save tParam to tShard
@@ -403,6 +431,14 @@ func (t *bwmfTask) updateTShard(ctx context.Context) {
 	t.updateDone <- &event{ctx: ctx}
 }
 
+// failJob aborts the whole job. Rerunning the same optimization won't help.
+func (t *bwmfTask) failJob(reason string) {
+	t.logger.Printf("task %d fails the job: %s", t.taskID, reason)
+	if err := t.framework.FailJob(reason); err != nil {
+		t.logger.Printf("FailJob failed: %v", err)
+	}
+}
+
 func copyParamToShard(param op.Parameter, shard *pb.MatrixShard, k int) {
 	for iter := param.IndexIterator(); iter.Next(); {
 		index := iter.Index()
@@ -417,8 +453,18 @@ func copyParamToShard(param op.Parameter, shard *pb.MatrixShard, k int) {
 	}
 }
 
-func (t *bwmfTask) notifyMaster(ctx context.Context) {
-	t.framework.FlagMeta(ctx, "Master", "done")
+func copyShardToParam(shard *pb.MatrixShard, param op.Parameter, k int) {
+	for r, m := range shard.GetRow() {
+		for c, v := range m.At {
+			param.Set(r*k+int(c), v)
+		}
+	}
+}
+
+func (t *bwmfTask) epochDone(ctx context.Context) {
+	if err := t.framework.EpochDone(ctx); err != nil {
+		t.logger.Printf("EpochDone failed: %v", err)
+	}
 }
 
 func (t *bwmfTask) CreateOutputMessage(method string) proto.Message {
@@ -457,19 +503,4 @@ func (t *bwmfTask) GetDShard(ctx context.Context, request *pb.Request) (*pb.Resp
 	return resp, nil
 }
 
-func (t *bwmfTask) MetaReady(ctx context.Context, fromID uint64, linkType, meta string) {
-	t.metaReady <- &event{ctx: ctx, fromID: fromID}
-}
-
-func (t *bwmfTask) notifyUpdate(ctx context.Context, fromID uint64) {
-	t.logger.Printf("notifyUpdate, task %d, from %d, epoch %d", t.taskID, fromID, t.epoch)
-	t.peerUpdated[fromID] = true
-	if len(t.peerUpdated) == int(t.numOfTasks) {
-		t.logger.Printf("All tasks update done, epoch %d", t.epoch)
-		if t.epoch < 2*t.config.OptConf.NumIters {
-			t.framework.IncEpoch(ctx)
-		} else {
-			t.framework.ShutdownJob()
-		}
-	}
-}
+func (t *bwmfTask) MetaReady(ctx context.Context, fromID uint64, linkType, meta string) {}
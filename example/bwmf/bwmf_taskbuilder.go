@@ -20,38 +20,39 @@ func (tb BWMFTaskBuilder) GetTask(taskID uint64) taskgraph.Task {
 		panic(unmarshErr)
 	}
 
-	var client filesystem.Client
-	var cltErr error
+	client, cltErr := NewFsClient(config)
+	if cltErr != nil {
+		panic(cltErr)
+	}
+
+	return &bwmfTask{
+		numOfTasks: tb.NumOfTasks,
+		config:     config,
+		fsClient:   client,
+	}
+}
+
+// NewFsClient returns the client of the filesystem chosen by config, which is
+// also where checkpoints can go.
+func NewFsClient(config *Config) (filesystem.Client, error) {
 	switch config.IOConf.Fs {
 	case "local":
-		client = filesystem.NewLocalFSClient()
+		return filesystem.NewLocalFSClient(), nil
 	case "hdfs":
-		client, cltErr = filesystem.NewHdfsClient(
+		return filesystem.NewHdfsClient(
 			config.IOConf.HdfsConf.NamenodeAddr,
 			config.IOConf.HdfsConf.WebHdfsAddr,
 			config.IOConf.HdfsConf.User,
 		)
-		if cltErr != nil {
-			panic(cltErr)
-		}
 	case "azure":
-		client, cltErr = filesystem.NewAzureClient(
+		return filesystem.NewAzureClient(
 			config.IOConf.AzureConf.AccountName,
 			config.IOConf.AzureConf.AccountKey,
 			config.IOConf.AzureConf.BlogServiceBaseUrl,
 			config.IOConf.AzureConf.ApiVersion,
 			config.IOConf.AzureConf.UseHttps,
 		)
-		if cltErr != nil {
-			panic(cltErr)
-		}
 	default:
-		panic(fmt.Errorf("Unknow fs: %s", config.IOConf.Fs))
-	}
-
-	return &bwmfTask{
-		numOfTasks: tb.NumOfTasks,
-		config:     config,
-		fsClient:   client,
+		return nil, fmt.Errorf("Unknow fs: %s", config.IOConf.Fs)
 	}
 }
@@ -12,6 +12,7 @@ It has these top-level messages:
 	Request
 	Response
 	MatrixShard
+	Checkpoint
 */
 package proto
 
@@ -83,6 +84,30 @@ func (m *MatrixShard_RowData) GetAt() map[int32]float32 {
 	return nil
 }
 
+// learned shards of a task, saved by framework as its checkpoint.
+type Checkpoint struct {
+	DShard *MatrixShard `protobuf:"bytes,1,opt,name=dShard" json:"dShard,omitempty"`
+	TShard *MatrixShard `protobuf:"bytes,2,opt,name=tShard" json:"tShard,omitempty"`
+}
+
+func (m *Checkpoint) Reset()         { *m = Checkpoint{} }
+func (m *Checkpoint) String() string { return proto1.CompactTextString(m) }
+func (*Checkpoint) ProtoMessage()    {}
+
+func (m *Checkpoint) GetDShard() *MatrixShard {
+	if m != nil {
+		return m.DShard
+	}
+	return nil
+}
+
+func (m *Checkpoint) GetTShard() *MatrixShard {
+	if m != nil {
+		return m.TShard
+	}
+	return nil
+}
+
 func init() {
 }
 
@@ -5,7 +5,7 @@ import (
 	"log"
 	"net"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/taskgraph/taskgraph/controller"
 	"github.com/taskgraph/taskgraph/example/regression"
 	"github.com/taskgraph/taskgraph/example/topo"
@@ -15,7 +15,7 @@ import (
 func main() {
 	programType := flag.String("type", "", "(c) controller or (t) task")
 	job := flag.String("job", "", "job name")
-	etcdURLs := []string{"http://localhost:4001"}
+	etcdURLs := []string{"http://localhost:2379"}
 	flag.Parse()
 
 	if *job == "" {
@@ -26,7 +26,11 @@ func main() {
 	switch *programType {
 	case "c":
 		log.Printf("controller")
-		controller := controller.New(*job, etcd.NewClient(etcdURLs), ntask)
+		etcdClient, err := clientv3.New(clientv3.Config{Endpoints: etcdURLs})
+		if err != nil {
+			log.Fatalf("connect to etcd failed: %v", err)
+		}
+		controller := controller.New(*job, etcdClient, ntask, topo.NewTreeTopology(2, ntask).GetLinkTypes())
 		controller.Start()
 		controller.WaitForJobDone()
 	case "t":
@@ -39,7 +43,9 @@ func main() {
 		}
 		bootstrap.SetTaskBuilder(taskBuilder)
 		bootstrap.SetTopology(topo.NewTreeTopology(2, ntask))
-		bootstrap.Start()
+		if err := bootstrap.Start(); err != nil {
+			log.Fatalf("bootstrap.Start failed: %v", err)
+		}
 	default:
 		log.Fatal("Please choose a type: (c) controller, (t) task")
 	}
@@ -5,7 +5,6 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"strconv"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/taskgraph/taskgraph"
@@ -21,7 +20,6 @@ import (
 // add error checking in the right places. We will skip these test for now.
 type dummyMaster struct {
 	dataChan           chan int32
-	NodeProducer       chan bool
 	framework          taskgraph.Framework
 	epoch, taskID      uint64
 	logger             *log.Logger
@@ -90,9 +88,6 @@ func (t *dummyMaster) Exit() {
 
 // This give the task an opportunity to cleanup and regroup.
 func (t *dummyMaster) EnterEpoch(ctx context.Context, epoch uint64) {
-	if t.testablyFail("SetEpoch", strconv.FormatUint(epoch, 10)) {
-		return
-	}
 	t.epochChange <- &event{ctx: ctx, epoch: epoch}
 }
 
@@ -129,6 +124,14 @@ func (t *dummyMaster) DataReady(ctx context.Context, fromID uint64, method strin
 	panic("")
 }
 
+func (t *dummyMaster) DataFailed(ctx context.Context, toID uint64, method string, err error) {
+	t.logger.Printf("master data request %s to task %d failed: %v", method, toID, err)
+}
+
+func (t *dummyMaster) OnFrameworkError(err error) {
+	t.logger.Printf("master framework error: %v", err)
+}
+
 func (t *dummyMaster) gradientReady(ctx context.Context) {
 	// In testing, we need to make sure dataChan has enough space and don't block.
 	t.dataChan <- t.gradient.Value
@@ -138,10 +141,14 @@ func (t *dummyMaster) gradientReady(ctx context.Context) {
 			data := []byte(fmt.Sprintf("Finished job. Gradient value %v\n", t.gradient.Value))
 			ioutil.WriteFile(t.config["writefile"], data, 0644)
 		}
-		t.framework.ShutdownJob()
+		if err := t.framework.ShutdownJob(); err != nil {
+			t.logger.Printf("ShutdownJob failed: %v", err)
+		}
 	} else {
 		t.logger.Printf("master finished current epoch, task %d, epoch %d", t.taskID, t.epoch)
-		t.framework.IncEpoch(ctx)
+		if err := t.framework.IncEpoch(ctx); err != nil {
+			t.logger.Printf("IncEpoch failed: %v", err)
+		}
 	}
 }
 
@@ -185,27 +192,4 @@ func (t *dummyMaster) CreateServer() *grpc.Server {
 	return server
 }
 
-func (t *dummyMaster) testablyFail(method string, args ...string) bool {
-	if t.config == nil {
-		return false
-	}
-	if t.config[method] != "fail" {
-		return false
-	}
-	if len(args) >= 1 && t.config["failepoch"] != "" {
-		// we need to care about fail at specific epoch
-		if t.config["failepoch"] != args[0] {
-			return false
-		}
-	}
-	if !probablyFail(t.config["faillevel"]) {
-		return false
-	}
-	t.logger.Printf("master task %d testably fail, method %s\n", t.taskID, method)
-	// Very hack. Need some internal knowledge. Don't change this.
-	t.framework.Kill()
-	t.NodeProducer <- true
-	return true
-}
-
 func (t *dummyMaster) MetaReady(ctx context.Context, fromID uint64, linkType, meta string) {}
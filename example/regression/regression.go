@@ -1,9 +1,6 @@
 package regression
 
 import (
-	"math/rand"
-	"strconv"
-
 	"github.com/taskgraph/taskgraph"
 )
 
@@ -24,9 +21,8 @@ job.
 type SimpleTaskBuilder struct {
 	GDataChan          chan int32
 	NumberOfIterations uint64
-	NodeProducer       chan bool
-	MasterConfig       map[string]string
-	SlaveConfig        map[string]string
+	// MasterConfig["writefile"] is where master writes the result to.
+	MasterConfig map[string]string
 }
 
 // This method is called once by framework implementation to get the
@@ -36,24 +32,9 @@ func (tc SimpleTaskBuilder) GetTask(taskID uint64) taskgraph.Task {
 	if taskID == 0 {
 		return &dummyMaster{
 			dataChan:           tc.GDataChan,
-			NodeProducer:       tc.NodeProducer,
 			config:             tc.MasterConfig,
 			numberOfIterations: tc.NumberOfIterations,
 		}
 	}
-	return &dummySlave{
-		NodeProducer: tc.NodeProducer,
-		config:       tc.SlaveConfig,
-	}
-}
-
-func probablyFail(levelStr string) bool {
-	level, err := strconv.Atoi(levelStr)
-	if err != nil {
-		return false
-	}
-	if level < rand.Intn(100)+1 {
-		return false
-	}
-	return true
+	return &dummySlave{}
 }
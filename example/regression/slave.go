@@ -20,8 +20,6 @@ type dummySlave struct {
 	framework     taskgraph.Framework
 	epoch, taskID uint64
 	logger        *log.Logger
-	NodeProducer  chan bool
-	config        map[string]string
 
 	param        *pb.Parameter
 	gradient     *pb.Gradient
@@ -160,21 +158,10 @@ func (t *dummySlave) parameterReady() {
 }
 func (t *dummySlave) gradientReady(ctx context.Context) {
 	t.logger.Printf("slave gradient ready, task %d, epoch %d, gradient %d", t.taskID, t.epoch, t.gradient.Value)
-	// If this failure happens, a new node will redo computing again.
-	if t.testablyFail("ChildDataReady") {
-		return
-	}
 	for _, req := range t.getGReqs {
 		req.retG <- t.gradient
 	}
 	t.getGReqs = nil
-	// if this failure happens, the parent could
-	// 1. not get the data. DataRequest shall retry.
-	// 2. already get the data. Where everything will continue and some work will drop
-	//    if epoch bumps up.
-	if t.testablyFail("ChildDataReady") {
-		return
-	}
 }
 func (t *dummySlave) checkGradReady(ctx context.Context) {
 	children := t.framework.GetTopology().GetNeighbors("Children", t.epoch)
@@ -191,9 +178,6 @@ func (t *dummySlave) checkGradReady(ctx context.Context) {
 
 func (t *dummySlave) ParentDataReady(ctx context.Context, parentID uint64, output proto.Message) {
 	t.logger.Printf("slave ParentDataReady, task %d, epoch %d, parent %d\n", t.taskID, t.epoch, parentID)
-	if t.testablyFail("ParentDataReady") {
-		return
-	}
 	d, ok := output.(*pb.Parameter)
 	if !ok {
 		t.logger.Fatalf("Can't convert proto message to Gradient: %v", output)
@@ -223,22 +207,14 @@ func (t *dummySlave) DataReady(ctx context.Context, fromID uint64, method string
 	}
 }
 
-func (t *dummySlave) testablyFail(method string, args ...string) bool {
-	if t.config == nil {
-		return false
-	}
-	if t.config[method] != "fail" {
-		return false
-	}
-	if !probablyFail(t.config["faillevel"]) {
-		return false
-	}
-	t.logger.Printf("slave task %d testably fail, method: %s\n", t.taskID, method)
-	// Very hack. Need some internal knowledge. Don't change this.
-	t.framework.Kill()
-	t.NodeProducer <- true
-	return true
+func (t *dummySlave) DataFailed(ctx context.Context, toID uint64, method string, err error) {
+	t.logger.Printf("slave data request %s to task %d failed: %v", method, toID, err)
+}
+
+func (t *dummySlave) OnFrameworkError(err error) {
+	t.logger.Printf("slave framework error: %v", err)
 }
+
 func (t *dummySlave) CreateOutputMessage(methodName string) proto.Message {
 	switch methodName {
 	case "/proto.Regression/GetParameter":
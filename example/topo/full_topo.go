@@ -37,6 +37,12 @@ func (t *FullTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
 	return res
 }
 
+// Resize changes the number of tasks. Neighbors are rebuilt for the new ones.
+func (t *FullTopology) Resize(numOfTasks uint64) {
+	t.numOfTasks = numOfTasks
+	t.SetTaskID(t.taskID)
+}
+
 // Creates a new tree topology with given fanout and number of tasks.
 // This will be called during the task graph configuration.
 func NewFullTopology(nTasks uint64) *FullTopology {
@@ -43,3 +43,15 @@ func TestFullTopogoly(t *testing.T) {
 		t.Error()
 	}
 }
+
+func TestFullTopologyResize(t *testing.T) {
+	topo := NewFullTopology(2)
+	topo.SetTaskID(0)
+	topo.Resize(3)
+	if n := topo.GetNeighbors("Neighbors", 1); len(n) != 3 || n[2] != 2 {
+		t.Errorf("neighbors want = [0 1 2], get = %v", n)
+	}
+	if m := topo.GetNeighbors("Master", 1); len(m) != 3 {
+		t.Errorf("master neighbors want = [0 1 2], get = %v", m)
+	}
+}
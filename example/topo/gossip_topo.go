@@ -0,0 +1,56 @@
+package topo
+
+import "math/rand"
+
+// The gossip structure links each task to a few random peers by "Peers", which
+// change every epoch. Peers are drawn from a source seeded by the seed, the epoch
+// and the task, so that any node working for the task, e.g. one taking it over,
+// gets the same peers at the same epoch.
+type GossipTopology struct {
+	fanout, numOfTasks uint64
+	taskID             uint64
+	seed               int64
+}
+
+func (t *GossipTopology) SetTaskID(taskID uint64) {
+	t.taskID = taskID
+}
+
+func (t *GossipTopology) GetLinkTypes() []string {
+	return []string{"Peers"}
+}
+
+func (t *GossipTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	res := make([]uint64, 0, t.fanout)
+	if linkType != "Peers" || t.numOfTasks < 2 {
+		return res
+	}
+	r := rand.New(rand.NewSource(t.seed + int64(epoch*t.numOfTasks+t.taskID)))
+	// pick from all tasks but this one.
+	for _, index := range r.Perm(int(t.numOfTasks - 1)) {
+		if uint64(len(res)) == t.fanout {
+			break
+		}
+		peer := uint64(index)
+		if peer >= t.taskID {
+			peer++
+		}
+		res = append(res, peer)
+	}
+	return res
+}
+
+// Resize changes the number of tasks. Peers are drawn from the new ones.
+func (t *GossipTopology) Resize(numOfTasks uint64) {
+	t.numOfTasks = numOfTasks
+}
+
+// Creates a new gossip topology, where each task has fanout peers at each epoch,
+// or all other tasks if there are fewer.
+func NewGossipTopology(fanout, nTasks uint64, seed int64) *GossipTopology {
+	return &GossipTopology{
+		fanout:     fanout,
+		numOfTasks: nTasks,
+		seed:       seed,
+	}
+}
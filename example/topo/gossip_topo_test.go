@@ -0,0 +1,50 @@
+package topo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGossipTopology(t *testing.T) {
+	topo := NewGossipTopology(2, 6, 1)
+	topo.SetTaskID(4)
+	// another node taking over task 4.
+	other := NewGossipTopology(2, 6, 1)
+	other.SetTaskID(4)
+
+	changed := false
+	prev := topo.GetNeighbors("Peers", 0)
+	for epoch := uint64(0); epoch < 10; epoch++ {
+		peers := topo.GetNeighbors("Peers", epoch)
+		if len(peers) != 2 || peers[0] == peers[1] {
+			t.Errorf("epoch %d: want 2 distinct peers, get = %v", epoch, peers)
+		}
+		for _, p := range peers {
+			if p == 4 || p >= 6 {
+				t.Errorf("epoch %d: unexpected peer %d", epoch, p)
+			}
+		}
+		if o := other.GetNeighbors("Peers", epoch); !reflect.DeepEqual(peers, o) {
+			t.Errorf("epoch %d: peers of same task differ, %v and %v", epoch, peers, o)
+		}
+		if !reflect.DeepEqual(peers, prev) {
+			changed = true
+		}
+		prev = peers
+	}
+	if !changed {
+		t.Errorf("peers never change between epochs")
+	}
+}
+
+func TestGossipTopologyResize(t *testing.T) {
+	topo := NewGossipTopology(3, 2, 1)
+	topo.SetTaskID(0)
+	if n := topo.GetNeighbors("Peers", 0); len(n) != 1 || n[0] != 1 {
+		t.Errorf("peers of 2 tasks want = [1], get = %v", n)
+	}
+	topo.Resize(1)
+	if n := topo.GetNeighbors("Peers", 1); len(n) != 0 {
+		t.Errorf("peers of a single task want = [], get = %v", n)
+	}
+}
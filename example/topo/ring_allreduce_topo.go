@@ -0,0 +1,59 @@
+package topo
+
+// The ring all-reduce structure passes partial results around the ring by
+// recursive doubling. At epoch e each task sends to task (id + 2^e) mod n by
+// "Send", and receives from task (id - 2^e) mod n by "Receive", so that every
+// task has heard from all others after ceil(log2(n)) epochs. The schedule then
+// starts over, i.e. the step at epoch e is 2^(e mod ceil(log2(n))).
+type RingAllReduceTopology struct {
+	numOfTasks uint64
+	taskID     uint64
+}
+
+func (t *RingAllReduceTopology) SetTaskID(taskID uint64) {
+	t.taskID = taskID
+}
+
+func (t *RingAllReduceTopology) GetLinkTypes() []string {
+	return []string{"Send", "Receive"}
+}
+
+func (t *RingAllReduceTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	res := make([]uint64, 0)
+	step := t.step(epoch)
+	if step == 0 {
+		return res
+	}
+	switch {
+	case linkType == "Send":
+		res = append(res, (t.taskID+step)%t.numOfTasks)
+	case linkType == "Receive":
+		res = append(res, (t.taskID+t.numOfTasks-step)%t.numOfTasks)
+	}
+	return res
+}
+
+// step returns the distance to the neighbors at the epoch, which is 0 if there
+// is no other task.
+func (t *RingAllReduceTopology) step(epoch uint64) uint64 {
+	rounds := uint64(0)
+	for uint64(1)<<rounds < t.numOfTasks {
+		rounds++
+	}
+	if rounds == 0 {
+		return 0
+	}
+	return uint64(1) << (epoch % rounds)
+}
+
+// Resize changes the number of tasks, and so the schedule.
+func (t *RingAllReduceTopology) Resize(numOfTasks uint64) {
+	t.numOfTasks = numOfTasks
+}
+
+// Creates a new ring all-reduce topology with given number of tasks.
+func NewRingAllReduceTopology(nTasks uint64) *RingAllReduceTopology {
+	return &RingAllReduceTopology{
+		numOfTasks: nTasks,
+	}
+}
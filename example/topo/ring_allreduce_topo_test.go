@@ -0,0 +1,82 @@
+package topo
+
+import "testing"
+
+// With 5 tasks, steps are 1, 2, 4 and then over again.
+func TestRingAllReduceTopology(t *testing.T) {
+	topo := NewRingAllReduceTopology(5)
+	topo.SetTaskID(3)
+
+	wantSend := []uint64{4, 0, 2, 4}
+	wantReceive := []uint64{2, 1, 4, 2}
+	for epoch := uint64(0); epoch < 4; epoch++ {
+		send := topo.GetNeighbors("Send", epoch)
+		if len(send) != 1 || send[0] != wantSend[epoch] {
+			t.Errorf("epoch %d: send want = [%d], get = %v", epoch, wantSend[epoch], send)
+		}
+		receive := topo.GetNeighbors("Receive", epoch)
+		if len(receive) != 1 || receive[0] != wantReceive[epoch] {
+			t.Errorf("epoch %d: receive want = [%d], get = %v", epoch, wantReceive[epoch], receive)
+		}
+	}
+}
+
+// Each task receives from the one that sends to it, and has heard from all tasks
+// once a round of the schedule is over.
+func TestRingAllReduceTopologyRound(t *testing.T) {
+	for _, n := range []uint64{2, 3, 4, 5, 8, 9} {
+		topos := make([]*RingAllReduceTopology, n)
+		heard := make([]map[uint64]bool, n)
+		for i := range topos {
+			topos[i] = NewRingAllReduceTopology(n)
+			topos[i].SetTaskID(uint64(i))
+			heard[i] = map[uint64]bool{uint64(i): true}
+		}
+		epoch := uint64(0)
+		for ; !heardAll(heard); epoch++ {
+			next := make([]map[uint64]bool, n)
+			for i, topo := range topos {
+				next[i] = make(map[uint64]bool)
+				for id := range heard[i] {
+					next[i][id] = true
+				}
+				to := topo.GetNeighbors("Send", epoch)[0]
+				if from := topos[to].GetNeighbors("Receive", epoch)[0]; from != uint64(i) {
+					t.Fatalf("%d tasks, epoch %d: task %d sends to %d, which receives from %d", n, epoch, i, to, from)
+				}
+				for id := range heard[topo.GetNeighbors("Receive", epoch)[0]] {
+					next[i][id] = true
+				}
+			}
+			heard = next
+		}
+		rounds := uint64(0)
+		for uint64(1)<<rounds < n {
+			rounds++
+		}
+		if epoch != rounds {
+			t.Errorf("%d tasks: all heard after %d epochs, want %d", n, epoch, rounds)
+		}
+	}
+}
+
+func heardAll(heard []map[uint64]bool) bool {
+	for _, h := range heard {
+		if len(h) < len(heard) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRingAllReduceTopologyResize(t *testing.T) {
+	topo := NewRingAllReduceTopology(1)
+	topo.SetTaskID(0)
+	if n := topo.GetNeighbors("Send", 0); len(n) != 0 {
+		t.Errorf("send of a single task want = [], get = %v", n)
+	}
+	topo.Resize(4)
+	if n := topo.GetNeighbors("Send", 1); len(n) != 1 || n[0] != 2 {
+		t.Errorf("send of 4 tasks want = [2], get = %v", n)
+	}
+}
@@ -0,0 +1,47 @@
+package topo
+
+// The row/column structure alternates between two sets of links, for jobs that
+// work on row shards and column shards in turn, e.g. bwmf. At even epochs every
+// task links to all tasks by "Rows", and at odd epochs by "Columns". The other
+// set of links is empty.
+type RowColumnTopology struct {
+	numOfTasks uint64
+	taskID     uint64
+	all        []uint64
+}
+
+func (t *RowColumnTopology) SetTaskID(taskID uint64) {
+	t.all = make([]uint64, 0, t.numOfTasks)
+	t.taskID = taskID
+	for index := uint64(0); index < t.numOfTasks; index++ {
+		t.all = append(t.all, index)
+	}
+}
+
+func (t *RowColumnTopology) GetLinkTypes() []string {
+	return []string{"Rows", "Columns"}
+}
+
+func (t *RowColumnTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	res := make([]uint64, 0)
+	switch {
+	case linkType == "Rows" && epoch%2 == 0:
+		res = t.all
+	case linkType == "Columns" && epoch%2 == 1:
+		res = t.all
+	}
+	return res
+}
+
+// Resize changes the number of tasks. Neighbors are rebuilt for the new ones.
+func (t *RowColumnTopology) Resize(numOfTasks uint64) {
+	t.numOfTasks = numOfTasks
+	t.SetTaskID(t.taskID)
+}
+
+// Creates a new row/column topology with given number of tasks.
+func NewRowColumnTopology(nTasks uint64) *RowColumnTopology {
+	return &RowColumnTopology{
+		numOfTasks: nTasks,
+	}
+}
@@ -0,0 +1,28 @@
+package topo
+
+import "testing"
+
+func TestRowColumnTopology(t *testing.T) {
+	topo := NewRowColumnTopology(3)
+	topo.SetTaskID(1)
+
+	for epoch := uint64(0); epoch < 4; epoch++ {
+		rows := topo.GetNeighbors("Rows", epoch)
+		columns := topo.GetNeighbors("Columns", epoch)
+		active, idle := rows, columns
+		if epoch%2 == 1 {
+			active, idle = columns, rows
+		}
+		if len(active) != 3 || active[0] != 0 || active[2] != 2 {
+			t.Errorf("epoch %d: active neighbors want = [0 1 2], get = %v", epoch, active)
+		}
+		if len(idle) != 0 {
+			t.Errorf("epoch %d: idle neighbors want = [], get = %v", epoch, idle)
+		}
+	}
+
+	topo.Resize(4)
+	if n := topo.GetNeighbors("Columns", 5); len(n) != 4 || n[3] != 3 {
+		t.Errorf("neighbors want = [0 1 2 3], get = %v", n)
+	}
+}
@@ -94,3 +94,22 @@ func testTreeTopology(fanout, number uint64, tests []treeTopoTest, t *testing.T)
 		}
 	}
 }
+
+// Task 3 gets child 7 once the tree grows from 7 to 8 tasks, and loses it once
+// the tree shrinks back.
+func TestTreeTopologyResize(t *testing.T) {
+	topo := NewTreeTopology(2, 7)
+	topo.SetTaskID(3)
+	if n := len(topo.GetNeighbors("Children", 0)); n != 0 {
+		t.Errorf("children of 7 tasks, want = 0, get = %d", n)
+	}
+	topo.Resize(8)
+	children := topo.GetNeighbors("Children", 1)
+	if len(children) != 1 || children[0] != 7 {
+		t.Errorf("children of 8 tasks, want = [7], get = %v", children)
+	}
+	topo.Resize(7)
+	if n := len(topo.GetNeighbors("Children", 2)); n != 0 {
+		t.Errorf("children of 7 tasks, want = 0, get = %d", n)
+	}
+}
@@ -0,0 +1,553 @@
+package framework
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/framework/proto"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// A Backupable task can have hot-standby backups. The primary hands update logs to
+// framework by Update, which keeps them in order. Each backup pulls them in a
+// stream from the primary over the Backup service, and applies them to its own
+// copy. Once the primary's session expires, a backup takes over the task at once,
+// with state up to date except the last few logs it hasn't pulled.
+//
+// Logs of a task that's also Checkpointable are compacted into a snapshot once
+// there are maxUpdateLogs of them. A backup that needs logs compacted already, e.g.
+// one that comes later, restores the snapshot first. Logs of other tasks are
+// trimmed once every backup pulling them has acknowledged them, and no more than
+// maxUpdateLogs of them are kept in case some backup is gone. A backup that needs
+// logs trimmed already can't catch up, and fails.
+
+const (
+	maxUpdateLogs = 1000
+	// backups acknowledge logs every ackUpdateLogs logs they apply.
+	ackUpdateLogs = 100
+)
+
+var errUpdateLogTrimmed = errors.New("update log trimmed")
+
+// updateLogs are kept by both primary and backups, so that a backup taking over
+// can serve the whole history to other backups, including ones come later.
+type updateLogs struct {
+	mu sync.Mutex
+	// logs start from the one of seq offset. Those before are compacted into
+	// snapshot, or dropped if trimming.
+	offset   uint64
+	snapshot []byte
+	logs     [][]byte
+	trimming bool
+	// acked has the seq of the first log not acknowledged, by backup.
+	acked map[string]uint64
+	// closed and replaced once a log is appended.
+	added chan struct{}
+}
+
+// newUpdateLogs returns logs that are trimmed if trimming, or compacted otherwise.
+func newUpdateLogs(trimming bool) *updateLogs {
+	return &updateLogs{
+		trimming: trimming,
+		acked:    make(map[string]uint64),
+		added:    make(chan struct{}),
+	}
+}
+
+func (u *updateLogs) append(log []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.logs = append(u.logs, log)
+	close(u.added)
+	u.added = make(chan struct{})
+	u.trim()
+}
+
+// ack records that the backup has all logs before seq.
+func (u *updateLogs) ack(backup string, seq uint64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.acked[backup] = seq
+	u.trim()
+}
+
+// trim drops logs every backup has acknowledged, and the oldest ones beyond
+// maxUpdateLogs, if trimming. It's called with mu held.
+func (u *updateLogs) trim() {
+	if !u.trimming {
+		return
+	}
+	end := u.offset + uint64(len(u.logs))
+	to := u.offset
+	if len(u.acked) > 0 {
+		to = end
+		for _, seq := range u.acked {
+			if seq < to {
+				to = seq
+			}
+		}
+	}
+	if end-to > maxUpdateLogs {
+		to = end - maxUpdateLogs
+	}
+	if to <= u.offset {
+		return
+	}
+	n := to - u.offset
+	// Let dropped logs go before the slice is reallocated by append.
+	for i := uint64(0); i < n; i++ {
+		u.logs[i] = nil
+	}
+	u.logs = u.logs[n:]
+	u.offset = to
+}
+
+// len returns the number of logs so far, including compacted or trimmed ones.
+func (u *updateLogs) len() uint64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.offset + uint64(len(u.logs))
+}
+
+// full tells whether there are enough logs to compact.
+func (u *updateLogs) full() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return len(u.logs) >= maxUpdateLogs
+}
+
+// compact drops all logs kept, given snapshot as the state of the task after them.
+func (u *updateLogs) compact(snapshot []byte) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.offset += uint64(len(u.logs))
+	u.logs = nil
+	u.snapshot = snapshot
+}
+
+// restore makes the logs start from the seq of s, with its snapshot as the state
+// before it.
+func (u *updateLogs) restore(s *pb.UpdateSnapshot) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.offset = s.Seq
+	u.logs = nil
+	u.snapshot = s.Snapshot
+}
+
+// snapshotFor returns the snapshot to restore before the log of seq, which is
+// Compacted if the log is. It fails if the log is trimmed.
+func (u *updateLogs) snapshotFor(seq uint64) (*pb.UpdateSnapshot, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if seq >= u.offset {
+		return &pb.UpdateSnapshot{}, nil
+	}
+	if u.trimming {
+		return nil, errUpdateLogTrimmed
+	}
+	return &pb.UpdateSnapshot{Compacted: true, Seq: u.offset, Snapshot: u.snapshot}, nil
+}
+
+// get blocks until the log of seq is there, or ctx is done, or stop. It fails if
+// the log is compacted or trimmed.
+func (u *updateLogs) get(ctx context.Context, seq uint64, stop chan struct{}) ([]byte, error) {
+	for {
+		u.mu.Lock()
+		if seq < u.offset {
+			u.mu.Unlock()
+			if u.trimming {
+				return nil, errUpdateLogTrimmed
+			}
+			return nil, fmt.Errorf("update log %d is compacted", seq)
+		}
+		if seq < u.offset+uint64(len(u.logs)) {
+			log := u.logs[seq-u.offset]
+			u.mu.Unlock()
+			return log, nil
+		}
+		added := u.added
+		u.mu.Unlock()
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-stop:
+			return nil, fmt.Errorf("framework stopped")
+		}
+	}
+}
+
+// backupServer serves update logs of the primary to its backups.
+type backupServer struct {
+	f *framework
+}
+
+func (s *backupServer) Pull(req *pb.PullRequest, stream pb.Backup_PullServer) error {
+	backup, err := parseSender(stream.Context(), s.f.name)
+	if err != nil {
+		return err
+	}
+	s.f.updates.ack(backup, req.Seq)
+	for seq := req.Seq; ; seq++ {
+		log, err := s.f.updates.get(stream.Context(), seq, s.f.globalStop)
+		if err != nil {
+			return backupError(err)
+		}
+		if err := stream.Send(&pb.UpdateLog{Log: log}); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *backupServer) Snapshot(ctx context.Context, req *pb.PullRequest) (*pb.UpdateSnapshot, error) {
+	if _, err := parseSender(ctx, s.f.name); err != nil {
+		return nil, err
+	}
+	snapshot, err := s.f.updates.snapshotFor(req.Seq)
+	if err != nil {
+		return nil, backupError(err)
+	}
+	return snapshot, nil
+}
+
+func (s *backupServer) Ack(ctx context.Context, req *pb.PullRequest) (*pb.AckResponse, error) {
+	backup, err := parseSender(ctx, s.f.name)
+	if err != nil {
+		return nil, err
+	}
+	s.f.updates.ack(backup, req.Seq)
+	return &pb.AckResponse{}, nil
+}
+
+// backupError tells backups that logs they need are trimmed, which retrying
+// doesn't help.
+func backupError(err error) error {
+	if err == errUpdateLogTrimmed {
+		return grpc.Errorf(codes.OutOfRange, "%v", err)
+	}
+	return err
+}
+
+// Update keeps the log for backups to pull. Only the primary of the task can do it.
+func (f *framework) Update(taskID uint64, log taskgraph.UpdateLog) {
+	if taskID != f.taskID || f.replicaID != 0 {
+		f.log.Printf("Update on task %d dropped, not its primary", taskID)
+		return
+	}
+	b, err := proto.Marshal(log)
+	if err != nil {
+		// Update might be called in event loop, which can't wait for the error.
+		go f.reportError(&taskgraph.FrameworkError{Op: "Update", Err: err})
+		return
+	}
+	f.updates.append(b)
+}
+
+// compactUpdates compacts the update logs into a snapshot of the task once there
+// are enough of them, if the task is Checkpointable. It's called when the state of
+// the task is that right after the logs: by the primary at the start of an epoch,
+// and by backups once they apply a log.
+func (f *framework) compactUpdates() {
+	task, ok := f.task.(taskgraph.Checkpointable)
+	if !ok || !f.updates.full() {
+		return
+	}
+	snapshot, err := task.Snapshot()
+	if err != nil {
+		f.log.Printf("compacting update logs failed, Snapshot: %v", err)
+		return
+	}
+	f.updates.compact(snapshot)
+}
+
+// backUp follows the primary of some task as its backup, until the backup takes
+// over the task, in which case true is returned. It returns false if the job is
+// done or the framework is killed before that.
+func (f *framework) backUp() (bool, error) {
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool, 1)
+	defer func() { stop <- true }()
+	var err error
+	f.epoch, err = f.coordinator.GetAndWatchEpoch(f.name, epochC, stop)
+	if err != nil {
+		return false, err
+	}
+	for f.epoch != exitEpoch {
+		taskID, replicaID, ok, err := f.occupyBackup(epochC)
+		if err != nil || !ok {
+			return false, err
+		}
+		f.log.Printf("backing up task %d as replica %d", taskID, replicaID)
+		promoted, err := f.followPrimary(taskID, replicaID, epochC)
+		if promoted || err != nil {
+			return promoted, err
+		}
+	}
+	return false, nil
+}
+
+// occupyBackup registers the framework as a free replica of some task. The tasks
+// are checked every heartbeat until one is found. It returns false if the job is
+// done or the framework is killed before that.
+func (f *framework) occupyBackup(epochC chan uint64) (taskID, replicaID uint64, ok bool, err error) {
+	for {
+		numOfBackups, numOfTasks, err := f.backupLayout()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		for taskID = 0; taskID < numOfTasks; taskID++ {
+			for replicaID = 1; replicaID <= numOfBackups; replicaID++ {
+				ok, err := f.coordinator.TryOccupyBackup(f.name, taskID, replicaID, f.ln.Addr().String())
+				if err != nil || ok {
+					return taskID, replicaID, ok, err
+				}
+			}
+		}
+		select {
+		case <-time.After(f.heartbeatInterval):
+		case f.epoch = <-epochC:
+			if f.epoch == exitEpoch {
+				return 0, 0, false, nil
+			}
+		case <-f.killChan:
+			return 0, 0, false, nil
+		}
+	}
+}
+
+// backupLayout returns the number of backups of each task, and the number of
+// tasks. Jobs that don't set the number of backups have none.
+func (f *framework) backupLayout() (numOfBackups, numOfTasks uint64, err error) {
+	err = retryEtcd(f.etcdRetryPolicy(), f.log, "GetConfig", func() error {
+		value, err := f.coordinator.GetConfig(f.name, etcdutil.Backups)
+		if etcdutil.IsKeyNotFound(err) {
+			numOfBackups = 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		numOfBackups, err = strconv.ParseUint(value, 10, 64)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	err = retryEtcd(f.etcdRetryPolicy(), f.log, "GetJobSize", func() error {
+		var err error
+		numOfTasks, err = f.coordinator.GetJobSize(f.name, exitEpoch)
+		return err
+	})
+	return numOfBackups, numOfTasks, err
+}
+
+// followPrimary sets up the task as a backup and keeps it up to date, until the
+// primary fails. It tries to take over the task then, and returns true if it
+// does. Otherwise the task exits and the replica is released, so that the backup
+// starts over: someone else has taken over the task, whose state isn't ours.
+// A task without primary isn't taken over by its backups, which wait for the
+// primary to come, e.g. at the start of a job.
+func (f *framework) followPrimary(taskID, replicaID uint64, epochC chan uint64) (bool, error) {
+	task := f.taskBuilder.GetTask(taskID)
+	backupable, ok := task.(taskgraph.Backupable)
+	if !ok {
+		f.coordinator.ReleaseBackup(f.name, taskID, replicaID)
+		return false, fmt.Errorf("task %d isn't Backupable", taskID)
+	}
+	f.log.SetPrefix(fmt.Sprintf("task %d replica %d: ", taskID, replicaID))
+	f.task, f.taskID, f.replicaID = task, taskID, replicaID
+	f.topology.SetTaskID(taskID)
+	_, checkpointable := task.(taskgraph.Checkpointable)
+	f.updates = newUpdateLogs(!checkpointable)
+	task.Init(taskID, f)
+	backupable.BecameBackup()
+
+	addrC := make(chan string, 1)
+	done := make(chan struct{})
+	defer close(done)
+	watchStop := make(chan bool, 1)
+	defer func() { watchStop <- true }()
+	err := f.coordinator.WatchTaskAddress(f.name, watchStop, func(id uint64, addr string) {
+		if id != taskID {
+			return
+		}
+		select {
+		case addrC <- addr:
+		case <-done:
+		}
+	})
+	if err != nil {
+		f.unfollow()
+		return false, err
+	}
+	primary, err := f.coordinator.GetAddress(f.name, taskID)
+	if err != nil && !etcdutil.IsKeyNotFound(err) {
+		f.unfollow()
+		return false, err
+	}
+
+	// the backup can't catch up once failed.
+	failed := make(chan error, 1)
+	stopPull := func() {}
+	startPull := func(addr string) {
+		ctx, cancel := context.WithCancel(context.Background())
+		pulled := make(chan struct{})
+		go func() {
+			defer close(pulled)
+			if err := f.pullUpdates(ctx, addr, backupable); err != nil {
+				failed <- err
+			}
+		}()
+		stopPull = func() {
+			cancel()
+			<-pulled
+		}
+	}
+	if primary != "" {
+		startPull(primary)
+	}
+	for {
+		select {
+		case addr := <-addrC:
+			switch {
+			case addr == "":
+				stopPull()
+				if f.takeOver() {
+					return true, nil
+				}
+				f.unfollow()
+				return false, nil
+			case primary == "":
+				primary = addr
+				startPull(addr)
+			case addr != primary:
+				f.log.Printf("task %d is taken over by %s, starting over", taskID, addr)
+				stopPull()
+				f.unfollow()
+				return false, nil
+			}
+		case err := <-failed:
+			stopPull()
+			f.unfollow()
+			return false, err
+		case f.epoch = <-epochC:
+			if f.epoch == exitEpoch {
+				stopPull()
+				f.unfollow()
+				return false, nil
+			}
+		case <-f.killChan:
+			stopPull()
+			f.unfollow()
+			return false, nil
+		}
+	}
+}
+
+// takeOver tries to occupy the task whose primary has failed. The replica is
+// released once the backup becomes the primary.
+func (f *framework) takeOver() bool {
+	ok, err := f.coordinator.TryOccupyTask(f.name, f.taskID, f.ln.Addr().String())
+	if err != nil || !ok {
+		f.log.Printf("taking over task %d failed: %v", f.taskID, err)
+		return false
+	}
+	f.log.Printf("took over task %d with %d updates", f.taskID, f.updates.len())
+	if err := f.coordinator.ReleaseBackup(f.name, f.taskID, f.replicaID); err != nil {
+		f.log.Printf("ReleaseBackup failed: %v", err)
+	}
+	f.replicaID = 0
+	return true
+}
+
+// unfollow stops backing up the task, and releases the replica.
+func (f *framework) unfollow() {
+	f.task.Exit()
+	if err := f.coordinator.ReleaseBackup(f.name, f.taskID, f.replicaID); err != nil {
+		f.log.Printf("ReleaseBackup failed: %v", err)
+	}
+}
+
+// pullUpdates applies update logs from the primary at addr until ctx is done.
+// Failed pulls are retried, as the primary might be back, or be taken over soon.
+// It fails only if logs wanted are trimmed by the primary.
+func (f *framework) pullUpdates(ctx context.Context, addr string, task taskgraph.Backupable) error {
+	ctx = senderContext(ctx, f.name, strconv.FormatUint(f.replicaID, 10))
+	for {
+		err := f.pullFrom(ctx, addr, task)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if grpc.Code(err) == codes.OutOfRange {
+			return fmt.Errorf("pulling updates from %s: %v", addr, err)
+		}
+		f.log.Printf("pulling updates from %s failed: %v", addr, err)
+		select {
+		case <-time.After(f.retryDelay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// pullFrom streams update logs from the primary, and applies them. Logs applied
+// are acknowledged from time to time, so that the primary can trim them.
+func (f *framework) pullFrom(ctx context.Context, addr string, task taskgraph.Backupable) error {
+	cc, err := grpc.Dial(addr, grpc.WithTimeout(f.dialTimeout))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	client := pb.NewBackupClient(cc)
+	if err := f.pullSnapshot(ctx, client); err != nil {
+		return err
+	}
+	stream, err := client.Pull(ctx, &pb.PullRequest{Seq: f.updates.len()})
+	if err != nil {
+		return err
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		log := task.CreateUpdateLog()
+		if err := proto.Unmarshal(resp.Log, log); err != nil {
+			return err
+		}
+		task.Update(log)
+		f.updates.append(resp.Log)
+		f.compactUpdates()
+		if seq := f.updates.len(); seq%ackUpdateLogs == 0 {
+			if _, err := client.Ack(ctx, &pb.PullRequest{Seq: seq}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// pullSnapshot restores the snapshot of the primary if the logs wanted next are
+// compacted there.
+func (f *framework) pullSnapshot(ctx context.Context, client pb.BackupClient) error {
+	snapshot, err := client.Snapshot(ctx, &pb.PullRequest{Seq: f.updates.len()})
+	if err != nil || !snapshot.Compacted {
+		return err
+	}
+	task, ok := f.task.(taskgraph.Checkpointable)
+	if !ok {
+		return fmt.Errorf("task %d isn't Checkpointable to restore update logs", f.taskID)
+	}
+	f.log.Printf("restoring snapshot of task %d before update log %d", f.taskID, snapshot.Seq)
+	task.Restore(snapshot.Snapshot)
+	f.updates.restore(snapshot)
+	return nil
+}
@@ -0,0 +1,157 @@
+package framework
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/framework/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func TestUpdateLogsCompact(t *testing.T) {
+	u := newUpdateLogs(false)
+	for _, log := range []string{"a", "b", "c"} {
+		u.append([]byte(log))
+	}
+	if s, err := u.snapshotFor(0); s.Compacted || err != nil {
+		t.Errorf("snapshotFor(0) before compact want nothing, get = (%+v, %v)", s, err)
+	}
+	u.compact([]byte("abc"))
+	u.append([]byte("d"))
+	if n := u.len(); n != 4 {
+		t.Errorf("len want = 4, get = %d", n)
+	}
+	stop := make(chan struct{})
+	if _, err := u.get(context.Background(), 1, stop); err == nil {
+		t.Errorf("get of compacted log should fail")
+	}
+	if log, err := u.get(context.Background(), 3, stop); string(log) != "d" || err != nil {
+		t.Errorf("get(3) want = (d, nil), get = (%s, %v)", log, err)
+	}
+	want := &pb.UpdateSnapshot{Compacted: true, Seq: 3, Snapshot: []byte("abc")}
+	if s, err := u.snapshotFor(1); err != nil || !reflect.DeepEqual(s, want) {
+		t.Errorf("snapshotFor(1) want = (%+v, nil), get = (%+v, %v)", want, s, err)
+	}
+	if s, err := u.snapshotFor(3); s.Compacted || err != nil {
+		t.Errorf("snapshotFor(3) want nothing, as log 3 is kept, get = (%+v, %v)", s, err)
+	}
+
+	// a backup restores the snapshot, and goes on from there.
+	backup := newUpdateLogs(false)
+	backup.restore(want)
+	if n := backup.len(); n != 3 {
+		t.Errorf("len after restore want = 3, get = %d", n)
+	}
+}
+
+func TestUpdateLogsTrim(t *testing.T) {
+	u := newUpdateLogs(true)
+	for _, log := range []string{"a", "b", "c", "d"} {
+		u.append([]byte(log))
+	}
+	stop := make(chan struct{})
+	if log, err := u.get(context.Background(), 0, stop); string(log) != "a" || err != nil {
+		t.Errorf("get(0) with no backups want = (a, nil), get = (%s, %v)", log, err)
+	}
+	// logs are kept until every backup has acknowledged them.
+	u.ack("2", 1)
+	u.ack("1", 3)
+	if _, err := u.get(context.Background(), 0, stop); err != errUpdateLogTrimmed {
+		t.Errorf("get(0) want = %v, get = %v", errUpdateLogTrimmed, err)
+	}
+	if log, err := u.get(context.Background(), 1, stop); string(log) != "b" || err != nil {
+		t.Errorf("get(1) want = (b, nil), get = (%s, %v)", log, err)
+	}
+	if _, err := u.snapshotFor(0); err != errUpdateLogTrimmed {
+		t.Errorf("snapshotFor(0) want = %v, get = %v", errUpdateLogTrimmed, err)
+	}
+	u.ack("2", 4)
+	if _, err := u.get(context.Background(), 2, stop); err != errUpdateLogTrimmed {
+		t.Errorf("get(2) want = %v, get = %v", errUpdateLogTrimmed, err)
+	}
+	if n := u.len(); n != 4 {
+		t.Errorf("len want = 4, get = %d", n)
+	}
+
+	// a backup gone can't keep logs beyond maxUpdateLogs.
+	for i := 0; i < maxUpdateLogs+10; i++ {
+		u.append([]byte("e"))
+	}
+	if n := len(u.logs); n != maxUpdateLogs {
+		t.Errorf("logs kept want = %d, get = %d", maxUpdateLogs, n)
+	}
+}
+
+func TestBackupPullUpdates(t *testing.T) {
+	appName := "backup_test_pull"
+	primary := &framework{
+		name:       appName,
+		log:        log.New(os.Stdout, "primary: ", log.Lshortfile),
+		globalStop: make(chan struct{}),
+		updates:    newUpdateLogs(true),
+	}
+	defer close(primary.globalStop)
+	ln := createListener(t)
+	server := grpc.NewServer()
+	pb.RegisterBackupServer(server, &backupServer{primary})
+	go server.Serve(ln)
+	defer server.Stop()
+
+	newBackup := func(replicaID uint64) *framework {
+		return &framework{
+			name:      appName,
+			replicaID: replicaID,
+			log:       log.New(os.Stdout, "backup: ", log.Lshortfile),
+			config:    newConfig(nil),
+			updates:   newUpdateLogs(true),
+		}
+	}
+	backup := newBackup(1)
+	task := &updateLogTask{applied: make(chan string, ackUpdateLogs+1)}
+	ctx, cancel := context.WithCancel(context.Background())
+	pulled := make(chan error, 1)
+	go func() { pulled <- backup.pullUpdates(ctx, ln.Addr().String(), task) }()
+
+	// the log after an ack is applied once the ack is done.
+	for i := 0; i <= ackUpdateLogs; i++ {
+		primary.Update(0, &pb.UpdateLog{Log: []byte("a")})
+	}
+	for i := 0; i <= ackUpdateLogs; i++ {
+		if log := <-task.applied; log != "a" {
+			t.Fatalf("log applied want = a, get = %s", log)
+		}
+	}
+	stop := make(chan struct{})
+	if _, err := primary.updates.get(context.Background(), ackUpdateLogs-1, stop); err != errUpdateLogTrimmed {
+		t.Errorf("get of acknowledged log want = %v, get = %v", errUpdateLogTrimmed, err)
+	}
+	if _, err := primary.updates.get(context.Background(), ackUpdateLogs, stop); err != nil {
+		t.Errorf("get of log not acknowledged failed: %v", err)
+	}
+	cancel()
+	if err := <-pulled; err != nil {
+		t.Errorf("pullUpdates after cancel want = nil, get = %v", err)
+	}
+
+	// a backup coming later can't catch up.
+	late := newBackup(2)
+	if err := late.pullUpdates(context.Background(), ln.Addr().String(), task); err == nil {
+		t.Errorf("pullUpdates of trimmed logs should fail")
+	}
+}
+
+// updateLogTask applies update logs by sending them to applied.
+type updateLogTask struct {
+	applied chan string
+}
+
+func (t *updateLogTask) BecamePrimary() {}
+func (t *updateLogTask) BecameBackup()  {}
+func (t *updateLogTask) Update(log taskgraph.UpdateLog) {
+	t.applied <- string(log.(*pb.UpdateLog).Log)
+}
+func (t *updateLogTask) CreateUpdateLog() taskgraph.UpdateLog { return &pb.UpdateLog{} }
@@ -6,9 +6,9 @@ import (
 	"net"
 	"os"
 	"strconv"
-	"strings"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 	"golang.org/x/net/context"
@@ -16,12 +16,7 @@ import (
 
 // One need to pass in at least these two for framework to start.
 func NewBootStrap(jobName string, etcdURLs []string, ln net.Listener, logger *log.Logger) taskgraph.Bootstrap {
-	return &framework{
-		name:     jobName,
-		etcdURLs: etcdURLs,
-		ln:       ln,
-		log:      logger,
-	}
+	return NewBootStrapWithOptions(jobName, etcdURLs, ln, logger)
 }
 
 func (f *framework) SetTaskBuilder(taskBuilder taskgraph.TaskBuilder) {
@@ -30,76 +25,157 @@ func (f *framework) SetTaskBuilder(taskBuilder taskgraph.TaskBuilder) {
 
 func (f *framework) SetTopology(topology taskgraph.Topology) { f.topology = topology }
 
-func (f *framework) Start() {
+func (f *framework) SetRetryPolicy(policy taskgraph.RetryPolicy) { f.retryPolicy = policy }
+
+func (f *framework) Start() error {
 	var err error
 
 	if f.log == nil {
 		f.log = log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
 	}
+	f.retryPolicy = fillRetryPolicy(f.retryPolicy, f.defaultRetryPolicy())
 
-	f.etcdClient = etcd.NewClient(f.etcdURLs)
+	if f.coordinator == nil {
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   f.etcdURLs,
+			DialTimeout: f.dialTimeout,
+		})
+		if err != nil {
+			return &taskgraph.FrameworkError{Op: "connectEtcd", Err: err}
+		}
+		f.coordinator = etcdutil.NewCoordinator(client)
+	}
+	if err = f.coordinator.StartSession(f.heartbeatInterval, f.ttlMultiplier); err != nil {
+		f.coordinator.Close()
+		return &taskgraph.FrameworkError{Op: "StartSession", Err: err}
+	}
+	// Keep the session alive while waiting for a free task.
+	f.heartbeat()
 
-	if err = f.occupyTask(); err != nil {
-		f.log.Panicf("occupyTask() failed: %v", err)
+	promoted := false
+	if f.backup {
+		if promoted, err = f.backUp(); err != nil || !promoted {
+			f.abortStart()
+			if err != nil {
+				return &taskgraph.FrameworkError{Op: "backUp", Err: err}
+			}
+			return nil
+		}
+	} else if err = f.occupyTask(); err != nil {
+		f.abortStart()
+		if err == etcdutil.ErrStopped {
+			// killed before getting a task.
+			return nil
+		}
+		return &taskgraph.FrameworkError{Op: "occupyTask", Err: err}
 	}
 
 	f.log.SetPrefix(fmt.Sprintf("task %d: ", f.taskID))
+	f.injector = newFaultInjector(f.faults, f.taskID)
 
 	f.epochWatcher = make(chan uint64, 1) // grab epoch from etcd
 	f.epochWatchStop = make(chan bool, 1) // stop etcd watch
-	// meta will have epoch prepended so we must get epoch before any watch on meta
-	f.epoch, err = etcdutil.GetAndWatchEpoch(f.etcdClient, f.name, f.epochWatcher, f.epochWatchStop)
+	// metas are kept by epoch, so we must get epoch before any watch on meta
+	f.epoch, err = f.coordinator.GetAndWatchEpoch(f.name, f.epochWatcher, f.epochWatchStop)
 	if err != nil {
-		f.log.Fatalf("WatchEpoch failed: %v", err)
+		f.abortStart()
+		return &taskgraph.FrameworkError{Op: "WatchEpoch", Err: err}
 	}
 	if f.epoch == exitEpoch {
 		f.log.Printf("found that job has finished\n")
 		f.epochWatchStop <- true
-		return
+		f.abortStart()
+		if promoted {
+			f.task.Exit()
+		}
+		return nil
 	}
 	f.log.Printf("starting at epoch %d\n", f.epoch)
+	if err = f.setupStaleness(); err != nil {
+		f.epochWatchStop <- true
+		f.abortStart()
+		return err
+	}
 
 	// task builder and topology are defined by applications.
 	// Both should be initialized at this point.
 	// Get the task implementation and topology for this node (indentified by taskID)
-	f.task = f.taskBuilder.GetTask(f.taskID)
-	f.topology.SetTaskID(f.taskID)
+	// A backup taking over has done so already.
+	if !promoted {
+		f.task = f.taskBuilder.GetTask(f.taskID)
+		f.topology.SetTaskID(f.taskID)
+		_, checkpointable := f.task.(taskgraph.Checkpointable)
+		f.updates = newUpdateLogs(!checkpointable)
+	}
+
+	f.connPool = newConnPool(f.name, f.coordinator, f.dialTimeout)
+	if err = f.connPool.watch(); err != nil {
+		f.epochWatchStop <- true
+		f.abortStart()
+		return &taskgraph.FrameworkError{Op: "watchTaskAddress", Err: err}
+	}
 
-	f.heartbeat()
 	f.setup()
-	f.task.Init(f.taskID, f)
+	if promoted {
+		f.task.(taskgraph.Backupable).BecamePrimary()
+	} else {
+		f.task.Init(f.taskID, f)
+		f.restore()
+	}
 	f.run()
 	f.releaseResource()
 	f.task.Exit()
+	return nil
+}
+
+// abortStart releases what's acquired in Start if it can't get into event loop.
+func (f *framework) abortStart() {
+	close(f.globalStop)
+	f.coordinator.Close()
 }
 
 func (f *framework) setup() {
-	f.globalStop = make(chan struct{})
 	f.metaChan = make(chan *metaChange, 1)
 	f.dataReqtoSendChan = make(chan *dataRequest, 1)
 	f.dataRespChan = make(chan *dataResponse, 1)
+	f.dataFailChan = make(chan *dataFailure, 1)
+	f.errChan = make(chan error, 1)
 	f.epochCheckChan = make(chan *epochCheck, 1)
+	f.clockChan = make(chan uint64, 1)
 }
 
 func (f *framework) run() {
 	f.log.Printf("framework starts to run")
 	defer f.log.Printf("framework stops running.")
-	f.setEpochStarted()
+	if !f.setEpochStarted() {
+		return
+	}
 	go f.startHTTP()
 	// this for-select is primarily used to synchronize epoch specific events.
 	for {
 		select {
-		case nextEpoch, ok := <-f.epochWatcher:
+		case <-f.killChan:
 			f.releaseEpochResource()
-			if !ok { // task is killed
+			return
+		case nextEpoch := <-f.epochWatcher:
+			if f.staleness > 0 && nextEpoch != exitEpoch {
+				f.jobEpoch = nextEpoch
+				if next := f.nextEpoch(); next != f.epoch && !f.enterEpoch(next) {
+					return
+				}
+				break
+			}
+			if !f.enterEpoch(nextEpoch) {
 				return
 			}
-			f.epoch = nextEpoch
-			if f.epoch == exitEpoch {
+		case epoch := <-f.clockChan:
+			if epoch != f.epoch {
+				break
+			}
+			f.clock = epoch + 1
+			if next := f.nextEpoch(); next != f.epoch && !f.enterEpoch(next) {
 				return
 			}
-			// start the next epoch's work
-			f.setEpochStarted()
 		case meta := <-f.metaChan:
 			if meta.epoch != f.epoch {
 				break
@@ -121,8 +197,15 @@ func (f *framework) run() {
 				break
 			}
 			f.handleDataResp(f.userCtx, resp)
+		case fail := <-f.dataFailChan:
+			if fail.epoch != f.epoch {
+				break
+			}
+			f.task.DataFailed(f.userCtx, fail.taskID, fail.method, fail.err)
+		case err := <-f.errChan:
+			f.task.OnFrameworkError(err)
 		case ec := <-f.epochCheckChan:
-			if ec.epoch != f.epoch {
+			if !f.inWindow(ec.epoch) {
 				ec.fail()
 				break
 			}
@@ -131,18 +214,50 @@ func (f *framework) run() {
 	}
 }
 
-func (f *framework) setEpochStarted() {
+// enterEpoch moves the task to the next epoch. It returns false if the task stops
+// there, e.g. the job is done.
+func (f *framework) enterEpoch(next uint64) bool {
+	f.releaseEpochResource()
+	f.epoch = next
+	if f.epoch == exitEpoch {
+		return false
+	}
+	if f.injector.killAt(f.epoch) {
+		f.log.Printf("fault injected: killed at epoch %d", f.epoch)
+		return false
+	}
+	// start the next epoch's work
+	return f.setEpochStarted()
+}
+
+// setEpochStarted starts the work of current epoch. It returns false if the task
+// has retired, i.e. left the job by resize.
+func (f *framework) setEpochStarted() bool {
 	// Each epoch have a new meta map
 	f.metaNotified = make(map[string]bool)
 
 	f.userCtx = context.WithValue(context.Background(), epochKey, f.epoch)
 	f.userCtx, f.userCtxCancel = context.WithCancel(f.userCtx)
 
+	switch f.membership() {
+	case retired:
+		f.log.Printf("retired at epoch %d", f.epoch)
+		f.userCtxCancel()
+		return false
+	case standby:
+		f.log.Printf("standby at epoch %d, joining later", f.epoch)
+		return true
+	}
+	f.checkpoint()
+	if f.updates != nil {
+		f.compactUpdates()
+	}
 	f.task.EnterEpoch(f.userCtx, f.epoch)
 	// setup etcd watches
 	for _, linkType := range f.topology.GetLinkTypes() {
 		f.watchMeta(linkType, f.topology.GetNeighbors(linkType, f.epoch))
 	}
+	return true
 }
 
 func (f *framework) releaseEpochResource() {
@@ -157,19 +272,25 @@ func (f *framework) releaseEpochResource() {
 func (f *framework) releaseResource() {
 	f.log.Printf("framework is releasing resources...\n")
 	f.epochWatchStop <- true
+	if f.sizeWatchStop != nil {
+		f.sizeWatchStop <- true
+	}
 	close(f.globalStop)
+	f.connPool.close()
 	f.ln.Close() // stop grpc server
+	f.coordinator.Close()
 }
 
-// occupyTask will grab the first unassigned task and register itself on etcd.
+// occupyTask will grab the first unassigned task and register itself on etcd. It
+// gives up with etcdutil.ErrStopped once killed.
 func (f *framework) occupyTask() error {
 	for {
-		freeTask, err := etcdutil.WaitFreeTask(f.etcdClient, f.name, f.log)
+		freeTask, err := f.coordinator.WaitFreeTask(f.name, f.killChan, f.log)
 		if err != nil {
 			return err
 		}
 		f.log.Printf("standby grabbed free task %d", freeTask)
-		ok, err := etcdutil.TryOccupyTask(f.etcdClient, f.name, freeTask, f.ln.Addr().String())
+		ok, err := f.coordinator.TryOccupyTask(f.name, freeTask, f.ln.Addr().String())
 		if err != nil {
 			return err
 		}
@@ -177,46 +298,45 @@ func (f *framework) occupyTask() error {
 			f.taskID = freeTask
 			return nil
 		}
+		// The stale free hint is dropped, so it won't be tried again.
 		f.log.Printf("standby tried task %d failed. Wait free task again.", freeTask)
 	}
 }
 
+// watchMeta watches metas of the neighbors flagged at current epoch. Neighbors
+// might change every epoch, so the watches are stopped at the end of the epoch,
+// and set up again for the next one.
 func (f *framework) watchMeta(linkType string, taskIDs []uint64) {
 	stops := make([]chan bool, len(taskIDs))
+	// watches are set up in event loop.
+	epoch := f.epoch
 
 	for i, taskID := range taskIDs {
 		stop := make(chan bool, 1)
 		stops[i] = stop
 
-		watchPath := etcdutil.MetaPath(linkType, f.name, taskID)
-
 		// When a node working for a task crashed, a new node will take over
 		// the task and continue what's left. It assumes that progress is stalled
 		// until the new node comes (i.e. epoch won't change).
-		responseHandler := func(resp *etcd.Response, taskID uint64) {
-			if resp.Action != "set" && resp.Action != "get" {
-				return
-			}
-			// epoch is prepended to meta. When a new one starts and replaces
-			// the old one, it doesn't need to handle previous things, whose
-			// epoch is smaller than current one.
-			values := strings.SplitN(resp.Node.Value, "-", 2)
-			ep, err := strconv.ParseUint(values[0], 10, 64)
-			if err != nil {
-				f.log.Panicf("WARN: not a unit64 prepended to meta: %s", values[0])
+		responseHandler := func(taskID uint64, value string) {
+			if d := f.injector.metaDelay(); d > 0 {
+				time.Sleep(d)
 			}
 			f.metaChan <- &metaChange{
 				from:  taskID,
 				who:   linkType,
-				epoch: ep,
-				meta:  values[1],
+				epoch: epoch,
+				meta:  value,
 			}
 		}
 
 		// Need to pass in taskID to make it work. Didn't know why.
-		err := etcdutil.WatchMeta(f.etcdClient, taskID, watchPath, stop, responseHandler)
+		err := retryEtcd(f.etcdRetryPolicy(), f.log, "WatchMeta", func() error {
+			return f.coordinator.WatchMeta(f.name, linkType, taskID, epoch, stop, responseHandler)
+		})
 		if err != nil {
-			f.log.Panicf("WatchMeta failed. path: %s, err: %v", watchPath, err)
+			// watchMeta runs in event loop, so the task can be told directly.
+			f.task.OnFrameworkError(err)
 		}
 	}
 	f.metaStops = append(f.metaStops, stops...)
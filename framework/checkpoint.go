@@ -0,0 +1,310 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+// Snapshots of a Checkpointable task are saved at the start of every epoch, named
+// by job, task and epoch, e.g. {dir}/{job}-000003-00000000000000000012.
+// Each snapshot is written to a temporary file first and then renamed, so that a
+// node crashing while writing doesn't leave a broken one.
+//
+// Each task reports its snapshot to the coordinator once saved. The checkpoint of
+// the epoch is committed by the last task to report, which also writes the
+// manifest to {dir}/{job}-manifest-00000000000000000012. The controller resumes a
+// job from the latest committed checkpoint, so that all tasks restore the same
+// epoch. Snapshots earlier than that are removed.
+
+func checkpointPrefix(dir, job string, taskID uint64) string {
+	return path.Join(dir, fmt.Sprintf("%s-%06d-", job, taskID))
+}
+
+func checkpointPath(dir, job string, taskID, epoch uint64) string {
+	return fmt.Sprintf("%s%020d", checkpointPrefix(dir, job, taskID), epoch)
+}
+
+func manifestPrefix(dir, job string) string {
+	return path.Join(dir, job+"-manifest-")
+}
+
+func manifestPath(dir, job string, epoch uint64) string {
+	return fmt.Sprintf("%s%020d", manifestPrefix(dir, job), epoch)
+}
+
+func checksum(snapshot []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(snapshot))
+}
+
+// checkpoints returns the epochs of saved snapshots of the task, by file name.
+func (f *framework) checkpoints(taskID uint64) (map[uint64]string, error) {
+	return f.filesByEpoch(checkpointPrefix(f.checkpointDir, f.name, taskID))
+}
+
+// filesByEpoch returns the files named by the prefix and an epoch, by epoch.
+func (f *framework) filesByEpoch(prefix string) (map[uint64]string, error) {
+	names, err := f.checkpointFS.Glob(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	epochs := make(map[uint64]string, len(names))
+	for _, name := range names {
+		// e.g. temporary files.
+		epoch, err := strconv.ParseUint(strings.TrimPrefix(name, prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		epochs[epoch] = name
+	}
+	return epochs, nil
+}
+
+// checkpoint saves the snapshot of the task at the start of current epoch. The
+// snapshot is taken in event loop, and written in background.
+func (f *framework) checkpoint() {
+	if f.checkpointFS == nil || f.epoch == 0 {
+		return
+	}
+	task, ok := f.task.(taskgraph.Checkpointable)
+	if !ok {
+		return
+	}
+	snapshot, err := task.Snapshot()
+	if err != nil {
+		f.task.OnFrameworkError(&taskgraph.FrameworkError{Op: "Snapshot", Err: err})
+		return
+	}
+	go func(epoch uint64) {
+		if err := f.saveCheckpoint(epoch, snapshot); err != nil {
+			f.reportError(&taskgraph.FrameworkError{Op: "saveCheckpoint", Err: err})
+		}
+	}(f.epoch)
+}
+
+// saveCheckpoint writes the snapshot of the epoch, and reports it.
+func (f *framework) saveCheckpoint(epoch uint64, snapshot []byte) error {
+	name := checkpointPath(f.checkpointDir, f.name, f.taskID, epoch)
+	if err := f.writeFile(name, snapshot); err != nil {
+		return err
+	}
+	f.log.Printf("saved checkpoint of epoch %d", epoch)
+	if err := f.reportCheckpoint(epoch, name, checksum(snapshot)); err != nil {
+		return err
+	}
+	return f.removeCheckpoints()
+}
+
+// writeFile writes a temporary file and renames it to name.
+func (f *framework) writeFile(name string, b []byte) error {
+	tmp := name + ".tmp"
+	// left by a node crashed while writing. Files aren't truncated on open.
+	if exist, err := f.checkpointFS.Exists(tmp); err != nil {
+		return err
+	} else if exist {
+		if err := f.checkpointFS.Remove(tmp); err != nil {
+			return err
+		}
+	}
+	w, err := f.checkpointFS.OpenWriteCloser(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return f.checkpointFS.Rename(tmp, name)
+}
+
+// reportCheckpoint reports the snapshot of the task at the epoch. If every task at
+// the epoch has reported, the checkpoint is committed, and its manifest written.
+// Only one of the tasks does that.
+func (f *framework) reportCheckpoint(epoch uint64, name, sum string) error {
+	var manifest taskgraph.CheckpointManifest
+	var size uint64
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, "ReportCheckpoint", func() error {
+		checkpoint := taskgraph.TaskCheckpoint{TaskID: f.taskID, Path: name, Checksum: sum}
+		if err := f.coordinator.ReportCheckpoint(f.name, epoch, checkpoint); err != nil {
+			return err
+		}
+		var err error
+		if manifest, err = f.coordinator.GetCheckpoint(f.name, epoch); err != nil {
+			return err
+		}
+		size, err = f.coordinator.GetJobSize(f.name, epoch)
+		if etcdutil.IsKeyNotFound(err) {
+			// Nobody can tell whether all tasks have reported.
+			size = 0
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if size == 0 || uint64(len(manifest.Tasks)) < size {
+		return nil
+	}
+	err = f.coordinator.CommitCheckpoint(f.name, epoch)
+	if etcdutil.IsCompareFailed(err) {
+		// committed by another task, or a later checkpoint is.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	f.log.Printf("committed checkpoint of epoch %d", epoch)
+	return f.saveManifest(manifest)
+}
+
+// saveManifest writes the manifest of the committed checkpoint, and removes
+// earlier ones.
+func (f *framework) saveManifest(manifest taskgraph.CheckpointManifest) error {
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := f.writeFile(manifestPath(f.checkpointDir, f.name, manifest.Epoch), b); err != nil {
+		return err
+	}
+	manifests, err := f.filesByEpoch(manifestPrefix(f.checkpointDir, f.name))
+	if err != nil {
+		return err
+	}
+	f.removeBefore(manifests, manifest.Epoch)
+	return nil
+}
+
+// removeCheckpoints removes snapshots of the task earlier than the latest committed
+// checkpoint, which are never restored again.
+func (f *framework) removeCheckpoints() error {
+	committed, found, err := f.latestCheckpoint()
+	if err != nil || !found {
+		return err
+	}
+	epochs, err := f.checkpoints(f.taskID)
+	if err != nil {
+		return err
+	}
+	f.removeBefore(epochs, committed.Epoch)
+	return nil
+}
+
+// latestCheckpoint returns the latest committed checkpoint of the job, if any.
+func (f *framework) latestCheckpoint() (taskgraph.CheckpointManifest, bool, error) {
+	var committed taskgraph.CheckpointManifest
+	found := true
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, "LatestCheckpoint", func() error {
+		var err error
+		committed, err = f.coordinator.LatestCheckpoint(f.name)
+		if etcdutil.IsKeyNotFound(err) {
+			found = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return committed, false, err
+	}
+	return committed, found, nil
+}
+
+func (f *framework) removeBefore(files map[uint64]string, epoch uint64) {
+	for e, old := range files {
+		if e < epoch {
+			if err := f.checkpointFS.Remove(old); err != nil {
+				f.log.Printf("removing %s failed: %v", old, err)
+			}
+		}
+	}
+}
+
+// restore loads the snapshot of the task at current epoch into it, if there is
+// one. One taken before current epoch isn't restored, or the task would run
+// current epoch with the state of that one, which the task is told instead.
+func (f *framework) restore() {
+	if f.checkpointFS == nil {
+		return
+	}
+	task, ok := f.task.(taskgraph.Checkpointable)
+	if !ok {
+		return
+	}
+	snapshot, err := f.loadCheckpoint()
+	if err != nil {
+		f.task.OnFrameworkError(&taskgraph.FrameworkError{Op: "restore", Err: err})
+		return
+	}
+	if snapshot == nil {
+		return
+	}
+	f.log.Printf("restoring checkpoint of epoch %d", f.epoch)
+	task.Restore(snapshot)
+}
+
+// loadCheckpoint returns the snapshot of the task at current epoch, as reported to
+// the coordinator. When the job is resumed, that's the one in the committed
+// manifest. It returns nil if the task has none at current epoch and nothing is
+// lost, and an error if only an earlier committed checkpoint has one.
+func (f *framework) loadCheckpoint() ([]byte, error) {
+	committed, found, err := f.latestCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	manifest := committed
+	if !found || committed.Epoch != f.epoch {
+		// reported at current epoch, but maybe not committed yet.
+		err := retryEtcd(f.etcdRetryPolicy(), f.log, "GetCheckpoint", func() error {
+			var err error
+			manifest, err = f.coordinator.GetCheckpoint(f.name, f.epoch)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if checkpoint, ok := findTaskCheckpoint(manifest, f.taskID); ok {
+		return f.readCheckpoint(checkpoint)
+	}
+	if _, ok := findTaskCheckpoint(committed, f.taskID); found && ok && committed.Epoch < f.epoch {
+		return nil, fmt.Errorf("latest checkpoint is of epoch %d, not current epoch %d, state since then is lost", committed.Epoch, f.epoch)
+	}
+	return nil, nil
+}
+
+// readCheckpoint reads the reported snapshot, which has to match its checksum.
+func (f *framework) readCheckpoint(checkpoint taskgraph.TaskCheckpoint) ([]byte, error) {
+	r, err := f.checkpointFS.OpenReadCloser(checkpoint.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	snapshot, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if sum := checksum(snapshot); sum != checkpoint.Checksum {
+		return nil, fmt.Errorf("checksum of %s is %s, want %s", checkpoint.Path, sum, checkpoint.Checksum)
+	}
+	return snapshot, nil
+}
+
+func findTaskCheckpoint(manifest taskgraph.CheckpointManifest, taskID uint64) (taskgraph.TaskCheckpoint, bool) {
+	for _, t := range manifest.Tasks {
+		if t.TaskID == taskID {
+			return t, true
+		}
+	}
+	return taskgraph.TaskCheckpoint{}, false
+}
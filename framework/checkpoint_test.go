@@ -0,0 +1,146 @@
+package framework
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/filesystem"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+)
+
+func newCheckpointFramework(job string, store *memcoord.Store, dir string, taskID, epoch uint64) *framework {
+	return &framework{
+		name:   job,
+		taskID: taskID,
+		epoch:  epoch,
+		log:    log.New(os.Stdout, fmt.Sprintf("task %d: ", taskID), log.Lshortfile),
+		config: newConfig([]Option{
+			WithCoordinator(store.NewCoordinator()),
+			WithCheckpoint(filesystem.NewLocalFSClient(), dir),
+		}),
+	}
+}
+
+func TestLoadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLoadCheckpoint")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	job := "TestLoadCheckpoint"
+	store := memcoord.NewStore()
+	if err := store.NewCoordinator().InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	// both tasks save snapshots at epoch 1, which is committed, but only task 0
+	// does at epoch 2.
+	for _, s := range []struct{ taskID, epoch uint64 }{{0, 1}, {1, 1}, {0, 2}} {
+		f := newCheckpointFramework(job, store, dir, s.taskID, s.epoch)
+		if err := f.saveCheckpoint(s.epoch, []byte(fmt.Sprintf("%d-%d", s.taskID, s.epoch))); err != nil {
+			t.Fatalf("saveCheckpoint of task %d at epoch %d failed: %v", s.taskID, s.epoch, err)
+		}
+	}
+
+	// reported at current epoch, though not committed.
+	f := newCheckpointFramework(job, store, dir, 0, 2)
+	if snapshot, err := f.loadCheckpoint(); string(snapshot) != "0-2" || err != nil {
+		t.Errorf("loadCheckpoint of task 0 at epoch 2 want = (0-2, nil), get = (%s, %v)", snapshot, err)
+	}
+	f = newCheckpointFramework(job, store, dir, 1, 1)
+	if snapshot, err := f.loadCheckpoint(); string(snapshot) != "1-1" || err != nil {
+		t.Errorf("loadCheckpoint of task 1 at epoch 1 want = (1-1, nil), get = (%s, %v)", snapshot, err)
+	}
+	// the state of task 1 since epoch 1 is lost.
+	f = newCheckpointFramework(job, store, dir, 1, 2)
+	if snapshot, err := f.loadCheckpoint(); err == nil || !strings.Contains(err.Error(), "epoch 1") {
+		t.Errorf("loadCheckpoint of task 1 at epoch 2 want error about epoch 1, get = (%s, %v)", snapshot, err)
+	}
+}
+
+func TestSaveCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestSaveCheckpoint")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	job := "TestSaveCheckpoint"
+	store := memcoord.NewStore()
+	if err := store.NewCoordinator().InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	tasks := []*framework{
+		newCheckpointFramework(job, store, dir, 0, 0),
+		newCheckpointFramework(job, store, dir, 1, 0),
+	}
+	// left by a node crashed while writing, longer than the snapshot.
+	tmp := checkpointPath(dir, job, 0, 1) + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte("broken snapshot"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	for _, epoch := range []uint64{1, 2} {
+		for _, f := range tasks {
+			if err := f.saveCheckpoint(epoch, []byte(fmt.Sprintf("%d-%d", f.taskID, epoch))); err != nil {
+				t.Fatalf("saveCheckpoint of task %d at epoch %d failed: %v", f.taskID, epoch, err)
+			}
+		}
+	}
+
+	manifest, err := store.NewCoordinator().LatestCheckpoint(job)
+	if err != nil || manifest.Epoch != 2 || len(manifest.Tasks) != 2 {
+		t.Fatalf("LatestCheckpoint want epoch 2 of 2 tasks, get = (%+v, %v)", manifest, err)
+	}
+	f := newCheckpointFramework(job, store, dir, 0, 1)
+	if snapshot, err := f.readCheckpoint(taskgraph.TaskCheckpoint{Path: checkpointPath(dir, job, 0, 1), Checksum: checksum([]byte("0-1"))}); string(snapshot) != "0-1" || err != nil {
+		t.Errorf("readCheckpoint want = (0-1, nil), get = (%s, %v)", snapshot, err)
+	}
+	// only the manifest of the latest committed checkpoint is kept.
+	manifests, err := f.filesByEpoch(manifestPrefix(dir, job))
+	if err != nil {
+		t.Fatalf("filesByEpoch failed: %v", err)
+	}
+	if want := map[uint64]string{2: manifestPath(dir, job, 2)}; !reflect.DeepEqual(manifests, want) {
+		t.Errorf("manifests want = %v, get = %v", want, manifests)
+	}
+	// task 0 saved its snapshot of epoch 2 before the commit, and task 1 after.
+	for taskID, want := range []map[uint64]string{
+		{1: checkpointPath(dir, job, 0, 1), 2: checkpointPath(dir, job, 0, 2)},
+		{2: checkpointPath(dir, job, 1, 2)},
+	} {
+		epochs, err := f.checkpoints(uint64(taskID))
+		if err != nil {
+			t.Fatalf("checkpoints failed: %v", err)
+		}
+		if !reflect.DeepEqual(epochs, want) {
+			t.Errorf("snapshots of task %d want = %v, get = %v", taskID, want, epochs)
+		}
+	}
+}
+
+func TestLoadCheckpointChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLoadCheckpointChecksum")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	job := "TestLoadCheckpointChecksum"
+	store := memcoord.NewStore()
+	if err := store.NewCoordinator().InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	f := newCheckpointFramework(job, store, dir, 0, 1)
+	if err := f.saveCheckpoint(1, []byte("0-1")); err != nil {
+		t.Fatalf("saveCheckpoint failed: %v", err)
+	}
+	if err := ioutil.WriteFile(checkpointPath(dir, job, 0, 1), []byte("0-2"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if snapshot, err := f.loadCheckpoint(); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("loadCheckpoint of a corrupted snapshot want checksum error, get = (%s, %v)", snapshot, err)
+	}
+}
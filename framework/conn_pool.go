@@ -0,0 +1,105 @@
+package framework
+
+import (
+	"sync"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"google.golang.org/grpc"
+)
+
+// connPool caches grpc connections to other tasks, keyed by taskID. Tasks usually
+// request data from the same neighbors every epoch, so we don't want to dial
+// for each request.
+// A connection is dropped once the task's address changes in coordinator, i.e. another
+// node has taken over the task, or a request on it fails.
+type connPool struct {
+	sync.Mutex
+	name        string
+	coordinator taskgraph.Coordinator
+	dialTimeout time.Duration
+	conns       map[uint64]*taskConn
+	watchStop   chan bool
+}
+
+type taskConn struct {
+	addr string
+	cc   *grpc.ClientConn
+}
+
+func newConnPool(name string, coordinator taskgraph.Coordinator, dialTimeout time.Duration) *connPool {
+	return &connPool{
+		name:        name,
+		coordinator: coordinator,
+		dialTimeout: dialTimeout,
+		conns:       make(map[uint64]*taskConn),
+	}
+}
+
+// watch invalidates cached connections when address changes.
+func (p *connPool) watch() error {
+	p.watchStop = make(chan bool, 1)
+	return p.coordinator.WatchTaskAddress(p.name, p.watchStop, func(taskID uint64, addr string) {
+		p.Lock()
+		defer p.Unlock()
+		if c, ok := p.conns[taskID]; ok && c.addr != addr {
+			c.cc.Close()
+			delete(p.conns, taskID)
+		}
+	})
+}
+
+// get returns the connection to the task and its address. It dials if there is
+// no cached connection.
+func (p *connPool) get(taskID uint64) (*grpc.ClientConn, string, error) {
+	p.Lock()
+	c, ok := p.conns[taskID]
+	p.Unlock()
+	if ok {
+		return c.cc, c.addr, nil
+	}
+
+	addr, err := p.coordinator.GetAddress(p.name, taskID)
+	if err != nil {
+		return nil, "", err
+	}
+	// The grpc.WithTimeout would help detect any disconnection in failfast.
+	// Otherwise grpc.Invoke will keep retrying.
+	cc, err := grpc.Dial(addr, grpc.WithTimeout(p.dialTimeout))
+	if err != nil {
+		return nil, addr, err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	// Someone else might have dialed the same task in the meantime.
+	if c, ok := p.conns[taskID]; ok {
+		cc.Close()
+		return c.cc, c.addr, nil
+	}
+	p.conns[taskID] = &taskConn{addr: addr, cc: cc}
+	return cc, addr, nil
+}
+
+// evict closes the connection to the task if it's still cached.
+func (p *connPool) evict(taskID uint64, cc *grpc.ClientConn) {
+	p.Lock()
+	defer p.Unlock()
+	if c, ok := p.conns[taskID]; ok && c.cc == cc {
+		c.cc.Close()
+		delete(p.conns, taskID)
+	}
+}
+
+// close stops watching and closes all connections.
+func (p *connPool) close() {
+	if p.watchStop != nil {
+		p.watchStop <- true
+	}
+	p.Lock()
+	defer p.Unlock()
+	for taskID, c := range p.conns {
+		c.cc.Close()
+		delete(p.conns, taskID)
+	}
+}
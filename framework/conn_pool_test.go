@@ -0,0 +1,58 @@
+package framework
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"golang.org/x/net/context"
+)
+
+// Connection should be reused until the task's address changes or it's evicted.
+func TestConnPool(t *testing.T) {
+	job := "TestConnPool"
+	etcdClient := newEtcdClient(t)
+	defer etcdClient.Delete(context.Background(), "/"+job, clientv3.WithPrefix())
+
+	ln0, ln1 := createListener(t), createListener(t)
+	defer ln0.Close()
+	defer ln1.Close()
+	if _, err := etcdClient.Put(context.Background(), etcdutil.TaskMasterPath(job, 1), ln0.Addr().String()); err != nil {
+		t.Fatalf("etcdClient.Set failed: %v", err)
+	}
+
+	p := newConnPool(job, etcdutil.NewCoordinator(etcdClient), defaultHeartbeatInterval)
+	if err := p.watch(); err != nil {
+		t.Fatalf("watch failed: %v", err)
+	}
+	defer p.close()
+
+	cc, addr, err := p.get(1)
+	if err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if addr != ln0.Addr().String() {
+		t.Errorf("address want = %s, get = %s", ln0.Addr(), addr)
+	}
+	if cc2, _, _ := p.get(1); cc2 != cc {
+		t.Errorf("connection isn't reused")
+	}
+
+	p.evict(1, cc)
+	if cc2, _, _ := p.get(1); cc2 == cc {
+		t.Errorf("evicted connection is reused")
+	}
+
+	// another node takes over task 1.
+	if _, err := etcdClient.Put(context.Background(), etcdutil.TaskMasterPath(job, 1), ln1.Addr().String()); err != nil {
+		t.Fatalf("etcdClient.Set failed: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, addr, _ = p.get(1); addr == ln1.Addr().String() {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Errorf("address want = %s, get = %s", ln1.Addr(), addr)
+}
@@ -6,7 +6,8 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/proto"
-	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/framework/proto"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
@@ -48,7 +49,21 @@ func (f *framework) CheckGRPCContext(ctx context.Context) error {
 func (f *framework) DataRequest(ctx context.Context, toID uint64, method string, input proto.Message) {
 	epoch, ok := ctx.Value(epochKey).(uint64)
 	if !ok {
-		f.log.Fatalf("Can not find epochKey or cast is in DataRequest")
+		// The task is told by DataFailed, in background as this might be called
+		// in event loop.
+		fail := &dataFailure{
+			taskID: toID,
+			epoch:  f.epoch,
+			method: method,
+			err:    fmt.Errorf("no epoch in the context of data request"),
+		}
+		go func() {
+			select {
+			case f.dataFailChan <- fail:
+			case <-f.globalStop:
+			}
+		}()
+		return
 	}
 	// assumption here:
 	// Event driven task will call this in a synchronous way so that
@@ -61,6 +76,7 @@ func (f *framework) DataRequest(ctx context.Context, toID uint64, method string,
 		epoch:  epoch,
 		input:  input,
 		method: method,
+		start:  time.Now(),
 	}:
 	case <-ctx.Done():
 		f.log.Printf("abort data request, to %d, epoch %d, method %s", toID, epoch, method)
@@ -77,24 +93,20 @@ func (f *framework) makeGRPCContext(ctx context.Context) context.Context {
 }
 
 func (f *framework) sendRequest(dr *dataRequest) {
-	addr, err := etcdutil.GetAddress(f.etcdClient, f.name, dr.taskID)
-	if err != nil {
-		f.log.Printf("getAddress(%d) failed: %v", dr.taskID, err)
-		go f.retrySendRequest(dr)
+	dr.attempts++
+	if err := f.injector.requestFault(dr.taskID, dr.method, dr.retry); err != nil {
+		f.log.Printf("data request %s to task %d failed: %v", dr.method, dr.taskID, err)
+		go f.retrySendRequest(dr, err)
 		return
 	}
-	// TODO: reuse ClientConn and reply message.
-	// The grpc.WithTimeout would help detect any disconnection in failfast.
-	// Otherwise grpc.Invoke will keep retrying.
-	cc, err := grpc.Dial(addr, grpc.WithTimeout(heartbeatInterval))
+	cc, addr, err := f.connPool.get(dr.taskID)
 	// we need to retry if some task failed and there is a temporary Get request failure.
 	if err != nil {
-		f.log.Printf("grpc.Dial to task %d (addr: %s) failed: %v", dr.taskID, addr, err)
+		f.log.Printf("connect to task %d (addr: %s) failed: %v", dr.taskID, addr, err)
 		// Should retry for other errors.
-		go f.retrySendRequest(dr)
+		go f.retrySendRequest(dr, err)
 		return
 	}
-	defer cc.Close()
 	if dr.retry {
 		f.log.Printf("retry data request %s to task %d, addr %s", dr.method, dr.taskID, addr)
 	} else {
@@ -104,7 +116,9 @@ func (f *framework) sendRequest(dr *dataRequest) {
 	err = grpc.Invoke(dr.ctx, dr.method, dr.input, reply, cc)
 	if err != nil {
 		f.log.Printf("grpc.Invoke to task %d (addr: %s), method: %s, failed: %v", dr.taskID, addr, dr.method, err)
-		go f.retrySendRequest(dr)
+		// The connection might be broken. Dial again next time.
+		f.connPool.evict(dr.taskID, cc)
+		go f.retrySendRequest(dr, err)
 		return
 	}
 
@@ -121,10 +135,28 @@ func (f *framework) sendRequest(dr *dataRequest) {
 	}
 }
 
-func (f *framework) retrySendRequest(dr *dataRequest) {
+func (f *framework) retrySendRequest(dr *dataRequest, err error) {
+	if retryExhausted(f.retryPolicy, dr.attempts, dr.start) {
+		f.log.Printf("give up data request %s to task %d after %d attempts", dr.method, dr.taskID, dr.attempts)
+		select {
+		case f.dataFailChan <- &dataFailure{
+			taskID: dr.taskID,
+			epoch:  dr.epoch,
+			method: dr.method,
+			err:    err,
+		}:
+		case <-dr.ctx.Done():
+		}
+		return
+	}
 	// we try again after the previous task key expires and hopefully another task
 	// gets up and running.
-	time.Sleep(2 * heartbeatInterval)
+	select {
+	case <-time.After(retryDelay(f.retryPolicy, dr.attempts)):
+	case <-dr.ctx.Done():
+		f.log.Printf("abort data request, to %d, epoch %d, method %s", dr.taskID, dr.epoch, dr.method)
+		return
+	}
 	dr.retry = true
 	select {
 	case f.dataReqtoSendChan <- dr:
@@ -140,6 +172,10 @@ func (f *framework) retrySendRequest(dr *dataRequest) {
 func (f *framework) startHTTP() {
 	f.log.Printf("serving grpc on %s\n", f.ln.Addr())
 	server := f.task.CreateServer()
+	// Backups pull update logs over the framework owned Backup service.
+	if _, ok := f.task.(taskgraph.Backupable); ok {
+		pb.RegisterBackupServer(server, &backupServer{f})
+	}
 	err := server.Serve(f.ln)
 	select {
 	case <-f.globalStop:
@@ -147,7 +183,7 @@ func (f *framework) startHTTP() {
 		f.log.Printf("grpc stops serving")
 	default:
 		if err != nil {
-			f.log.Fatalf("grpc.Serve returns error: %v\n", err)
+			f.reportError(&taskgraph.FrameworkError{Op: "grpc.Serve", Err: err})
 		}
 	}
 }
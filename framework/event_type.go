@@ -1,6 +1,8 @@
 package framework
 
 import (
+	"time"
+
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 )
@@ -19,6 +21,9 @@ type dataRequest struct {
 	input  proto.Message
 	method string
 	retry  bool
+	// used by retry policy
+	attempts int
+	start    time.Time
 }
 
 type dataResponse struct {
@@ -29,6 +34,13 @@ type dataResponse struct {
 	output proto.Message
 }
 
+type dataFailure struct {
+	taskID uint64
+	epoch  uint64
+	method string
+	err    error
+}
+
 type epochCheck struct {
 	epoch   uint64
 	resChan chan bool
@@ -41,3 +53,18 @@ func (c *epochCheck) fail() {
 func (c *epochCheck) pass() {
 	c.resChan <- true
 }
+
+// notification received by master or worker. It's handled in event loop one
+// at a time and the reply is sent back through retChan.
+type notification struct {
+	ctx      context.Context
+	workerID uint64
+	method   string
+	input    proto.Message
+	retChan  chan *notifyReply
+}
+
+type notifyReply struct {
+	output proto.Message
+	err    error
+}
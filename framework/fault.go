@@ -0,0 +1,179 @@
+package framework
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type faultKind int
+
+const (
+	faultKill faultKind = iota
+	faultDropRequest
+	faultDelayMeta
+	faultPartition
+)
+
+// Fault is a failure injected into the framework for testing. Faults are created
+// by KillAtEpoch, DropRequest, DelayMeta and Partition, and given to bootstraps by
+// WithFaults. Each task only applies the faults that concern it.
+type Fault struct {
+	kind        faultKind
+	taskID      uint64
+	epoch       uint64
+	method      string
+	nth         int
+	delay       time.Duration
+	groupA      []uint64
+	groupB      []uint64
+	probability float64
+}
+
+// KillAtEpoch kills task taskID when the job moves into the epoch, as if the node
+// crashed. A node that takes over the task at that epoch isn't killed again.
+func KillAtEpoch(taskID, epoch uint64) Fault {
+	return Fault{kind: faultKill, taskID: taskID, epoch: epoch}
+}
+
+// DropRequest fails the nth (from 1) data request of the method sent by task
+// fromID. The request is retried as if the connection was broken.
+func DropRequest(fromID uint64, method string, nth int) Fault {
+	return Fault{kind: faultDropRequest, taskID: fromID, method: method, nth: nth}
+}
+
+// DelayMeta holds each meta delivered to task toID for d.
+func DelayMeta(toID uint64, d time.Duration) Fault {
+	return Fault{kind: faultDelayMeta, taskID: toID, delay: d}
+}
+
+// Partition fails data requests between tasks in a and tasks in b, in both
+// directions, for d since the task starts. Coordination isn't affected.
+func Partition(a, b []uint64, d time.Duration) Fault {
+	return Fault{kind: faultPartition, groupA: a, groupB: b, delay: d}
+}
+
+// WithProbability makes the fault happen with probability p each time it could.
+// It's 0 by default, which means always. The faults of a task are driven by the
+// seed given to WithFaults.
+func (ft Fault) WithProbability(p float64) Fault {
+	ft.probability = p
+	return ft
+}
+
+// faultPlan is what a bootstrap is configured with. Each task makes its own
+// injector from it once it knows its ID.
+type faultPlan struct {
+	seed   int64
+	faults []Fault
+}
+
+// WithFaults injects the faults into the bootstrap. The same seed and faults
+// reproduce the same failures.
+func WithFaults(seed int64, faults ...Fault) Option {
+	return func(c *config) { c.faults = &faultPlan{seed: seed, faults: faults} }
+}
+
+// faultInjector decides for a task whether a fault happens. A nil one injects
+// nothing.
+type faultInjector struct {
+	mu     sync.Mutex
+	taskID uint64
+	start  time.Time
+	rng    *rand.Rand
+	faults []Fault
+	// number of requests sent, keyed by method.
+	requests map[string]int
+}
+
+func newFaultInjector(plan *faultPlan, taskID uint64) *faultInjector {
+	if plan == nil {
+		return nil
+	}
+	return &faultInjector{
+		taskID:   taskID,
+		start:    time.Now(),
+		rng:      rand.New(rand.NewSource(plan.seed + int64(taskID))),
+		faults:   plan.faults,
+		requests: make(map[string]int),
+	}
+}
+
+// killAt tells whether the task should be killed as entering the epoch.
+func (fi *faultInjector) killAt(epoch uint64) bool {
+	if fi == nil {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	for _, ft := range fi.faults {
+		if ft.kind == faultKill && ft.taskID == fi.taskID && ft.epoch == epoch && fi.happen(ft) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestFault returns the error to fail the request with, if any. Retries of a
+// request aren't counted as new ones.
+func (fi *faultInjector) requestFault(toID uint64, method string, retry bool) error {
+	if fi == nil {
+		return nil
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	if !retry {
+		fi.requests[method]++
+	}
+	for _, ft := range fi.faults {
+		switch ft.kind {
+		case faultDropRequest:
+			if !retry && ft.taskID == fi.taskID && ft.method == method && ft.nth == fi.requests[method] && fi.happen(ft) {
+				return fmt.Errorf("fault injected: drop request %s #%d to task %d", method, ft.nth, toID)
+			}
+		case faultPartition:
+			if time.Since(fi.start) < ft.delay && separated(ft, fi.taskID, toID) && fi.happen(ft) {
+				return fmt.Errorf("fault injected: task %d is partitioned from task %d", fi.taskID, toID)
+			}
+		}
+	}
+	return nil
+}
+
+// metaDelay returns how long to hold a meta before delivering it to the task.
+func (fi *faultInjector) metaDelay() time.Duration {
+	if fi == nil {
+		return 0
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var d time.Duration
+	for _, ft := range fi.faults {
+		if ft.kind == faultDelayMeta && ft.taskID == fi.taskID && fi.happen(ft) {
+			d += ft.delay
+		}
+	}
+	return d
+}
+
+func (fi *faultInjector) happen(ft Fault) bool {
+	if ft.probability == 0 {
+		return true
+	}
+	return fi.rng.Float64() < ft.probability
+}
+
+func separated(ft Fault, from, to uint64) bool {
+	return (contains(ft.groupA, from) && contains(ft.groupB, to)) ||
+		(contains(ft.groupB, from) && contains(ft.groupA, to))
+}
+
+func contains(ids []uint64, id uint64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package framework
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFaultInjector(t *testing.T) {
+	plan := &faultPlan{faults: []Fault{
+		KillAtEpoch(3, 5),
+		DropRequest(1, "GetTShard", 2),
+		DelayMeta(1, 500*time.Millisecond),
+		Partition([]uint64{0, 1}, []uint64{2, 3}, time.Hour),
+	}}
+
+	if fi := newFaultInjector(nil, 1); fi.killAt(5) || fi.requestFault(2, "m", false) != nil || fi.metaDelay() != 0 {
+		t.Errorf("nil injector should inject nothing")
+	}
+
+	fi3 := newFaultInjector(plan, 3)
+	if fi3.killAt(4) {
+		t.Errorf("task 3 killed at epoch 4")
+	}
+	if !fi3.killAt(5) {
+		t.Errorf("task 3 not killed at epoch 5")
+	}
+	if fi3.metaDelay() != 0 {
+		t.Errorf("task 3 meta delay want = 0, get = %v", fi3.metaDelay())
+	}
+
+	fi1 := newFaultInjector(plan, 1)
+	if fi1.killAt(5) {
+		t.Errorf("task 1 killed at epoch 5")
+	}
+	if d := fi1.metaDelay(); d != 500*time.Millisecond {
+		t.Errorf("task 1 meta delay want = 500ms, get = %v", d)
+	}
+	tests := []struct {
+		toID   uint64
+		method string
+		retry  bool
+		fail   bool
+	}{
+		{0, "GetTShard", false, false},
+		{0, "GetTShard", false, true},
+		// retry of the dropped one
+		{0, "GetTShard", true, false},
+		{0, "GetTShard", false, false},
+		{0, "Other", false, false},
+		{2, "Other", false, true},
+		{3, "Other", true, true},
+	}
+	for i, tt := range tests {
+		err := fi1.requestFault(tt.toID, tt.method, tt.retry)
+		if (err != nil) != tt.fail {
+			t.Errorf("#%d: request fail want = %v, get = %v", i, tt.fail, err)
+		}
+	}
+	if err := newFaultInjector(plan, 2).requestFault(1, "Other", false); err == nil {
+		t.Errorf("partition should apply in both directions")
+	}
+}
+
+func TestFaultInjectorSeed(t *testing.T) {
+	plan := &faultPlan{seed: 7, faults: []Fault{DelayMeta(0, time.Second).WithProbability(0.5)}}
+	run := func() []time.Duration {
+		fi := newFaultInjector(plan, 0)
+		var ds []time.Duration
+		for i := 0; i < 20; i++ {
+			ds = append(ds, fi.metaDelay())
+		}
+		return ds
+	}
+	a, b := run(), run()
+	delayed := 0
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("#%d: same seed gives different faults: %v, %v", i, a[i], b[i])
+		}
+		if a[i] != 0 {
+			delayed++
+		}
+	}
+	if delayed == 0 || delayed == len(a) {
+		t.Errorf("faults with probability 0.5 happen %d out of %d times", delayed, len(a))
+	}
+}
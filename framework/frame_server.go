@@ -0,0 +1,47 @@
+package framework
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/framework/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// frameServer implements the framework owned Frame service. Each request carries
+// the application method and serialized input. It's decoded with task's helper
+// and then goes through Intercept, which dispatches it to task's handlers.
+type frameServer struct {
+	helper      taskgraph.GRPCHelper
+	interceptor taskgraph.GRPCHandlerInterceptor
+}
+
+func newFrameServer(helper taskgraph.GRPCHelper, interceptor taskgraph.GRPCHandlerInterceptor) *grpc.Server {
+	server := grpc.NewServer()
+	pb.RegisterFrameServer(server, &frameServer{
+		helper:      helper,
+		interceptor: interceptor,
+	})
+	return server
+}
+
+func (s *frameServer) Call(ctx context.Context, req *pb.Request) (*pb.Response, error) {
+	input := s.helper.CreateInputMessage(req.Method)
+	if input == nil {
+		return nil, fmt.Errorf("unknown method: %s", req.Method)
+	}
+	if err := proto.Unmarshal(req.Input, input); err != nil {
+		return nil, err
+	}
+	output, err := s.interceptor.Intercept(ctx, req.Method, input)
+	if err != nil {
+		return nil, err
+	}
+	out, err := proto.Marshal(output)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Response{Output: out}, nil
+}
@@ -3,16 +3,15 @@ package framework
 import (
 	"fmt"
 	"log"
-	"math"
 	"net"
+	"sync"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 	"golang.org/x/net/context"
 )
 
-const exitEpoch = math.MaxUint64
+const exitEpoch = taskgraph.ExitEpoch
 
 type framework struct {
 	// These should be passed by outside world
@@ -23,12 +22,30 @@ type framework struct {
 	// user defined interfaces
 	taskBuilder taskgraph.TaskBuilder
 	topology    taskgraph.Topology
-
-	task          taskgraph.Task
-	taskID        uint64
-	epoch         uint64
-	etcdClient    *etcd.Client
+	retryPolicy taskgraph.RetryPolicy
+	config
+
+	task   taskgraph.Task
+	taskID uint64
+	// non-zero while the framework backs up the task.
+	replicaID uint64
+	epoch     uint64
+	// number of tasks at the epoch. Zero if the job isn't resizable.
+	numOfTasks uint64
+	// recorded sizes of the job, kept up to date by a watch once read, see jobSize.
+	sizes         map[string]string
+	sizesMu       sync.Mutex
+	sizeWatchStop chan bool
+	// how many epochs the task can run ahead of the job's epoch, which is that of
+	// the slowest task. Zero unless the job is stale-synchronous, where the task
+	// is done with epochs before clock.
+	staleness     uint64
+	jobEpoch      uint64
+	clock         uint64
 	ln            net.Listener
+	connPool      *connPool
+	injector      *faultInjector
+	updates       *updateLogs
 	userCtx       context.Context
 	userCtxCancel context.CancelFunc
 
@@ -42,13 +59,18 @@ type framework struct {
 	epochWatchStop chan bool
 
 	globalStop chan struct{}
+	killChan   chan struct{}
+	killOnce   sync.Once
 
 	// event loop
 	epochWatcher      chan uint64
 	metaChan          chan *metaChange
 	dataReqtoSendChan chan *dataRequest
 	dataRespChan      chan *dataResponse
+	dataFailChan      chan *dataFailure
+	errChan           chan error
 	epochCheckChan    chan *epochCheck
+	clockChan         chan uint64
 }
 
 // The key type is unexported to prevent collisions with context keys defined in
@@ -60,32 +82,56 @@ type contextKey int
 // different integer values.
 const epochKey contextKey = 1
 
-func (f *framework) FlagMeta(ctx context.Context, linkType, meta string) {
+func (f *framework) FlagMeta(ctx context.Context, linkType, meta string) error {
 	epoch, ok := ctx.Value(epochKey).(uint64)
 	if !ok {
-		f.log.Fatalf("Can not find epochKey in FlagMeta: %d", epoch)
-	}
-	value := fmt.Sprintf("%d-%s", epoch, meta)
-	_, err := f.etcdClient.Set(etcdutil.MetaPath(linkType, f.name, f.GetTaskID()), value, 0)
-	if err != nil {
-		f.log.Fatalf("etcdClient.Set failed; key: %s, value: %s, error: %v",
-			etcdutil.MetaPath(linkType, f.name, f.GetTaskID()), value, err)
+		return fmt.Errorf("Can not find epochKey in FlagMeta")
 	}
+	return retryEtcd(f.etcdRetryPolicy(), f.log, "FlagMeta", func() error {
+		return f.coordinator.SetMeta(f.name, linkType, f.GetTaskID(), epoch, meta)
+	})
 }
 
 // When app code invoke this method on framework, we simply
 // update the etcd epoch to next uint64. All nodes should watch
 // for epoch and update their local epoch correspondingly.
-func (f *framework) IncEpoch(ctx context.Context) {
+func (f *framework) IncEpoch(ctx context.Context) error {
 	epoch, ok := ctx.Value(epochKey).(uint64)
 	if !ok {
-		f.log.Fatalf("Can not find epochKey in IncEpoch")
+		return fmt.Errorf("Can not find epochKey in IncEpoch")
+	}
+	return f.retryMoveEpoch("IncEpoch", epoch, func() error {
+		return f.coordinator.CASEpoch(f.name, epoch, epoch+1)
+	})
+}
+
+// retryMoveEpoch retries fn, which moves the job from the epoch to the next one
+// only if it's still at the epoch. A retry fails its compare if an earlier try
+// has moved the job but its reply was lost. That's taken as done.
+func (f *framework) retryMoveEpoch(op string, epoch uint64, fn func() error) error {
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, op, fn)
+	if fe, ok := err.(*taskgraph.FrameworkError); !ok || !etcdutil.IsCompareFailed(fe.Err) {
+		return err
 	}
-	err := etcdutil.CASEpoch(f.etcdClient, f.name, epoch, epoch+1)
-	if err != nil {
-		f.log.Fatalf("task %d Epoch CompareAndSwap(%d, %d) failed: %v",
-			f.taskID, epoch+1, epoch, err)
+	if current, gerr := f.coordinator.GetEpoch(f.name); gerr == nil && current == epoch+1 {
+		return nil
 	}
+	return err
+}
+
+// EpochDone reports the task done with the epoch. The coordinator moves the job
+// on once enough tasks have. A task of a stale-synchronous job moves on by itself.
+func (f *framework) EpochDone(ctx context.Context) error {
+	epoch, ok := ctx.Value(epochKey).(uint64)
+	if !ok {
+		return fmt.Errorf("Can not find epochKey in EpochDone")
+	}
+	if f.staleness > 0 {
+		return f.clockDone(ctx, epoch)
+	}
+	return f.retryMoveEpoch("EpochDone", epoch, func() error {
+		return f.coordinator.ReportEpochDone(f.name, epoch, f.taskID)
+	})
 }
 
 func (f *framework) GetTopology() taskgraph.Topology { return f.topology }
@@ -93,17 +139,43 @@ func (f *framework) GetTopology() taskgraph.Topology { return f.topology }
 func (f *framework) Kill() {
 	// framework select loop will quit and end like getting a exit epoch, except that
 	// it won't set exit epoch across cluster.
-	close(f.epochWatcher)
+	// It's safe to call Kill more than once, and out of the event loop.
+	f.killOnce.Do(func() { close(f.killChan) })
 }
 
 // When node call this on framework, it simply set epoch to exitEpoch,
 // All nodes will be notified of the epoch change and exit themselves.
-func (f *framework) ShutdownJob() {
-	if err := etcdutil.CASEpoch(f.etcdClient, f.name, f.epoch, exitEpoch); err != nil {
-		panic("TODO: we should do a set instead of CAS here.")
+func (f *framework) ShutdownJob() error {
+	return f.endJob(taskgraph.JobStatus{State: taskgraph.JobSucceeded})
+}
+
+func (f *framework) FailJob(reason string) error {
+	return f.endJob(taskgraph.JobStatus{State: taskgraph.JobFailed, Reason: reason})
+}
+
+// endJob sets the terminal status before exit epoch, so that status is there
+// once tasks start to exit. If the job is already done, e.g. cancelled, tasks
+// still exit but the status is kept.
+func (f *framework) endJob(status taskgraph.JobStatus) error {
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, "SetJobStatus", func() error {
+		return f.coordinator.SetJobStatus(f.name, status)
+	})
+	if fe, ok := err.(*taskgraph.FrameworkError); ok && etcdutil.IsCompareFailed(fe.Err) {
+		f.log.Printf("job is already done, status %v is dropped", status)
+	} else if err != nil {
+		return err
 	}
-	if err := etcdutil.SetJobStatus(f.etcdClient, f.name, 0); err != nil {
-		panic("SetJobStatus")
+	return retryEtcd(f.etcdRetryPolicy(), f.log, "ShutdownJob", func() error {
+		return f.coordinator.SetEpoch(f.name, exitEpoch)
+	})
+}
+
+// reportError sends background failure to event loop, which informs the task.
+func (f *framework) reportError(err error) {
+	select {
+	case f.errChan <- err:
+	case <-f.globalStop:
+		f.log.Printf("framework stopped, drop error: %v", err)
 	}
 }
 
@@ -6,15 +6,16 @@ import (
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/golang/protobuf/proto"
 	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/controller"
 	"github.com/taskgraph/taskgraph/example/topo"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
 
 	pb "github.com/taskgraph/taskgraph/example/regression/proto"
-	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
@@ -25,8 +26,8 @@ import (
 func TestRequestDataEpochMismatch(t *testing.T) {
 	t.Skip("TODO")
 	job := "TestRequestDataEpochMismatch"
-	etcdURLs := []string{"http://localhost:4001"}
-	ctl := controller.New(job, etcd.NewClient(etcdURLs), 1, []string{"Parents", "Children"})
+	etcdURLs := []string{"http://localhost:2379"}
+	ctl := controller.New(job, newEtcdClient(t), 1, []string{"Parents", "Children"})
 	ctl.InitEtcdLayout()
 	defer ctl.DestroyEtcdLayout()
 
@@ -34,6 +35,7 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 		name:     job,
 		etcdURLs: etcdURLs,
 		ln:       createListener(t),
+		config:   defaultConfig(),
 	}
 	var wg sync.WaitGroup
 	fw.SetTaskBuilder(&testableTaskBuilder{
@@ -45,7 +47,7 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 	wg.Wait()
 	defer fw.ShutdownJob()
 
-	addr, err := etcdutil.GetAddress(fw.etcdClient, job, fw.GetTaskID())
+	addr, err := fw.coordinator.GetAddress(job, fw.GetTaskID())
 	if err != nil {
 		t.Fatalf("GetAddress failed: %v", err)
 	}
@@ -56,6 +58,20 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 	// }
 }
 
+// A data request without epoch in its context should fail by DataFailed.
+func TestDataRequestNoEpoch(t *testing.T) {
+	f := &framework{
+		globalStop:   make(chan struct{}),
+		dataFailChan: make(chan *dataFailure, 1),
+	}
+	defer close(f.globalStop)
+	f.DataRequest(context.Background(), 1, "/proto.Regression/GetParameter", nil)
+	fail := <-f.dataFailChan
+	if fail.taskID != 1 || fail.method != "/proto.Regression/GetParameter" || fail.err == nil {
+		t.Errorf("data failure want of task 1 and GetParameter with error, get = %+v", fail)
+	}
+}
+
 // TestFrameworkFlagMetaReady and TestFrameworkDataRequest test basic workflows of
 // framework impl. It uses a scenario with two nodes: 0 as parent, 1 as child.
 // The basic idea is that when parent tries to talk to child and vice versa,
@@ -64,9 +80,9 @@ func TestRequestDataEpochMismatch(t *testing.T) {
 // it's passed from framework correctly and unmodified.
 func TestFrameworkFlagMetaReady(t *testing.T) {
 	appName := "framework_test_flagmetaready"
-	etcdURLs := []string{"http://localhost:4001"}
+	etcdURLs := []string{"http://localhost:2379"}
 	// launch controller to setup etcd layout
-	ctl := controller.New(appName, etcd.NewClient(etcdURLs), 2, []string{"Parents", "Children"})
+	ctl := controller.New(appName, newEtcdClient(t), 2, []string{"Parents", "Children"})
 	if err := ctl.InitEtcdLayout(); err != nil {
 		t.Fatalf("initEtcdLayout failed: %v", err)
 	}
@@ -81,11 +97,13 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 		name:     appName,
 		etcdURLs: etcdURLs,
 		ln:       createListener(t),
+		config:   defaultConfig(),
 	}
 	f1 := &framework{
 		name:     appName,
 		etcdURLs: etcdURLs,
 		ln:       createListener(t),
+		config:   defaultConfig(),
 	}
 
 	var wg sync.WaitGroup
@@ -120,7 +138,9 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 	ctx := context.WithValue(context.Background(), epochKey, uint64(0))
 	for i, tt := range tests {
 		// 0: F#FlagChildMetaReady -> 1: T#ParentMetaReady
-		f0.FlagMeta(ctx, "Parents", tt.cMeta)
+		if err := f0.FlagMeta(ctx, "Parents", tt.cMeta); err != nil {
+			t.Fatalf("FlagMeta failed: %v", err)
+		}
 		// from child(1)'s view
 		data := <-cDataChan
 		expected := &tDataBundle{id: 0, meta: tt.cMeta}
@@ -129,7 +149,9 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 		}
 
 		// 1: F#FlagParentMetaReady -> 0: T#ChildMetaReady
-		f1.FlagMeta(ctx, "Children", tt.pMeta)
+		if err := f1.FlagMeta(ctx, "Children", tt.pMeta); err != nil {
+			t.Fatalf("FlagMeta failed: %v", err)
+		}
 		// from parent(0)'s view
 		data = <-pDataChan
 		expected = &tDataBundle{id: 1, meta: tt.pMeta}
@@ -141,9 +163,9 @@ func TestFrameworkFlagMetaReady(t *testing.T) {
 
 func TestFrameworkDataRequest(t *testing.T) {
 	appName := "framework_test_datarequest"
-	etcdURLs := []string{"http://localhost:4001"}
+	etcdURLs := []string{"http://localhost:2379"}
 	// launch controller to setup etcd layout
-	ctl := controller.New(appName, etcd.NewClient(etcdURLs), 2, []string{"Parents", "Children"})
+	ctl := controller.New(appName, newEtcdClient(t), 2, []string{"Parents", "Children"})
 	if err := ctl.InitEtcdLayout(); err != nil {
 		t.Fatalf("initEtcdLayout failed: %v", err)
 	}
@@ -157,11 +179,13 @@ func TestFrameworkDataRequest(t *testing.T) {
 		name:     appName,
 		etcdURLs: etcdURLs,
 		ln:       createListener(t),
+		config:   defaultConfig(),
 	}
 	f1 := &framework{
 		name:     appName,
 		etcdURLs: etcdURLs,
 		ln:       createListener(t),
+		config:   defaultConfig(),
 	}
 
 	var wg sync.WaitGroup
@@ -208,6 +232,112 @@ func TestFrameworkDataRequest(t *testing.T) {
 	}
 }
 
+// TestFrameworkOnMemCoordinator runs the parent-child scenario above without etcd.
+// All tasks and controller share one in-memory store.
+func TestFrameworkOnMemCoordinator(t *testing.T) {
+	appName := "framework_test_memcoordinator"
+	store := memcoord.NewStore()
+	ctl := controller.NewWithCoordinator(appName, store.NewCoordinator(), 2, []string{"Parents", "Children"})
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	pDataChan := make(chan *tDataBundle, 1)
+	cDataChan := make(chan *tDataBundle, 1)
+	f0 := &framework{
+		name:   appName,
+		ln:     createListener(t),
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	f1 := &framework{
+		name:   appName,
+		ln:     createListener(t),
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+
+	var wg sync.WaitGroup
+	taskBuilder := &testableTaskBuilder{
+		cDataChan:  cDataChan,
+		pDataChan:  pDataChan,
+		setupLatch: &wg,
+	}
+	f0.SetTaskBuilder(taskBuilder)
+	f0.SetTopology(topo.NewTreeTopology(2, 2))
+	f1.SetTaskBuilder(taskBuilder)
+	f1.SetTopology(topo.NewTreeTopology(2, 2))
+
+	taskBuilder.setupLatch.Add(2)
+	go f0.Start()
+	go f1.Start()
+	taskBuilder.setupLatch.Wait()
+	if f0.GetTaskID() != 0 {
+		f0, f1 = f1, f0
+	}
+	defer f0.ShutdownJob()
+
+	ctx := context.WithValue(context.Background(), epochKey, uint64(0))
+	if err := f0.FlagMeta(ctx, "Parents", "parent"); err != nil {
+		t.Fatalf("FlagMeta failed: %v", err)
+	}
+	data := <-cDataChan
+	expected := &tDataBundle{id: 0, meta: "parent"}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+
+	f1.DataRequest(ctx, 0, "/proto.Regression/GetParameter", nil)
+	data = <-cDataChan
+	expected = &tDataBundle{
+		id:     0,
+		method: "/proto.Regression/GetParameter",
+		output: &pb.Parameter{Value: 1},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("data bundle want = %v, get = %v", expected, data)
+	}
+}
+
+// TestKillWaitingForFreeTask checks that a bootstrap waiting for a free task
+// stops once killed.
+func TestKillWaitingForFreeTask(t *testing.T) {
+	appName := "framework_test_kill_waiting"
+	store := memcoord.NewStore()
+	ctl := controller.NewWithCoordinator(appName, store.NewCoordinator(), 1, []string{"Parents", "Children"})
+	if err := ctl.InitEtcdLayout(); err != nil {
+		t.Fatalf("InitEtcdLayout failed: %v", err)
+	}
+	defer ctl.DestroyEtcdLayout()
+
+	// The only task is taken, so the bootstrap has to wait.
+	c := store.NewCoordinator()
+	c.StartSession(50*time.Millisecond, 3)
+	heartbeatStop := make(chan struct{})
+	defer close(heartbeatStop)
+	go c.Heartbeat(heartbeatStop)
+	if ok, err := c.TryOccupyTask(appName, 0, "addr0"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+
+	bootstrap := NewBootStrapWithOptions(appName, nil, createListener(t), nil,
+		WithCoordinator(store.NewCoordinator()), WithHeartbeatInterval(50*time.Millisecond))
+	bootstrap.SetTaskBuilder(&testableTaskBuilder{})
+	bootstrap.SetTopology(topo.NewTreeTopology(2, 1))
+	done := make(chan error, 1)
+	go func() { done <- bootstrap.Start() }()
+
+	time.Sleep(100 * time.Millisecond)
+	bootstrap.(taskgraph.Framework).Kill()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Start want = nil, get = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("killed bootstrap is still waiting for a free task")
+	}
+}
+
 type tDataBundle struct {
 	id     uint64
 	meta   string
@@ -267,6 +397,9 @@ func (t *testableTask) DataReady(ctx context.Context, fromID uint64, method stri
 	t.dataChan <- &tDataBundle{id: fromID, method: method, output: output}
 }
 
+func (t *testableTask) DataFailed(ctx context.Context, toID uint64, method string, err error) {}
+func (t *testableTask) OnFrameworkError(err error)                                            {}
+
 // These are payload rpc for application purpose.
 func (t *testableTask) GetParameter(ctx context.Context, input *pb.Input) (*pb.Parameter, error) {
 	return &pb.Parameter{1}, nil
@@ -294,6 +427,14 @@ func (t *testableTask) CreateServer() *grpc.Server {
 	return server
 }
 
+func newEtcdClient(t *testing.T) *clientv3.Client {
+	c, err := clientv3.New(clientv3.Config{Endpoints: []string{"http://localhost:2379"}})
+	if err != nil {
+		t.Fatalf("create etcd client failed: %v", err)
+	}
+	return c
+}
+
 func createListener(t *testing.T) net.Listener {
 	l, err := net.Listen("tcp4", "127.0.0.1:0")
 	if err != nil {
@@ -1,21 +1,38 @@
 package framework
 
-import (
-	"time"
-
-	"github.com/taskgraph/taskgraph/pkg/etcdutil"
-)
-
-var (
-	heartbeatInterval = 1 * time.Second
-)
-
 func (f *framework) heartbeat() {
 	f.globalStop = make(chan struct{})
 	go func() {
-		err := etcdutil.Heartbeat(f.etcdClient, f.name, f.taskID, heartbeatInterval, f.globalStop)
+		err := f.coordinator.Heartbeat(f.globalStop)
 		if err != nil {
 			f.log.Printf("Heartbeat stops with error: %v\n", err)
 		}
 	}()
 }
+
+func (w *worker) heartbeat() {
+	go func() {
+		err := w.coordinator.Heartbeat(w.stopChan)
+		if err != nil {
+			w.logger.Printf("Heartbeat stops with error: %v\n", err)
+		}
+	}()
+}
+
+// Master watches workers' heartbeat. Failed worker is reported so that
+// a standby worker can take over, and the master task is notified.
+func (m *master) startFailureDetection() {
+	m.failDetectStop = make(chan bool, 1)
+	go func() {
+		err := m.coordinator.DetectWorkerFailure(m.job, m.failDetectStop, func(workerID uint64) {
+			m.logger.Printf("worker %d failed", workerID)
+			select {
+			case m.workerFailChan <- workerID:
+			case <-m.stopChan:
+			}
+		})
+		if err != nil {
+			m.logger.Printf("DetectWorkerFailure returns error: %v", err)
+		}
+	}()
+}
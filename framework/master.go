@@ -4,22 +4,34 @@ import (
 	"log"
 	"net"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/taskgraph/taskgraph"
 )
 
 type master struct {
-	job        string
-	etcdURL    []string
-	listener   net.Listener
-	logger     *log.Logger
-	task       taskgraph.MasterTask
-	workerNum  uint64
-	etcdClient *etcd.Client
-	stopChan   chan struct{}
+	job       string
+	etcdURL   []string
+	listener  net.Listener
+	logger    *log.Logger
+	task      taskgraph.MasterTask
+	workerNum uint64
+	stopChan  chan struct{}
+	config
+
+	failDetectStop  chan bool
+	workerWatchStop chan bool
+
+	// event loop
+	notifyChan     chan *notification
+	workerJoinChan chan uint64
+	workerFailChan chan uint64
+	readyChan      chan chan struct{}
+
+	// These are only accessed in event loop.
+	workers      map[uint64]bool
+	readyWaiters []chan struct{}
 }
 
-func NewMasterBoot(job string, etcdURL []string, ln net.Listener, logger *log.Logger, task taskgraph.MasterTask, workerNum uint64) taskgraph.Bootup {
+func NewMasterBoot(job string, etcdURL []string, ln net.Listener, logger *log.Logger, task taskgraph.MasterTask, workerNum uint64, opts ...Option) taskgraph.Bootup {
 	return &master{
 		job:       job,
 		etcdURL:   etcdURL,
@@ -27,5 +39,6 @@ func NewMasterBoot(job string, etcdURL []string, ln net.Listener, logger *log.Lo
 		logger:    logger,
 		task:      task,
 		workerNum: workerNum,
+		config:    newConfig(opts),
 	}
 }
@@ -1,7 +1,11 @@
 package framework
 
 import (
-	"github.com/coreos/go-etcd/etcd"
+	"log"
+	"os"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 	"golang.org/x/net/context"
 )
@@ -9,51 +13,133 @@ import (
 // Master is guaranteed the first to run. It mainly does the following:
 // - initialize internal structures.
 // - set up etcd. Register master address. Set up layout.
-// - start server. This is the user defined grpc server.
+// - start server. This is the framework owned grpc server.
 // - start event handling. Select and handle events, e.g. sending and receiving messages,
 //   finishing job, etc.
 // - run user task.
 
-func (m *master) Start() {
-	m.init()
-	m.setupEtcd()
+// Start runs the master task until it finishes. It returns an error if the master
+// fails to set up, e.g. etcd isn't reachable.
+func (m *master) Start() error {
+	if err := m.init(); err != nil {
+		return err
+	}
+	if err := m.setupEtcd(); err != nil {
+		m.coordinator.Close()
+		return err
+	}
+	if err := m.startWorkerWatch(); err != nil {
+		m.coordinator.Close()
+		return err
+	}
 	m.startServer()
+	m.startFailureDetection()
 	go m.startEventHandling()
 	m.runUserTask()
 	m.stop()
+	return nil
 }
 
-func (m *master) init() {
-	m.etcdClient = etcd.NewClient(m.etcdURL)
+func (m *master) init() error {
+	if m.logger == nil {
+		m.logger = log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
+	}
+	if m.coordinator == nil {
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   m.etcdURL,
+			DialTimeout: m.dialTimeout,
+		})
+		if err != nil {
+			return &taskgraph.FrameworkError{Op: "connectEtcd", Err: err}
+		}
+		m.coordinator = etcdutil.NewCoordinator(client)
+	}
 	m.stopChan = make(chan struct{})
+	m.notifyChan = make(chan *notification, 1)
+	m.workerJoinChan = make(chan uint64, 1)
+	m.workerFailChan = make(chan uint64, 1)
+	m.readyChan = make(chan chan struct{}, 1)
+	m.workers = make(map[uint64]bool)
+	return nil
 }
 
-func (m *master) setupEtcd() {
-	// init layout
+func (m *master) setupEtcd() error {
 	// register master's addr
-	m.etcdClient.Set(etcdutil.MasterPath(m.job), m.listener.Addr().String(), 0)
+	if err := m.coordinator.RegisterMaster(m.job, m.listener.Addr().String()); err != nil {
+		return &taskgraph.FrameworkError{Op: "RegisterMaster", Err: err}
+	}
+	return nil
 }
 
 func (m *master) startServer() {
-	s := m.task.CreateServer()
+	s := newFrameServer(m.task, m)
 	go s.Serve(m.listener)
 }
 
+// Keep track of registered workers. A worker that takes over a failed one
+// registers again and joins. Workers registered before, e.g. the master has
+// restarted, are there from the start.
+func (m *master) startWorkerWatch() error {
+	m.workerWatchStop = make(chan bool, 1)
+	workers, err := m.coordinator.WatchWorkers(m.job, m.workerWatchStop, func(workerID uint64) {
+		select {
+		case m.workerJoinChan <- workerID:
+		case <-m.stopChan:
+		}
+	})
+	if err != nil {
+		return &taskgraph.FrameworkError{Op: "WatchWorkers", Err: err}
+	}
+	// event loop isn't running yet.
+	for _, workerID := range workers {
+		m.logger.Printf("worker %d registered", workerID)
+		m.workers[workerID] = true
+	}
+	return nil
+}
+
+// This for-select serializes notifications and worker membership changes.
 func (m *master) startEventHandling() {
 	for {
 		select {
+		case n := <-m.notifyChan:
+			output, err := m.task.OnNotify(n.ctx, n.workerID, n.method, n.input)
+			n.retChan <- &notifyReply{output: output, err: err}
+		case workerID := <-m.workerJoinChan:
+			m.logger.Printf("worker %d joined", workerID)
+			m.workers[workerID] = true
+			m.checkWorkersReady()
+		case workerID := <-m.workerFailChan:
+			delete(m.workers, workerID)
+			m.task.OnWorkerFailure(context.Background(), workerID)
+		case ready := <-m.readyChan:
+			m.readyWaiters = append(m.readyWaiters, ready)
+			m.checkWorkersReady()
 		case <-m.stopChan:
 			return
 		}
 	}
 }
 
+func (m *master) checkWorkersReady() {
+	if uint64(len(m.workers)) < m.workerNum {
+		return
+	}
+	for _, ready := range m.readyWaiters {
+		close(ready)
+	}
+	m.readyWaiters = nil
+}
+
 func (m *master) runUserTask() {
 	m.task.Setup(m)
 	m.task.Run(context.Background())
 }
 
 func (m *master) stop() {
+	m.failDetectStop <- true
+	m.workerWatchStop <- true
 	m.listener.Close()
 	close(m.stopChan)
+	m.coordinator.Close()
 }
@@ -1,28 +1,205 @@
 package framework
 
 import (
+	"log"
+	"os"
 	"testing"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+	"golang.org/x/net/context"
 )
 
-// Master should register its address in etcd so that workers can find him.
+// Master should register its address so that workers can find him.
 func TestMasterSetupEtcd(t *testing.T) {
 	job := "TestMasterSetupEtcd"
-	etcdClient := etcd.NewClient([]string{"http://localhost:4001"})
+	store := memcoord.NewStore()
 	m := &master{
-		job:        job,
-		etcdClient: etcdClient,
-		listener:   createListener(t),
+		job:      job,
+		listener: createListener(t),
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
 	}
-	m.setupEtcd()
-	resp, err := etcdClient.Get(etcdutil.MasterPath(job), false, false)
+	if err := m.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	value, err := store.NewCoordinator().GetMasterAddress(job)
 	if err != nil {
-		t.Fatalf("etcdClient.Get failed: %v", err)
+		t.Fatalf("GetMasterAddress failed: %v", err)
 	}
 	addr := m.listener.Addr().String()
-	if resp.Node.Value != addr {
-		t.Fatalf("Wrong address on etcd: want = %s, get = %s", addr, resp.Node.Value)
+	if value != addr {
+		t.Fatalf("Wrong address: want = %s, get = %s", addr, value)
+	}
+}
+
+// Master should be notified when a worker stops heartbeating, and the worker ID
+// should be freed for standby workers.
+func TestMasterDetectWorkerFailure(t *testing.T) {
+	job := "TestMasterDetectWorkerFailure"
+	store := memcoord.NewStore()
+
+	task := &testableMasterTask{failures: make(chan uint64, 1)}
+	m := &master{
+		job:      job,
+		listener: createListener(t),
+		logger:   log.New(os.Stdout, "", log.Lshortfile),
+		task:     task,
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := m.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if err := m.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	m.startFailureDetection()
+	if err := m.startWorkerWatch(); err != nil {
+		t.Fatalf("startWorkerWatch failed: %v", err)
+	}
+	go m.startEventHandling()
+	defer m.stop()
+
+	// The worker registers itself and then stops heartbeating.
+	w := &worker{
+		id:       2,
+		job:      job,
+		listener: createListener(t),
+		logger:   log.New(os.Stdout, "", log.Lshortfile),
+		stopChan: make(chan struct{}),
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := w.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	close(w.stopChan)
+
+	select {
+	case id := <-task.failures:
+		if id != 2 {
+			t.Errorf("failed worker want = 2, get = %d", id)
+		}
+	case <-time.After(10 * defaultHeartbeatInterval):
+		t.Fatalf("master didn't detect worker failure")
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	if id, err := store.NewCoordinator().WaitFreeWorker(job, stop, m.logger); err != nil || id != 2 {
+		t.Errorf("WaitFreeWorker want = 2, get = %d, %v", id, err)
+	}
+}
+
+// WorkersReady should return after all workers registered.
+func TestMasterWorkersReady(t *testing.T) {
+	job := "TestMasterWorkersReady"
+	store := memcoord.NewStore()
+
+	m := &master{
+		job:       job,
+		listener:  createListener(t),
+		logger:    log.New(os.Stdout, "", log.Lshortfile),
+		task:      &testableMasterTask{},
+		config:    newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+		workerNum: 2,
+	}
+	if err := m.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if err := m.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	if err := m.startWorkerWatch(); err != nil {
+		t.Fatalf("startWorkerWatch failed: %v", err)
+	}
+	go m.startEventHandling()
+	defer close(m.stopChan)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHeartbeatInterval)
+	defer cancel()
+	if err := m.WorkersReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WorkersReady want = %v, get = %v", context.DeadlineExceeded, err)
+	}
+
+	for i := uint64(0); i < 2; i++ {
+		w := &worker{
+			id:       i,
+			job:      job,
+			listener: createListener(t),
+			config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+		}
+		if err := w.setupEtcd(); err != nil {
+			t.Fatalf("setupEtcd failed: %v", err)
+		}
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*defaultHeartbeatInterval)
+	defer cancel()
+	if err := m.WorkersReady(ctx); err != nil {
+		t.Fatalf("WorkersReady failed: %v", err)
+	}
+}
+
+// A restarted master should count workers registered before it, but not those
+// that have died.
+func TestMasterRestartWorkersReady(t *testing.T) {
+	job := "TestMasterRestartWorkersReady"
+	etcdClient := newEtcdClient(t)
+	defer etcdClient.Delete(context.Background(), "/"+job, clientv3.WithPrefix())
+
+	for i := uint64(0); i < 2; i++ {
+		w := &worker{
+			id:       i,
+			job:      job,
+			listener: createListener(t),
+			config:   newConfig([]Option{WithCoordinator(etcdutil.NewCoordinator(etcdClient))}),
+		}
+		if err := w.setupEtcd(); err != nil {
+			t.Fatalf("setupEtcd failed: %v", err)
+		}
+	}
+	// address left by a dead worker, whose healthy key is gone.
+	if _, err := etcdClient.Put(context.Background(), etcdutil.WorkerPath(job, 2), "dead"); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	m := &master{
+		job:       job,
+		listener:  createListener(t),
+		logger:    log.New(os.Stdout, "", log.Lshortfile),
+		task:      &testableMasterTask{},
+		config:    newConfig([]Option{WithCoordinator(etcdutil.NewCoordinator(etcdClient))}),
+		workerNum: 3,
+	}
+	if err := m.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if err := m.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	if err := m.startWorkerWatch(); err != nil {
+		t.Fatalf("startWorkerWatch failed: %v", err)
+	}
+	go m.startEventHandling()
+	defer close(m.stopChan)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultHeartbeatInterval)
+	defer cancel()
+	if err := m.WorkersReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WorkersReady want = %v, get = %v", context.DeadlineExceeded, err)
+	}
+
+	w := &worker{
+		id:       3,
+		job:      job,
+		listener: createListener(t),
+		config:   newConfig([]Option{WithCoordinator(etcdutil.NewCoordinator(etcdClient))}),
+	}
+	if err := w.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*defaultHeartbeatInterval)
+	defer cancel()
+	if err := m.WorkersReady(ctx); err != nil {
+		t.Fatalf("WorkersReady failed: %v", err)
 	}
 }
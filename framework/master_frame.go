@@ -1,14 +1,59 @@
 package framework
 
 import (
+	"fmt"
+
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 )
 
+// NotifyWorker calls the worker's OnNotify and waits for its reply.
+// If the worker fails in between, the call is retried on the new worker
+// that takes over the ID until ctx is done.
 func (m *master) NotifyWorker(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error) {
-	panic("")
+	reply := m.task.CreateOutputMessage(method)
+	ctx = senderContext(ctx, m.job, masterSender)
+	peer := fmt.Sprintf("worker %d", workerID)
+	err := m.invokeWithRetry(ctx, peer, func() (string, error) {
+		return m.coordinator.GetWorkerAddress(m.job, workerID)
+	}, method, input, reply, m.logger)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
 }
 
+// Intercept should be called by the grpc handlers of master task. It figures out which
+// worker sent the request and hands it over to the task's OnNotify.
 func (m *master) Intercept(ctx context.Context, method string, input proto.Message) (proto.Message, error) {
-	panic("")
+	sender, err := parseSender(ctx, m.job)
+	if err != nil {
+		return nil, err
+	}
+	workerID, err := parseWorkerID(sender)
+	if err != nil {
+		return nil, err
+	}
+	return handleNotification(m.notifyChan, m.stopChan, &notification{
+		ctx:      ctx,
+		workerID: workerID,
+		method:   method,
+		input:    input,
+	})
+}
+
+// WorkersReady blocks until all workers have registered or ctx is done.
+func (m *master) WorkersReady(ctx context.Context) error {
+	ready := make(chan struct{})
+	select {
+	case m.readyChan <- ready:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,89 @@
+package framework
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/example/regression/proto"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+	"golang.org/x/net/context"
+)
+
+// Master should find the worker through the coordinator and get the reply of its notification.
+func TestMasterNotifyWorker(t *testing.T) {
+	job := "TestMasterNotifyWorker"
+	store := memcoord.NewStore()
+
+	w := &worker{
+		id:       1,
+		job:      job,
+		listener: createListener(t),
+		logger:   log.New(os.Stdout, "", log.Lshortfile),
+		task:     &testableWorkerTask{},
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := w.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	w.setupEtcd()
+	w.startServer()
+	go w.startEventHandling()
+	defer w.stop()
+
+	m := &master{
+		job:    job,
+		logger: log.New(os.Stdout, "", log.Lshortfile),
+		task:   &testableMasterTask{},
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	reply, err := m.NotifyWorker(context.Background(), 1, "/proto.Regression/GetParameter", &pb.Input{})
+	if err != nil {
+		t.Fatalf("NotifyWorker failed: %v", err)
+	}
+	if p := reply.(*pb.Parameter); p.Value != 1 {
+		t.Errorf("parameter value want = 1, get = %d", p.Value)
+	}
+}
+
+type testableMasterTask struct {
+	frame    taskgraph.MasterFrame
+	failures chan uint64
+}
+
+func (t *testableMasterTask) Setup(frame taskgraph.MasterFrame) { t.frame = frame }
+func (t *testableMasterTask) Run(ctx context.Context)           {}
+
+func (t *testableMasterTask) OnNotify(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error) {
+	return &pb.Gradient{Value: int32(workerID)}, nil
+}
+
+func (t *testableMasterTask) OnWorkerFailure(ctx context.Context, workerID uint64) {
+	if t.failures != nil {
+		t.failures <- workerID
+	}
+}
+
+func (t *testableMasterTask) CreateInputMessage(method string) proto.Message {
+	switch method {
+	case "/proto.Regression/GetGradient":
+		return new(pb.Input)
+	default:
+		log.Panicf("Unknown method: %s", method)
+		return nil
+	}
+}
+
+func (t *testableMasterTask) CreateOutputMessage(method string) proto.Message {
+	switch method {
+	case "/proto.Regression/GetParameter":
+		return new(pb.Parameter)
+	case "/proto.Regression/GetGradient":
+		return new(pb.Gradient)
+	default:
+		log.Panicf("Unknown method: %s", method)
+		return nil
+	}
+}
@@ -0,0 +1,126 @@
+package framework
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/filesystem"
+)
+
+const (
+	defaultHeartbeatInterval = 1 * time.Second
+	defaultTTLMultiplier     = 3
+)
+
+// config holds the liveness and coordination settings of a bootstrap. Each bootstrap has its own
+// so that jobs in the same process don't interfere with each other.
+type config struct {
+	// how often a task refreshes its healthy key.
+	heartbeatInterval time.Duration
+	// healthy key expires after ttlMultiplier heartbeats are missed.
+	ttlMultiplier uint64
+	// how long to wait for a connection to another task.
+	dialTimeout time.Duration
+	// how long to wait before retrying a failed call, hopefully after
+	// another node has taken over the failed one.
+	retryDelay time.Duration
+	// coordinator to use instead of the one on etcdURLs.
+	coordinator taskgraph.Coordinator
+	// faults injected for testing.
+	faults *faultPlan
+	// whether to back up some task instead of working for a free one.
+	backup bool
+	// where snapshots of Checkpointable tasks go.
+	checkpointFS  filesystem.Client
+	checkpointDir string
+}
+
+func defaultConfig() config {
+	return newConfig(nil)
+}
+
+// Option configures a bootstrap.
+type Option func(*config)
+
+// WithHeartbeatInterval sets how often a task refreshes its healthy key.
+// Dial timeout and retry delay scale with it unless they are set explicitly.
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(c *config) { c.heartbeatInterval = d }
+}
+
+// WithTTLMultiplier sets how many heartbeats a task can miss before it's
+// considered failed.
+func WithTTLMultiplier(n uint64) Option {
+	return func(c *config) { c.ttlMultiplier = n }
+}
+
+// WithDialTimeout sets how long to wait for a connection to another task.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *config) { c.dialTimeout = d }
+}
+
+// WithRetryDelay sets how long to wait before retrying a failed call.
+func WithRetryDelay(d time.Duration) Option {
+	return func(c *config) { c.retryDelay = d }
+}
+
+// WithCoordinator makes the bootstrap coordinate through c instead of etcd,
+// e.g. a memcoord one for tests. etcdURLs are ignored then. The bootstrap owns c
+// and closes it when it's done.
+func WithCoordinator(c taskgraph.Coordinator) Option {
+	return func(cfg *config) { cfg.coordinator = c }
+}
+
+// AsBackup makes the bootstrap a hot-standby backup of some task, which takes over
+// the task once its primary fails. Tasks have to be Backupable, and the number of
+// backups is set by the controller, see Controller.SetBackups.
+func AsBackup() Option {
+	return func(c *config) { c.backup = true }
+}
+
+// WithCheckpoint makes Checkpointable tasks save their snapshots to dir through
+// client, at the start of every epoch. A node taking over a task restores the one
+// of current epoch, or the task gets OnFrameworkError if it's lost. Snapshots
+// can't be taken less often, as a task taking over can't go back to an earlier
+// epoch while others go on. A resumed job goes back to the latest checkpoint.
+// dir shouldn't be shared by different runs of the same job.
+func WithCheckpoint(client filesystem.Client, dir string) Option {
+	return func(c *config) {
+		c.checkpointFS = client
+		c.checkpointDir = dir
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		heartbeatInterval: defaultHeartbeatInterval,
+		ttlMultiplier:     defaultTTLMultiplier,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.dialTimeout == 0 {
+		c.dialTimeout = c.heartbeatInterval
+	}
+	if c.retryDelay == 0 {
+		c.retryDelay = 2 * c.heartbeatInterval
+	}
+	return c
+}
+
+// NewBootStrapWithOptions is like NewBootStrap, but the liveness settings can
+// be changed by options, e.g.
+//
+//	NewBootStrapWithOptions(job, etcdURLs, ln, logger, WithHeartbeatInterval(5*time.Second))
+func NewBootStrapWithOptions(jobName string, etcdURLs []string, ln net.Listener, logger *log.Logger, opts ...Option) taskgraph.Bootstrap {
+	return &framework{
+		name:     jobName,
+		etcdURLs: etcdURLs,
+		ln:       ln,
+		log:      logger,
+		config:   newConfig(opts),
+		killChan: make(chan struct{}),
+	}
+}
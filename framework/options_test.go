@@ -0,0 +1,44 @@
+package framework
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		opts []Option
+		want config
+	}{
+		{nil, defaultConfig()},
+		{
+			[]Option{WithHeartbeatInterval(5 * time.Second)},
+			config{
+				heartbeatInterval: 5 * time.Second,
+				ttlMultiplier:     defaultTTLMultiplier,
+				dialTimeout:       5 * time.Second,
+				retryDelay:        10 * time.Second,
+			},
+		},
+		{
+			// explicit settings win over the ones derived from heartbeat interval.
+			[]Option{
+				WithDialTimeout(time.Second),
+				WithRetryDelay(3 * time.Second),
+				WithHeartbeatInterval(5 * time.Second),
+				WithTTLMultiplier(5),
+			},
+			config{
+				heartbeatInterval: 5 * time.Second,
+				ttlMultiplier:     5,
+				dialTimeout:       time.Second,
+				retryDelay:        3 * time.Second,
+			},
+		},
+	}
+	for i, tt := range tests {
+		if c := newConfig(tt.opts); c != tt.want {
+			t.Errorf("#%d: config want = %+v, get = %+v", i, tt.want, c)
+		}
+	}
+}
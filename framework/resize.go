@@ -0,0 +1,123 @@
+package framework
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+// membership is what a task does at an epoch of a resizable job.
+type membership int
+
+const (
+	// the task works for the epoch as usual.
+	member membership = iota
+	// the task is set up by a pending resize, and joins at a later epoch.
+	standby
+	// the task has left the job by resize, and exits.
+	retired
+)
+
+// membership finds out what the task does at current epoch. Once the number of
+// tasks changes, the topology is resized before anything of the epoch starts.
+// Jobs that don't record their size have fixed membership.
+func (f *framework) membership() membership {
+	size, latest, err := f.jobSize()
+	if etcdutil.IsKeyNotFound(err) {
+		return member
+	}
+	if err != nil {
+		// Assume nothing changes. The task can tell whether to go on.
+		f.task.OnFrameworkError(err)
+		return member
+	}
+
+	if size != f.numOfTasks {
+		t, resizable := f.topology.(taskgraph.ResizableTopology)
+		if f.numOfTasks != 0 {
+			f.log.Printf("job resized from %d to %d tasks at epoch %d", f.numOfTasks, size, f.epoch)
+			if !resizable {
+				f.task.OnFrameworkError(&taskgraph.FrameworkError{
+					Op:  "resize",
+					Err: fmt.Errorf("topology isn't resizable, neighbors might be wrong"),
+				})
+			}
+		}
+		if resizable {
+			t.Resize(size)
+		}
+		f.numOfTasks = size
+	}
+	switch {
+	case f.taskID < size:
+		return member
+	case f.taskID < latest:
+		return standby
+	}
+	return retired
+}
+
+// jobSize returns the number of tasks at current epoch, and the latest one. Sizes
+// are read once and then watched, rather than read every epoch. The watch isn't
+// ordered with that of the epoch though, so the size recorded for current epoch,
+// i.e. by a resize at the last one, is read unless it's seen already.
+// ErrKeyNotFound of etcdutil is returned if the job never records its size.
+func (f *framework) jobSize() (size, latest uint64, err error) {
+	if f.sizeWatchStop == nil {
+		f.sizes = make(map[string]string)
+		stop := make(chan bool, 1)
+		err = retryEtcd(f.etcdRetryPolicy(), f.log, "WatchJobSizes", func() error {
+			kvs, err := f.coordinator.GetAndWatchJobSizes(f.name, stop, f.setJobSize)
+			for key, value := range kvs {
+				f.setJobSize(key, value)
+			}
+			return err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		f.sizeWatchStop = stop
+	}
+
+	f.sizesMu.Lock()
+	empty := len(f.sizes) == 0
+	_, ok := f.sizes[etcdutil.JobSizePath(f.name, f.epoch)]
+	f.sizesMu.Unlock()
+	// a job is resizable only if it records its size from the start.
+	if !empty && !ok {
+		var value string
+		err = retryEtcd(f.etcdRetryPolicy(), f.log, "GetJobSize", func() error {
+			size, err := f.coordinator.GetJobSize(f.name, f.epoch)
+			if etcdutil.IsKeyNotFound(err) {
+				return nil
+			}
+			if err == nil {
+				value = strconv.FormatUint(size, 10)
+			}
+			return err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		if value != "" {
+			f.setJobSize(etcdutil.JobSizePath(f.name, f.epoch), value)
+		}
+	}
+
+	f.sizesMu.Lock()
+	defer f.sizesMu.Unlock()
+	if size, err = etcdutil.JobSizeAt(f.sizes, f.name, f.epoch); err != nil {
+		return 0, 0, err
+	}
+	latest, err = etcdutil.JobSizeAt(f.sizes, f.name, exitEpoch)
+	return size, latest, err
+}
+
+// setJobSize records a size of the job, given as its key and value.
+func (f *framework) setJobSize(key, value string) {
+	f.sizesMu.Lock()
+	f.sizes[key] = value
+	f.sizesMu.Unlock()
+}
@@ -0,0 +1,129 @@
+package framework
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+)
+
+func TestMembership(t *testing.T) {
+	job := "TestMembership"
+	store := memcoord.NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	if err := ctl.ResizeJob(job, 0, 3); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	task := &resizeTask{}
+	topology := &resizableTopology{}
+	f := &framework{
+		name:     job,
+		taskID:   2,
+		task:     task,
+		topology: topology,
+		log:      log.New(os.Stdout, "", log.Lshortfile),
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	defer func() { f.sizeWatchStop <- true }()
+
+	// task 2 is set up by the resize, and joins at epoch 1.
+	if m := f.membership(); m != standby {
+		t.Errorf("membership at epoch 0 want = %d, get = %d", standby, m)
+	}
+	if f.numOfTasks != 2 || topology.numOfTasks != 2 {
+		t.Errorf("size at epoch 0 want = 2, get = %d, topology %d", f.numOfTasks, topology.numOfTasks)
+	}
+	f.epoch = 1
+	if m := f.membership(); m != member {
+		t.Errorf("membership at epoch 1 want = %d, get = %d", member, m)
+	}
+	if f.numOfTasks != 3 || topology.numOfTasks != 3 {
+		t.Errorf("size at epoch 1 want = 3, get = %d, topology %d", f.numOfTasks, topology.numOfTasks)
+	}
+
+	// the size of epoch 2 is read even if it isn't watched yet.
+	if err := ctl.CASEpoch(job, 0, 1); err != nil {
+		t.Fatalf("CASEpoch failed: %v", err)
+	}
+	if err := ctl.ResizeJob(job, 1, 2); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	f.epoch = 2
+	if m := f.membership(); m != retired {
+		t.Errorf("membership at epoch 2 want = %d, get = %d", retired, m)
+	}
+	if len(task.errs) != 0 {
+		t.Errorf("framework errors want none, get = %v", task.errs)
+	}
+}
+
+// A job that isn't resizable, or never records its size, has fixed membership.
+func TestMembershipNotResizable(t *testing.T) {
+	job := "TestMembershipNotResizable"
+	store := memcoord.NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	if err := ctl.ResizeJob(job, 0, 3); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	task := &resizeTask{}
+	f := &framework{
+		name:       job,
+		taskID:     0,
+		epoch:      1,
+		numOfTasks: 2,
+		task:       task,
+		topology:   &fixedTopology{},
+		log:        log.New(os.Stdout, "", log.Lshortfile),
+		config:     newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	defer func() { f.sizeWatchStop <- true }()
+	if m := f.membership(); m != member {
+		t.Errorf("membership want = %d, get = %d", member, m)
+	}
+	if len(task.errs) != 1 {
+		t.Fatalf("framework errors want 1, get = %v", task.errs)
+	}
+	if fe, ok := task.errs[0].(*taskgraph.FrameworkError); !ok || fe.Op != "resize" {
+		t.Errorf("framework error want of resize, get = %v", task.errs[0])
+	}
+
+	noSize := &framework{
+		name:   "TestMembershipNoSize",
+		taskID: 5,
+		task:   task,
+		log:    log.New(os.Stdout, "", log.Lshortfile),
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	defer func() { noSize.sizeWatchStop <- true }()
+	if m := noSize.membership(); m != member {
+		t.Errorf("membership of job without size want = %d, get = %d", member, m)
+	}
+}
+
+type resizeTask struct {
+	testableTask
+	errs []error
+}
+
+func (t *resizeTask) OnFrameworkError(err error) { t.errs = append(t.errs, err) }
+
+type fixedTopology struct{}
+
+func (t *fixedTopology) SetTaskID(taskID uint64)                             {}
+func (t *fixedTopology) GetLinkTypes() []string                              { return nil }
+func (t *fixedTopology) GetNeighbors(linkType string, epoch uint64) []uint64 { return nil }
+
+type resizableTopology struct {
+	fixedTopology
+	numOfTasks uint64
+}
+
+func (t *resizableTopology) Resize(numOfTasks uint64) { t.numOfTasks = numOfTasks }
@@ -0,0 +1,102 @@
+package framework
+
+import (
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+// By default, we retry after the previous task key expires and hopefully another
+// task gets up and running. It keeps retrying until epoch changes.
+func (c *config) defaultRetryPolicy() taskgraph.RetryPolicy {
+	return taskgraph.RetryPolicy{
+		InitialDelay: c.retryDelay,
+		MaxDelay:     15 * c.retryDelay,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// fillRetryPolicy sets each zero field of the policy to that of defaults, so that
+// a policy that only sets e.g. Deadline still backs off. Negative Jitter is kept,
+// as it turns jitter off, see retryDelay.
+func fillRetryPolicy(p, defaults taskgraph.RetryPolicy) taskgraph.RetryPolicy {
+	if p.InitialDelay == 0 {
+		p.InitialDelay = defaults.InitialDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = defaults.MaxDelay
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaults.Multiplier
+	}
+	if p.Jitter == 0 {
+		p.Jitter = defaults.Jitter
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.Deadline == 0 {
+		p.Deadline = defaults.Deadline
+	}
+	return p
+}
+
+// retryDelay returns how long to wait before the given attempt. attempt starts
+// from 1 for the first retry.
+func retryDelay(p taskgraph.RetryPolicy, attempt int) time.Duration {
+	d := float64(p.InitialDelay)
+	for i := 1; i < attempt && p.Multiplier > 1 && d < float64(p.MaxDelay); i++ {
+		d *= p.Multiplier
+	}
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// retryExhausted tells whether the request has used up its budget after the
+// given number of attempts since start.
+func retryExhausted(p taskgraph.RetryPolicy, attempts int, start time.Time) bool {
+	if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+		return true
+	}
+	if p.Deadline > 0 && time.Since(start) >= p.Deadline {
+		return true
+	}
+	return false
+}
+
+// Transient etcd failures are retried with bounded backoff.
+func (c *config) etcdRetryPolicy() taskgraph.RetryPolicy {
+	return taskgraph.RetryPolicy{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     c.heartbeatInterval,
+		Multiplier:   2,
+		Jitter:       0.2,
+		MaxAttempts:  5,
+	}
+}
+
+// retryEtcd runs fn until it succeeds or the retry budget is used up. A failed
+// compare-and-swap won't be retried. It returns *taskgraph.FrameworkError on failure.
+func retryEtcd(p taskgraph.RetryPolicy, logger *log.Logger, op string, fn func() error) error {
+	start := time.Now()
+	for attempts := 1; ; attempts++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if etcdutil.IsCompareFailed(err) || retryExhausted(p, attempts, start) {
+			return &taskgraph.FrameworkError{Op: op, Err: err}
+		}
+		logger.Printf("%s failed: %v, retry...", op, err)
+		time.Sleep(retryDelay(p, attempts))
+	}
+}
@@ -0,0 +1,154 @@
+package framework
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+)
+
+func TestRetryDelay(t *testing.T) {
+	p := taskgraph.RetryPolicy{
+		InitialDelay: time.Second,
+		MaxDelay:     5 * time.Second,
+		Multiplier:   2,
+	}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for i, tt := range tests {
+		if d := retryDelay(p, tt.attempt); d != tt.delay {
+			t.Errorf("#%d: delay want = %v, get = %v", i, tt.delay, d)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := retryDelay(p, 2); d < time.Second || d > 3*time.Second {
+			t.Fatalf("delay with jitter out of range: %v", d)
+		}
+	}
+}
+
+// A policy that sets only some fields still backs off with the defaults.
+func TestFillRetryPolicy(t *testing.T) {
+	c := defaultConfig()
+	defaults := c.defaultRetryPolicy()
+	p := fillRetryPolicy(taskgraph.RetryPolicy{Deadline: 10 * time.Second}, defaults)
+	want := defaults
+	want.Deadline = 10 * time.Second
+	if p != want {
+		t.Errorf("policy want = %+v, get = %+v", want, p)
+	}
+	if p := fillRetryPolicy(taskgraph.RetryPolicy{}, defaults); p != defaults {
+		t.Errorf("policy want = %+v, get = %+v", defaults, p)
+	}
+	// jitter can be turned off.
+	p = fillRetryPolicy(taskgraph.RetryPolicy{Jitter: -1}, defaults)
+	if p.Jitter >= 0 {
+		t.Errorf("jitter want negative, get = %v", p.Jitter)
+	}
+	for i := 0; i < 10; i++ {
+		if d := retryDelay(p, 1); d != defaults.InitialDelay {
+			t.Fatalf("delay without jitter want = %v, get = %v", defaults.InitialDelay, d)
+		}
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	tests := []struct {
+		policy    taskgraph.RetryPolicy
+		attempts  int
+		start     time.Time
+		exhausted bool
+	}{
+		{taskgraph.RetryPolicy{}, 100, time.Now().Add(-time.Hour), false},
+		{taskgraph.RetryPolicy{MaxAttempts: 3}, 2, time.Now(), false},
+		{taskgraph.RetryPolicy{MaxAttempts: 3}, 3, time.Now(), true},
+		{taskgraph.RetryPolicy{Deadline: time.Minute}, 1, time.Now(), false},
+		{taskgraph.RetryPolicy{Deadline: time.Minute}, 1, time.Now().Add(-time.Hour), true},
+	}
+	for i, tt := range tests {
+		if e := retryExhausted(tt.policy, tt.attempts, tt.start); e != tt.exhausted {
+			t.Errorf("#%d: exhausted want = %v, get = %v", i, tt.exhausted, e)
+		}
+	}
+}
+
+// Transient failures should be retried, while a failed compare gives up at once.
+func TestRetryEtcd(t *testing.T) {
+	logger := log.New(os.Stdout, "", log.Lshortfile)
+	c := defaultConfig()
+	p := c.etcdRetryPolicy()
+	attempts := 0
+	err := retryEtcd(p, logger, "transient", func() error {
+		attempts++
+		if attempts < 3 {
+			return fmt.Errorf("etcd unavailable")
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("retryEtcd: err = %v, attempts = %d, want nil and 3", err, attempts)
+	}
+
+	attempts = 0
+	err = retryEtcd(p, logger, "compare", func() error {
+		attempts++
+		return etcdutil.ErrCompareFailed
+	})
+	if _, ok := err.(*taskgraph.FrameworkError); !ok || attempts != 1 {
+		t.Errorf("retryEtcd: err = %v, attempts = %d, want *FrameworkError and 1", err, attempts)
+	}
+}
+
+// A retry of a move whose reply was lost fails its compare, but the job has moved,
+// while a move beaten by another epoch still fails.
+func TestRetryMoveEpoch(t *testing.T) {
+	store := memcoord.NewStore()
+	f := &framework{
+		name:   "TestRetryMoveEpoch",
+		log:    log.New(os.Stdout, "", log.Lshortfile),
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := f.coordinator.InitJob(f.name, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+
+	attempts := 0
+	err := f.retryMoveEpoch("lost", 0, func() error {
+		attempts++
+		if err := f.coordinator.CASEpoch(f.name, 0, 1); err != nil {
+			return err
+		}
+		if attempts == 1 {
+			return fmt.Errorf("reply lost")
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Errorf("retryMoveEpoch: err = %v, attempts = %d, want nil and 2", err, attempts)
+	}
+
+	if err := f.coordinator.SetEpoch(f.name, 3); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+	err = f.retryMoveEpoch("beaten", 1, func() error {
+		return f.coordinator.CASEpoch(f.name, 1, 2)
+	})
+	if _, ok := err.(*taskgraph.FrameworkError); !ok {
+		t.Errorf("retryMoveEpoch: err = %v, want *FrameworkError", err)
+	}
+}
@@ -0,0 +1,118 @@
+package framework
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/taskgraph/taskgraph/framework/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Metadata keys used by master/worker rpc. grpc lowercases header keys, so
+// these have to stay lowercased.
+const (
+	mdJob    = "job"
+	mdSender = "sender"
+
+	masterSender = "master"
+)
+
+// encode who is calling into the grpc context.
+func senderContext(ctx context.Context, job, sender string) context.Context {
+	md := metadata.MD{
+		mdJob:    job,
+		mdSender: sender,
+	}
+	return metadata.NewContext(ctx, md)
+}
+
+// parseSender returns the sender encoded by senderContext. It returns an error if the
+// request doesn't come from the same job.
+func parseSender(ctx context.Context, job string) (string, error) {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return "", fmt.Errorf("Can't get grpc.Metadata from context: %v", ctx)
+	}
+	if md[mdJob] != job {
+		return "", fmt.Errorf("request from job %q, want %q", md[mdJob], job)
+	}
+	sender, ok := md[mdSender]
+	if !ok {
+		return "", fmt.Errorf("no sender in grpc.Metadata: %v", md)
+	}
+	return sender, nil
+}
+
+func parseWorkerID(sender string) (uint64, error) {
+	return strconv.ParseUint(sender, 10, 64)
+}
+
+// invokeWithRetry resolves the address of peer by addr, e.g. from the coordinator,
+// and invokes the method on it. When a peer dies, another process will take over
+// and register a new address. So on failure we wait a while, resolve the address
+// again and retry until ctx is done.
+func (c *config) invokeWithRetry(ctx context.Context, peer string, addr func() (string, error), method string, input, reply proto.Message, logger *log.Logger) error {
+	for {
+		err := c.invoke(ctx, addr, method, input, reply)
+		if err == nil {
+			return nil
+		}
+		logger.Printf("invoke %s on %s failed: %v", method, peer, err)
+		select {
+		case <-time.After(c.retryDelay):
+			logger.Printf("retry %s on %s", method, peer)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *config) invoke(ctx context.Context, resolve func() (string, error), method string, input, reply proto.Message) error {
+	addr, err := resolve()
+	if err != nil {
+		return err
+	}
+	// The grpc.WithTimeout would help detect any disconnection in failfast.
+	// Otherwise grpc.Invoke will keep retrying.
+	cc, err := grpc.Dial(addr, grpc.WithTimeout(c.dialTimeout))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	return callFrame(ctx, cc, method, input, reply)
+}
+
+// callFrame sends the input through the generic Frame service and decodes
+// the output into reply.
+func callFrame(ctx context.Context, cc *grpc.ClientConn, method string, input, reply proto.Message) error {
+	in, err := proto.Marshal(input)
+	if err != nil {
+		return err
+	}
+	resp, err := pb.NewFrameClient(cc).Call(ctx, &pb.Request{Method: method, Input: in})
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(resp.Output, reply)
+}
+
+// handleNotification sends the notification to event loop and waits for the reply.
+func handleNotification(notifyChan chan *notification, stopChan chan struct{}, n *notification) (proto.Message, error) {
+	n.retChan = make(chan *notifyReply, 1)
+	select {
+	case notifyChan <- n:
+	case <-stopChan:
+		return nil, fmt.Errorf("framework stopped")
+	}
+	select {
+	case reply := <-n.retChan:
+		return reply.output, reply.err
+	case <-stopChan:
+		return nil, fmt.Errorf("framework stopped")
+	}
+}
@@ -0,0 +1,84 @@
+package framework
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"golang.org/x/net/context"
+)
+
+// A stale-synchronous job is one with staleness set, see the staleness config.
+// Each task moves to its next epoch on its own once it's done with the current
+// one, see EpochDone, as long as it's at most staleness epochs ahead of the job's
+// epoch, i.e. the slowest task. Otherwise it waits for the slowest to catch up.
+// Data requests between tasks in the window are served, whatever their epochs.
+// Metas are still only delivered for the epoch they are flagged at.
+
+// setupStaleness reads the staleness of the job. A task of a stale-synchronous job
+// sets its clock to where it starts, which might be behind the one reported before
+// it's taken over.
+func (f *framework) setupStaleness() error {
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, "GetConfig", func() error {
+		value, err := f.coordinator.GetConfig(f.name, etcdutil.Staleness)
+		if etcdutil.IsKeyNotFound(err) {
+			f.staleness = 0
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		f.staleness, err = strconv.ParseUint(value, 10, 64)
+		return err
+	})
+	if err != nil || f.staleness == 0 {
+		return err
+	}
+	f.log.Printf("stale-synchronous with staleness %d", f.staleness)
+	f.jobEpoch, f.clock = f.epoch, f.epoch
+	return retryEtcd(f.etcdRetryPolicy(), f.log, "ReportClock", func() error {
+		return f.coordinator.ReportClock(f.name, f.taskID, f.epoch)
+	})
+}
+
+// clockDone moves the clock of the task past the epoch of ctx. The event loop
+// moves the task on once the window allows.
+func (f *framework) clockDone(ctx context.Context, epoch uint64) error {
+	// The task has moved on already.
+	if ctx.Err() != nil {
+		return fmt.Errorf("epoch %d is over", epoch)
+	}
+	err := retryEtcd(f.etcdRetryPolicy(), f.log, "ReportClock", func() error {
+		return f.coordinator.ReportClock(f.name, f.taskID, epoch+1)
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case f.clockChan <- epoch:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+// nextEpoch returns the epoch the task should be at, which is current one if it
+// has to wait. A task behind the job's epoch, e.g. the epoch is set from outside,
+// jumps to it.
+func (f *framework) nextEpoch() uint64 {
+	next := f.clock
+	if next < f.jobEpoch {
+		next = f.jobEpoch
+	}
+	if next > f.jobEpoch+f.staleness {
+		return f.epoch
+	}
+	return next
+}
+
+// inWindow tells whether a task at the epoch can talk to this one.
+func (f *framework) inWindow(epoch uint64) bool {
+	if epoch > f.epoch {
+		return epoch-f.epoch <= f.staleness
+	}
+	return f.epoch-epoch <= f.staleness
+}
@@ -0,0 +1,116 @@
+package framework
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+	"golang.org/x/net/context"
+)
+
+func TestSetupStaleness(t *testing.T) {
+	job := "TestSetupStaleness"
+	store := memcoord.NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	f := &framework{
+		name:   job,
+		epoch:  3,
+		log:    log.New(os.Stdout, "", log.Lshortfile),
+		config: newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := f.setupStaleness(); err != nil || f.staleness != 0 {
+		t.Fatalf("setupStaleness without config want = (0, nil), get = (%d, %v)", f.staleness, err)
+	}
+
+	if err := ctl.SetConfig(job, etcdutil.Staleness, "2"); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	if err := f.setupStaleness(); err != nil || f.staleness != 2 {
+		t.Fatalf("setupStaleness want = (2, nil), get = (%d, %v)", f.staleness, err)
+	}
+	if f.clock != 3 || f.jobEpoch != 3 {
+		t.Errorf("clock and job epoch want = 3, get = %d, %d", f.clock, f.jobEpoch)
+	}
+	// the only task reports its clock, which moves the job on.
+	if epoch, err := ctl.GetEpoch(job); epoch != 3 || err != nil {
+		t.Errorf("GetEpoch want = (3, nil), get = (%d, %v)", epoch, err)
+	}
+}
+
+func TestClockDone(t *testing.T) {
+	job := "TestClockDone"
+	store := memcoord.NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	f := &framework{
+		name:      job,
+		log:       log.New(os.Stdout, "", log.Lshortfile),
+		clockChan: make(chan uint64, 1),
+		config:    newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := f.clockDone(context.Background(), 0); err != nil {
+		t.Fatalf("clockDone failed: %v", err)
+	}
+	if epoch := <-f.clockChan; epoch != 0 {
+		t.Errorf("clock done want = 0, get = %d", epoch)
+	}
+	if epoch, err := ctl.GetEpoch(job); epoch != 1 || err != nil {
+		t.Errorf("GetEpoch want = (1, nil), get = (%d, %v)", epoch, err)
+	}
+
+	// the task has moved on from epoch 1.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := f.clockDone(ctx, 1); err == nil {
+		t.Errorf("clockDone of an epoch that is over should fail")
+	}
+	if epoch, _ := ctl.GetEpoch(job); epoch != 1 {
+		t.Errorf("GetEpoch want = 1, get = %d", epoch)
+	}
+}
+
+func TestNextEpoch(t *testing.T) {
+	tests := []struct {
+		epoch, clock, jobEpoch, staleness uint64
+		want                              uint64
+	}{
+		{2, 3, 2, 1, 3},
+		// too far ahead of the slowest task.
+		{3, 4, 2, 1, 3},
+		{3, 4, 2, 2, 4},
+		// the job's epoch is set from outside.
+		{3, 4, 6, 1, 6},
+	}
+	for i, tt := range tests {
+		f := &framework{epoch: tt.epoch, clock: tt.clock, jobEpoch: tt.jobEpoch, staleness: tt.staleness}
+		if get := f.nextEpoch(); get != tt.want {
+			t.Errorf("#%d: nextEpoch want = %d, get = %d", i, tt.want, get)
+		}
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	f := &framework{epoch: 5, staleness: 2}
+	tests := []struct {
+		epoch uint64
+		want  bool
+	}{
+		{2, false},
+		{3, true},
+		{5, true},
+		{7, true},
+		{8, false},
+	}
+	for i, tt := range tests {
+		if get := f.inWindow(tt.epoch); get != tt.want {
+			t.Errorf("#%d: inWindow(%d) want = %v, get = %v", i, tt.epoch, tt.want, get)
+		}
+	}
+}
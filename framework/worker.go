@@ -4,22 +4,27 @@ import (
 	"log"
 	"net"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/taskgraph/taskgraph"
 )
 
 type worker struct {
-	id         uint64
-	job        string
-	etcdURL    []string
-	listener   net.Listener
-	logger     *log.Logger
-	task       taskgraph.WorkerTask
-	etcdClient *etcd.Client
-	stopChan   chan struct{}
+	id       uint64
+	job      string
+	etcdURL  []string
+	listener net.Listener
+	logger   *log.Logger
+	task     taskgraph.WorkerTask
+	stopChan chan struct{}
+	config
+	// A standby worker doesn't have an ID at start. It waits for any failed worker
+	// and takes over its ID.
+	standby bool
+
+	// event loop
+	notifyChan chan *notification
 }
 
-func NewWorkerBoot(job string, etcdURL []string, ln net.Listener, logger *log.Logger, task taskgraph.WorkerTask, id uint64) taskgraph.Bootup {
+func NewWorkerBoot(job string, etcdURL []string, ln net.Listener, logger *log.Logger, task taskgraph.WorkerTask, id uint64, opts ...Option) taskgraph.Bootup {
 	return &worker{
 		job:      job,
 		etcdURL:  etcdURL,
@@ -27,5 +32,20 @@ func NewWorkerBoot(job string, etcdURL []string, ln net.Listener, logger *log.Lo
 		logger:   logger,
 		task:     task,
 		id:       id,
+		config:   newConfig(opts),
+	}
+}
+
+// NewStandbyWorkerBoot creates a worker that waits for a failed worker and takes
+// over its worker ID.
+func NewStandbyWorkerBoot(job string, etcdURL []string, ln net.Listener, logger *log.Logger, task taskgraph.WorkerTask, opts ...Option) taskgraph.Bootup {
+	return &worker{
+		job:      job,
+		etcdURL:  etcdURL,
+		listener: ln,
+		logger:   logger,
+		task:     task,
+		standby:  true,
+		config:   newConfig(opts),
 	}
 }
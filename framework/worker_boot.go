@@ -1,40 +1,111 @@
 package framework
 
 import (
-	"github.com/coreos/go-etcd/etcd"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/pkg/etcdutil"
 	"golang.org/x/net/context"
 )
 
 // Worker has similar workflow to master. Although they have different impl.
 
-func (w *worker) Start() {
-	w.init()
-	w.setupEtcd()
+// Start runs the worker task until it finishes. It returns an error if the worker
+// fails to set up, e.g. its ID is held by a live worker.
+func (w *worker) Start() error {
+	if err := w.init(); err != nil {
+		return err
+	}
+	if err := w.setupEtcd(); err != nil {
+		close(w.stopChan)
+		w.coordinator.Close()
+		return err
+	}
 	w.startServer()
 	go w.startEventHandling()
 	w.runUserTask()
 	w.stop()
+	return nil
 }
 
-func (w *worker) init() {
-	w.etcdClient = etcd.NewClient(w.etcdURL)
+func (w *worker) init() error {
+	if w.logger == nil {
+		w.logger = log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
+	}
+	if w.coordinator == nil {
+		client, err := clientv3.New(clientv3.Config{
+			Endpoints:   w.etcdURL,
+			DialTimeout: w.dialTimeout,
+		})
+		if err != nil {
+			return &taskgraph.FrameworkError{Op: "connectEtcd", Err: err}
+		}
+		w.coordinator = etcdutil.NewCoordinator(client)
+	}
 	w.stopChan = make(chan struct{})
+	w.notifyChan = make(chan *notification, 1)
+	return nil
 }
 
-func (w *worker) setupEtcd() {
+func (w *worker) setupEtcd() error {
+	if err := w.coordinator.StartSession(w.heartbeatInterval, w.ttlMultiplier); err != nil {
+		return &taskgraph.FrameworkError{Op: "StartSession", Err: err}
+	}
+	// Keep the session alive while waiting for a free worker ID.
+	w.heartbeat()
+	if w.standby {
+		if err := w.occupyFreeWorker(); err != nil {
+			return &taskgraph.FrameworkError{Op: "occupyFreeWorker", Err: err}
+		}
+		w.logger.SetPrefix(fmt.Sprintf("worker %d: ", w.id))
+		return nil
+	}
 	// register worker's addr
-	w.etcdClient.Set(etcdutil.WorkerPath(w.job, w.id), w.listener.Addr().String(), 0)
+	ok, err := w.coordinator.TryOccupyWorker(w.job, w.id, w.listener.Addr().String())
+	if err != nil {
+		return &taskgraph.FrameworkError{Op: "TryOccupyWorker", Err: err}
+	}
+	if !ok {
+		return &taskgraph.FrameworkError{Op: "TryOccupyWorker", Err: fmt.Errorf("worker %d is still alive", w.id)}
+	}
+	return nil
+}
+
+// occupyFreeWorker will grab the ID of a failed worker and register itself on etcd.
+func (w *worker) occupyFreeWorker() error {
+	for {
+		id, err := w.coordinator.WaitFreeWorker(w.job, w.stopChan, w.logger)
+		if err != nil {
+			return err
+		}
+		w.logger.Printf("standby grabbed free worker %d", id)
+		ok, err := w.coordinator.TryOccupyWorker(w.job, id, w.listener.Addr().String())
+		if err != nil {
+			return err
+		}
+		if ok {
+			w.id = id
+			return nil
+		}
+		w.logger.Printf("standby tried worker %d failed. Wait free worker again.", id)
+	}
 }
 
 func (w *worker) startServer() {
-	s := w.task.CreateServer()
+	s := newFrameServer(w.task, w)
 	go s.Serve(w.listener)
 }
 
+// This for-select serializes notifications from master.
 func (w *worker) startEventHandling() {
 	for {
 		select {
+		case n := <-w.notifyChan:
+			output, err := w.task.OnNotify(n.ctx, n.method, n.input)
+			n.retChan <- &notifyReply{output: output, err: err}
 		case <-w.stopChan:
 			return
 		}
@@ -49,4 +120,5 @@ func (w *worker) runUserTask() {
 func (w *worker) stop() {
 	w.listener.Close()
 	close(w.stopChan)
+	w.coordinator.Close()
 }
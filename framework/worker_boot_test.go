@@ -1,30 +1,91 @@
 package framework
 
 import (
+	"log"
+	"os"
 	"testing"
 
-	"github.com/coreos/go-etcd/etcd"
-	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
 )
 
-// Worker should register its address in etcd so that others can find him.
+// Worker should register its address so that others can find him.
 func TestWorkerSetupEtcd(t *testing.T) {
 	job := "TestWorkerSetupEtcd"
 	id := uint64(1)
-	etcdClient := etcd.NewClient([]string{"http://localhost:4001"})
+	store := memcoord.NewStore()
 	w := &worker{
-		job:        job,
-		etcdClient: etcdClient,
-		listener:   createListener(t),
-		id:         id,
+		job:      job,
+		listener: createListener(t),
+		id:       id,
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
 	}
-	w.setupEtcd()
-	resp, err := etcdClient.Get(etcdutil.WorkerPath(job, id), false, false)
+	if err := w.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	value, err := store.NewCoordinator().GetWorkerAddress(job, id)
 	if err != nil {
-		t.Fatalf("etcdClient.Get failed: %v", err)
+		t.Fatalf("GetWorkerAddress failed: %v", err)
 	}
 	addr := w.listener.Addr().String()
-	if resp.Node.Value != addr {
-		t.Fatalf("Wrong address on etcd: want = %s, get = %s", addr, resp.Node.Value)
+	if value != addr {
+		t.Fatalf("Wrong address: want = %s, get = %s", addr, value)
+	}
+}
+
+// Standby worker should take over the ID of failed worker.
+func TestStandbyWorkerTakeOver(t *testing.T) {
+	job := "TestStandbyWorkerTakeOver"
+	store := memcoord.NewStore()
+
+	if err := store.NewCoordinator().ReportWorkerFailure(job, 3); err != nil {
+		t.Fatalf("ReportWorkerFailure failed: %v", err)
+	}
+	w := &worker{
+		job:      job,
+		listener: createListener(t),
+		logger:   log.New(os.Stdout, "", log.Lshortfile),
+		standby:  true,
+		config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+	}
+	if err := w.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	if w.id != 3 {
+		t.Fatalf("worker id want = 3, get = %d", w.id)
+	}
+	value, err := store.NewCoordinator().GetWorkerAddress(job, 3)
+	if err != nil {
+		t.Fatalf("GetWorkerAddress failed: %v", err)
+	}
+	if addr := w.listener.Addr().String(); value != addr {
+		t.Fatalf("Wrong address: want = %s, get = %s", addr, value)
+	}
+}
+
+// Start should fail, instead of panicking, if the worker ID is held by a live worker.
+func TestWorkerStartOccupied(t *testing.T) {
+	job := "TestWorkerStartOccupied"
+	store := memcoord.NewStore()
+
+	newWorker := func() *worker {
+		return &worker{
+			id:       1,
+			job:      job,
+			listener: createListener(t),
+			logger:   log.New(os.Stdout, "", log.Lshortfile),
+			task:     &testableWorkerTask{},
+			config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+		}
+	}
+	w := newWorker()
+	if err := w.init(); err != nil {
+		t.Fatalf("init failed: %v", err)
+	}
+	if err := w.setupEtcd(); err != nil {
+		t.Fatalf("setupEtcd failed: %v", err)
+	}
+	defer w.stop()
+	if err := newWorker().Start(); err == nil {
+		t.Errorf("Start of worker 1 should fail, as it's alive")
 	}
 }
@@ -1,18 +1,57 @@
 package framework
 
 import (
+	"fmt"
+	"strconv"
+
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 )
 
+// NotifyMaster calls the master's OnNotify and waits for its reply.
+// It keeps retrying on the current master address until ctx is done.
 func (w *worker) NotifyMaster(ctx context.Context, method string, input proto.Message) (proto.Message, error) {
-	panic("")
+	return w.call(ctx, "master", func() (string, error) {
+		return w.coordinator.GetMasterAddress(w.job)
+	}, method, input)
 }
 
+// DataRequest calls ServeData on the given worker and waits for its reply.
+// If the worker fails in between, the request is sent again to the one that
+// takes over the ID until ctx is done.
 func (w *worker) DataRequest(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error) {
-	panic("")
+	return w.call(ctx, fmt.Sprintf("worker %d", workerID), func() (string, error) {
+		return w.coordinator.GetWorkerAddress(w.job, workerID)
+	}, method, input)
+}
+
+func (w *worker) call(ctx context.Context, peer string, addr func() (string, error), method string, input proto.Message) (proto.Message, error) {
+	reply := w.task.CreateOutputMessage(method)
+	ctx = senderContext(ctx, w.job, strconv.FormatUint(w.id, 10))
+	err := w.invokeWithRetry(ctx, peer, addr, method, input, reply, w.logger)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
 }
 
+// Intercept should be called by the grpc handlers of worker task. Requests from master
+// go to the task's OnNotify, and requests from other workers go to ServeData.
 func (w *worker) Intercept(ctx context.Context, method string, input proto.Message) (proto.Message, error) {
-	panic("")
+	sender, err := parseSender(ctx, w.job)
+	if err != nil {
+		return nil, err
+	}
+	if sender == masterSender {
+		return handleNotification(w.notifyChan, w.stopChan, &notification{
+			ctx:    ctx,
+			method: method,
+			input:  input,
+		})
+	}
+	workerID, err := parseWorkerID(sender)
+	if err != nil {
+		return nil, err
+	}
+	return w.task.ServeData(ctx, workerID, method, input)
 }
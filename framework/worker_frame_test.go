@@ -0,0 +1,118 @@
+package framework
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/taskgraph/taskgraph"
+	pb "github.com/taskgraph/taskgraph/example/regression/proto"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+	"golang.org/x/net/context"
+)
+
+// A worker should be able to request data from its peer, which gets served by
+// the peer's ServeData with the requester's ID.
+func TestWorkerDataRequest(t *testing.T) {
+	job := "TestWorkerDataRequest"
+	store := memcoord.NewStore()
+
+	workers := make([]*worker, 2)
+	for i := range workers {
+		workers[i] = &worker{
+			id:       uint64(i),
+			job:      job,
+			listener: createListener(t),
+			logger:   log.New(os.Stdout, "", log.Lshortfile),
+			task:     &testableWorkerTask{},
+			config:   newConfig([]Option{WithCoordinator(store.NewCoordinator())}),
+		}
+		if err := workers[i].init(); err != nil {
+			t.Fatalf("init failed: %v", err)
+		}
+		workers[i].task.Setup(workers[i], uint64(i))
+		if err := workers[i].setupEtcd(); err != nil {
+			t.Fatalf("setupEtcd failed: %v", err)
+		}
+		workers[i].startServer()
+		go workers[i].startEventHandling()
+		defer workers[i].stop()
+	}
+
+	reply, err := workers[0].DataRequest(context.Background(), 1, "/proto.Regression/GetGradient", &pb.Input{})
+	if err != nil {
+		t.Fatalf("DataRequest failed: %v", err)
+	}
+	if g := reply.(*pb.Gradient); g.Value != 0 {
+		t.Errorf("gradient value want = 0, get = %d", g.Value)
+	}
+}
+
+// DataRequest to a worker that never shows up should give up once ctx is done.
+func TestWorkerDataRequestCancel(t *testing.T) {
+	job := "TestWorkerDataRequestCancel"
+	w := &worker{
+		id:     0,
+		job:    job,
+		logger: log.New(os.Stdout, "", log.Lshortfile),
+		task:   &testableWorkerTask{},
+		config: newConfig([]Option{WithCoordinator(memcoord.NewStore().NewCoordinator())}),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 3*defaultHeartbeatInterval)
+	defer cancel()
+	_, err := w.DataRequest(ctx, 1, "/proto.Regression/GetGradient", &pb.Input{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("DataRequest error want = %v, get = %v", context.DeadlineExceeded, err)
+	}
+}
+
+// Requests from another job shouldn't reach the task.
+func TestWorkerInterceptOtherJob(t *testing.T) {
+	w := &worker{
+		job:    "TestWorkerInterceptOtherJob",
+		task:   &testableWorkerTask{},
+		config: defaultConfig(),
+	}
+	ctx := senderContext(context.Background(), "AnotherJob", "1")
+	if _, err := w.Intercept(ctx, "/proto.Regression/GetGradient", &pb.Input{}); err == nil {
+		t.Errorf("Intercept should fail on request from another job")
+	}
+}
+
+type testableWorkerTask struct {
+	frame taskgraph.WorkerFrame
+}
+
+func (t *testableWorkerTask) Setup(frame taskgraph.WorkerFrame, workerID uint64) { t.frame = frame }
+func (t *testableWorkerTask) Run(ctx context.Context)                            {}
+
+func (t *testableWorkerTask) OnNotify(ctx context.Context, method string, input proto.Message) (proto.Message, error) {
+	return &pb.Parameter{Value: 1}, nil
+}
+
+func (t *testableWorkerTask) ServeData(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error) {
+	return &pb.Gradient{Value: int32(workerID)}, nil
+}
+
+func (t *testableWorkerTask) CreateInputMessage(method string) proto.Message {
+	switch method {
+	case "/proto.Regression/GetParameter", "/proto.Regression/GetGradient":
+		return new(pb.Input)
+	default:
+		log.Panicf("Unknown method: %s", method)
+		return nil
+	}
+}
+
+func (t *testableWorkerTask) CreateOutputMessage(method string) proto.Message {
+	switch method {
+	case "/proto.Regression/GetParameter":
+		return new(pb.Parameter)
+	case "/proto.Regression/GetGradient":
+		return new(pb.Gradient)
+	default:
+		log.Panicf("Unknown method: %s", method)
+		return nil
+	}
+}
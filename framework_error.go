@@ -0,0 +1,15 @@
+package taskgraph
+
+import "fmt"
+
+// FrameworkError is returned or reported to task via OnFrameworkError when
+// framework fails at some operation even after retries. Op tells which
+// operation fails and Err is the last underlying error.
+type FrameworkError struct {
+	Op  string
+	Err error
+}
+
+func (e *FrameworkError) Error() string {
+	return fmt.Sprintf("taskgraph: %s failed: %v", e.Op, e.Err)
+}
@@ -16,9 +16,13 @@ type Bootstrap interface {
 	// This allow the application to specify how tasks are connection at each epoch
 	SetTopology(topology Topology)
 
+	// This allow the application to specify how failed data requests are retried.
+	SetRetryPolicy(policy RetryPolicy)
+
 	// After all the configure is done, driver need to call start so that all
 	// nodes will get into the event loop to run the application.
-	Start()
+	// It returns error if framework fails to set up.
+	Start() error
 }
 
 // Framework hides distributed system complexity and provides users convenience of
@@ -32,9 +36,12 @@ type Framework interface {
 	Kill()
 
 	// Some task can inform all participating tasks to shutdown.
-	// If successful, all tasks will be gracefully shutdown.
-	// TODO: @param status
-	ShutdownJob()
+	// If successful, all tasks will be gracefully shutdown, and the job succeeds.
+	ShutdownJob() error
+
+	// FailJob is like ShutdownJob, but the job fails with the reason, e.g. it
+	// doesn't converge.
+	FailJob(reason string) error
 
 	GetLogger() *log.Logger
 
@@ -44,10 +51,21 @@ type Framework interface {
 	// This is useful for task to inform the framework their status change.
 	// metaData has to be really small, since it might be stored in etcd.
 	// Set meta flag to notify meta to all nodes of linkType to this node.
-	FlagMeta(ctx context.Context, linkType, meta string)
-
-	// Some task can inform all participating tasks to new epoch
-	IncEpoch(ctx context.Context)
+	// Transient etcd failures are retried. *FrameworkError is returned if it still fails.
+	FlagMeta(ctx context.Context, linkType, meta string) error
+
+	// Some task can inform all participating tasks to new epoch.
+	// It fails without retry if epoch has been changed by others.
+	IncEpoch(ctx context.Context) error
+
+	// EpochDone tells that the task is done with the epoch of ctx. Once all tasks
+	// at the epoch have done so, the job moves to the next epoch, so that no task
+	// needs to count others. A job can also move on once a quorum of them have, see
+	// controller.SetEpochQuorum. Calling it again for the same epoch, e.g. after
+	// the task is taken over, counts once. It fails without retry if the epoch has
+	// moved on. In a stale-synchronous job, see controller.SetStaleness, the task
+	// moves on by itself instead, unless it's too far ahead of the slowest task.
+	EpochDone(ctx context.Context) error
 
 	// Request data from task toID with specified linkType and meta.
 	DataRequest(ctx context.Context, toID uint64, method string, input proto.Message)
@@ -55,20 +73,25 @@ type Framework interface {
 }
 
 // Note that framework can decide how update can be done, and how to serve the updatelog.
+// The framework given to a Backupable task implements it.
 type BackedUpFramework interface {
 	// Ask framework to do update on this update on this task, which consists
-	// of one primary and some backup copies.
+	// of one primary and some backup copies. Only the primary can do it, and the
+	// primary is supposed to have applied the update itself. Backups get it
+	// asynchronously, so the last few updates might be lost if the primary fails.
 	Update(taskID uint64, log UpdateLog)
 }
 
 type Bootup interface {
-	// Blocking call to run the task until it finishes.
-	Start()
+	// Blocking call to run the task until it finishes. It returns error if the
+	// task fails to set up.
+	Start() error
 }
 
 type GRPCHandlerInterceptor interface {
-	// Currently grpc doesn't support interceptor functionality. We need to rely on user
-	// to call this at handler implementation.
+	// Currently grpc doesn't support interceptor functionality. Framework serves a
+	// generic service and calls this for every request. It checks where the request
+	// comes from and then dispatches it to task's handler.
 	// The workflow would be
 	//   C:Notify -> S:Intercept -> S:OnNotify
 	Intercept(ctx context.Context, method string, input proto.Message) (proto.Message, error)
@@ -95,6 +118,9 @@ type MasterFrame interface {
 	// track of workers' states, user can make decisions on logical worker and communicate it
 	// using proto messages.
 	NotifyWorker(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error)
+	// Block until all workers have registered or ctx is done. Usually master task
+	// calls this at the beginning of Run.
+	WorkersReady(ctx context.Context) error
 	GRPCHandlerInterceptor
 }
 
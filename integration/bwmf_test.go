@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"testing"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/golang/protobuf/proto"
 	"github.com/taskgraph/taskgraph/controller"
 	"github.com/taskgraph/taskgraph/example/bwmf"
@@ -14,14 +13,14 @@ import (
 )
 
 func TestBWMF(t *testing.T) {
-	etcdURLs := []string{"http://localhost:4001"}
+	etcdURLs := []string{"http://localhost:2379"}
 
 	job := "bwmf_basic_test"
 	numOfTasks := uint64(2)
 
 	generateTestData(t)
 
-	ctl := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks, []string{"Neighbors", "Master"})
+	ctl := controller.New(job, newEtcdClient(t, etcdURLs), numOfTasks, []string{"Rows", "Columns"})
 	ctl.Start()
 
 	tb := &bwmf.BWMFTaskBuilder{
@@ -46,10 +45,12 @@ func TestBWMF(t *testing.T) {
 				}`),
 	}
 	for i := uint64(0); i < numOfTasks; i++ {
-		go drive(t, job, etcdURLs, tb, topo.NewFullTopology(numOfTasks))
+		go drive(t, job, etcdURLs, tb, topo.NewRowColumnTopology(numOfTasks))
 	}
 
-	ctl.WaitForJobDone()
+	if _, err := ctl.WaitForJobDone(); err != nil {
+		t.Errorf("WaitForJobDone failed: %v", err)
+	}
 	ctl.Stop()
 }
 
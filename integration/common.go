@@ -4,6 +4,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/coreos/etcd/clientv3"
 	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/example/topo"
 	"github.com/taskgraph/taskgraph/framework"
@@ -17,6 +18,14 @@ func createListener(t *testing.T) net.Listener {
 	return l
 }
 
+func newEtcdClient(t *testing.T, etcdURLs []string) *clientv3.Client {
+	c, err := clientv3.New(clientv3.Config{Endpoints: etcdURLs})
+	if err != nil {
+		t.Fatalf("clientv3.New failed: %v", err)
+	}
+	return c
+}
+
 // This is used to show how to drive the network.
 func driveWithTreeTopo(t *testing.T, jobName string, etcds []string, ntask uint64, taskBuilder taskgraph.TaskBuilder) {
 	drive(t, jobName, etcds, taskBuilder, topo.NewTreeTopology(2, ntask))
@@ -27,5 +36,7 @@ func drive(t *testing.T, jobName string, etcds []string, taskBuilder taskgraph.T
 	bootstrap := framework.NewBootStrap(jobName, etcds, createListener(t), nil)
 	bootstrap.SetTaskBuilder(taskBuilder)
 	bootstrap.SetTopology(topo)
-	bootstrap.Start()
+	if err := bootstrap.Start(); err != nil {
+		t.Errorf("bootstrap.Start failed: %v", err)
+	}
 }
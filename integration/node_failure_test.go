@@ -1,12 +1,14 @@
 package integration
 
 import (
-	"log"
 	"testing"
+	"time"
 
-	"github.com/coreos/go-etcd/etcd"
-	"github.com/taskgraph/taskgraph/controller"
+	"github.com/taskgraph/taskgraph"
 	"github.com/taskgraph/taskgraph/example/regression"
+	"github.com/taskgraph/taskgraph/example/topo"
+	"github.com/taskgraph/taskgraph/framework"
+	tgtesting "github.com/taskgraph/taskgraph/testing"
 )
 
 // TestMasterSetEpochFailure checks if a master task failed at SetEpoch,
@@ -14,95 +16,48 @@ import (
 // 2. continue what's left;
 // 3. finish the job with the same result.
 func TestMasterSetEpochFailure(t *testing.T) {
-	job := "TestMasterSetEpochFailure"
-	etcdURLs := []string{"http://localhost:4001"}
-	numOfTasks := uint64(15)
-	numOfIterations := uint64(10)
-
-	// controller start first to setup task directories in etcd
-	controller := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks, []string{"Parents", "Children"})
-	controller.Start()
-
-	taskBuilder := &regression.SimpleTaskBuilder{
-		GDataChan:    make(chan int32, 11),
-		NodeProducer: make(chan bool, 1),
-		MasterConfig: map[string]string{
-			"SetEpoch":  "fail",
-			"failepoch": "1",
-			"faillevel": "100",
-		},
-		NumberOfIterations: numOfIterations,
-	}
-	for i := uint64(0); i < numOfTasks; i++ {
-		go driveWithTreeTopo(t, job, etcdURLs, numOfTasks, taskBuilder)
-	}
-	if <-taskBuilder.NodeProducer {
-		taskBuilder.MasterConfig = nil
-		log.Println("Starting a new node")
-		// this time we start a new bootstrap whose task master doesn't fail.
-		go driveWithTreeTopo(t, job, etcdURLs, numOfTasks, taskBuilder)
-	}
-
-	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
-	getData := make([]int32, numOfIterations+1)
-	for i := uint64(0); i <= numOfIterations; i++ {
-		getData[i] = <-taskBuilder.GDataChan
-	}
-
-	for i := range wantData {
-		if wantData[i] != getData[i] {
-			t.Errorf("#%d: data want = %d, get = %d", i, wantData[i], getData[i])
-		}
-	}
-	controller.WaitForJobDone()
-	controller.Stop()
+	testRegressionWithFaults(t, framework.KillAtEpoch(0, 1))
 }
 
-func TestSlaveParentDataReadyFailure(t *testing.T) {
-	job := "TestSlavePDataReadyFailure"
-	slaveConfig := map[string]string{
-		"ParentDataReady": "fail",
-		"faillevel":       "3",
-	}
-	testSlaveFailure(t, job, slaveConfig)
+// TestSlaveFailure kills slaves in the middle of the tree, whose children and
+// parent have to wait for the new ones.
+func TestSlaveFailure(t *testing.T) {
+	testRegressionWithFaults(t,
+		framework.KillAtEpoch(3, 2),
+		framework.KillAtEpoch(5, 4).WithProbability(0.5),
+	)
 }
 
-// This test tests fault tolerance in slave ChildDataReady() if node fails before/after
-// sending data to parent node
-func TestSlaveChildDataReadyFailure(t *testing.T) {
-	job := "TestSlaveChildDataReadyFailure"
-	slaveConfig := map[string]string{
-		"ChildDataReady": "fail",
-		"faillevel":      "3",
-	}
-	testSlaveFailure(t, job, slaveConfig)
+// TestDataRequestFailure checks that failed data requests are retried and won't
+// change the result.
+func TestDataRequestFailure(t *testing.T) {
+	testRegressionWithFaults(t,
+		framework.DropRequest(0, "/proto.Regression/GetGradient", 3),
+		framework.DropRequest(4, "/proto.Regression/GetParameter", 2),
+		framework.Partition([]uint64{0, 1}, []uint64{3, 4}, 500*time.Millisecond),
+		framework.DelayMeta(2, 100*time.Millisecond),
+	)
 }
 
-func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
-	etcdURLs := []string{"http://localhost:4001"}
+func testRegressionWithFaults(t *testing.T, faults ...framework.Fault) {
 	numOfTasks := uint64(15)
 	numOfIterations := uint64(10)
 
-	// controller start first to setup task directories in etcd
-	controller := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks, []string{"Parents", "Children"})
-	controller.Start()
-	defer controller.Stop()
-
 	taskBuilder := &regression.SimpleTaskBuilder{
 		GDataChan:          make(chan int32, 11),
-		NodeProducer:       make(chan bool, 1),
-		SlaveConfig:        slaveConfig,
 		NumberOfIterations: numOfIterations,
 	}
-	go func() {
-		for _ = range taskBuilder.NodeProducer {
-			log.Println("Starting a new node")
-			go driveWithTreeTopo(t, job, etcdURLs, numOfTasks, taskBuilder)
-		}
-	}()
-	for i := uint64(0); i < numOfTasks; i++ {
-		taskBuilder.NodeProducer <- true
+	c := &tgtesting.LocalCluster{
+		NumTasks:      numOfTasks,
+		Topology:      func() taskgraph.Topology { return topo.NewTreeTopology(2, numOfTasks) },
+		TaskBuilder:   taskBuilder,
+		Options:       []framework.Option{framework.WithFaults(1, faults...)},
+		RestartKilled: true,
+	}
+	if err := c.Start(); err != nil {
+		t.Fatalf("LocalCluster.Start failed: %v", err)
 	}
+	defer c.Stop()
 
 	wantData := []int32{0, 105, 210, 315, 420, 525, 630, 735, 840, 945, 1050}
 	getData := make([]int32, numOfIterations+1)
@@ -115,7 +70,7 @@ func testSlaveFailure(t *testing.T, job string, slaveConfig map[string]string) {
 			t.Errorf("#%d: data want = %d, get = %d", i, wantData[i], getData[i])
 		}
 	}
-	controller.WaitForJobDone()
-	controller.Stop()
-	close(taskBuilder.NodeProducer)
+	if _, err := c.WaitDone(); err != nil {
+		t.Errorf("WaitDone failed: %v", err)
+	}
 }
@@ -3,20 +3,19 @@ package integration
 import (
 	"testing"
 
-	"github.com/coreos/go-etcd/etcd"
 	"github.com/taskgraph/taskgraph/controller"
 	"github.com/taskgraph/taskgraph/example/regression"
 )
 
 func TestRegressionFramework(t *testing.T) {
-	etcdURLs := []string{"http://localhost:4001"}
+	etcdURLs := []string{"http://localhost:2379"}
 
 	job := "framework_regression_test"
 	numOfTasks := uint64(15)
 	numOfIterations := uint64(10)
 
 	// controller start first to setup task directories in etcd
-	controller := controller.New(job, etcd.NewClient(etcdURLs), numOfTasks, []string{"Parents", "Children"})
+	controller := controller.New(job, newEtcdClient(t, etcdURLs), numOfTasks, []string{"Parents", "Children"})
 	controller.Start()
 
 	// We need to set etcd so that nodes know what to do.
@@ -39,6 +38,8 @@ func TestRegressionFramework(t *testing.T) {
 		}
 	}
 
-	controller.WaitForJobDone()
+	if _, err := controller.WaitForJobDone(); err != nil {
+		t.Errorf("WaitForJobDone failed: %v", err)
+	}
 	controller.Stop()
 }
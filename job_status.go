@@ -0,0 +1,93 @@
+package taskgraph
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ExitEpoch is the epoch a job moves to once it's done. All tasks exit on it.
+const ExitEpoch = math.MaxUint64
+
+// JobState is where a job is in its life cycle.
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobSucceeded
+	JobFailed
+	JobCancelled
+)
+
+var jobStateNames = []string{"running", "succeeded", "failed", "cancelled"}
+
+func (s JobState) String() string {
+	if s < 0 || int(s) >= len(jobStateNames) {
+		return fmt.Sprintf("JobState(%d)", int(s))
+	}
+	return jobStateNames[s]
+}
+
+// MarshalText makes a state readable in JSON.
+func (s JobState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *JobState) UnmarshalText(text []byte) error {
+	for i, name := range jobStateNames {
+		if string(text) == name {
+			*s = JobState(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("taskgraph: unknown job state %q", text)
+}
+
+// JobStatus is the state of a job, with the reason if it's failed or cancelled.
+type JobStatus struct {
+	State  JobState
+	Reason string
+}
+
+// Done tells whether the job has reached a terminal state.
+func (s JobStatus) Done() bool { return s.State != JobRunning }
+
+// Err returns nil if the job has succeeded or is still running, and an error with
+// the reason otherwise.
+func (s JobStatus) Err() error {
+	switch s.State {
+	case JobFailed, JobCancelled:
+		return fmt.Errorf("taskgraph: job %s", s)
+	}
+	return nil
+}
+
+// String is also how a status is stored, e.g. "failed: out of memory".
+func (s JobStatus) String() string {
+	if s.Reason == "" {
+		return s.State.String()
+	}
+	return s.State.String() + ": " + s.Reason
+}
+
+// ParseJobStatus parses the string of a status. An empty one means running.
+func ParseJobStatus(str string) (JobStatus, error) {
+	if str == "" {
+		return JobStatus{State: JobRunning}, nil
+	}
+	// status written by earlier versions.
+	if str == "done" {
+		return JobStatus{State: JobSucceeded}, nil
+	}
+	parts := strings.SplitN(str, ": ", 2)
+	for i, name := range jobStateNames {
+		if parts[0] == name {
+			s := JobStatus{State: JobState(i)}
+			if len(parts) == 2 {
+				s.Reason = parts[1]
+			}
+			return s, nil
+		}
+	}
+	return JobStatus{}, fmt.Errorf("taskgraph: unknown job status %q", str)
+}
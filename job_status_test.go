@@ -0,0 +1,49 @@
+package taskgraph
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJobStatusString(t *testing.T) {
+	tests := []JobStatus{
+		{State: JobRunning},
+		{State: JobSucceeded},
+		{State: JobFailed, Reason: "loss is NaN: epoch 3"},
+		{State: JobCancelled, Reason: "by user"},
+	}
+	for i, tt := range tests {
+		s, err := ParseJobStatus(tt.String())
+		if err != nil {
+			t.Errorf("#%d: ParseJobStatus(%q) failed: %v", i, tt.String(), err)
+			continue
+		}
+		if s != tt {
+			t.Errorf("#%d: status want = %+v, get = %+v", i, tt, s)
+		}
+		if (s.Err() != nil) != (s.State == JobFailed || s.State == JobCancelled) {
+			t.Errorf("#%d: unexpected Err() = %v", i, s.Err())
+		}
+	}
+	if _, err := ParseJobStatus("exploded"); err == nil {
+		t.Errorf("ParseJobStatus of unknown status should fail")
+	}
+}
+
+func TestJobStatusJSON(t *testing.T) {
+	status := JobStatus{State: JobCancelled, Reason: "by user"}
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if want := `{"State":"cancelled","Reason":"by user"}`; string(b) != want {
+		t.Errorf("JSON want = %s, get = %s", want, b)
+	}
+	var s JobStatus
+	if err := json.Unmarshal(b, &s); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if s != status {
+		t.Errorf("status want = %+v, get = %+v", status, s)
+	}
+}
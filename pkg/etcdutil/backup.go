@@ -0,0 +1,28 @@
+package etcdutil
+
+import (
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// TryOccupyBackup registers the process as the replica of the task, with its
+// address attached to the lease, if the replica isn't taken. The number of
+// replicas is up to the job, see the backups config.
+func TryOccupyBackup(client *clientv3.Client, job string, taskID, replicaID uint64, lease clientv3.LeaseID, connection string) (bool, error) {
+	key := TaskReplicaPath(job, taskID, replicaID)
+	resp, err := client.Txn(context.Background()).
+		If(notExist(key)).
+		Then(clientv3.OpPut(key, connection, clientv3.WithLease(lease))).
+		Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+// ReleaseBackup frees the replica of the task, e.g. once the backup has taken
+// over the task.
+func ReleaseBackup(client *clientv3.Client, job string, taskID, replicaID uint64) error {
+	_, err := client.Delete(context.Background(), TaskReplicaPath(job, taskID, replicaID))
+	return err
+}
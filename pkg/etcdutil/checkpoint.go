@@ -0,0 +1,178 @@
+package etcdutil
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
+)
+
+// A global checkpoint is taken at the start of an epoch. Each task reports its
+// snapshot once saved, and the checkpoint is committed once every task at the
+// epoch has reported. Reports of a checkpoint not committed are useless on their
+// own, as tasks can't go back to different epochs.
+
+// ReportCheckpoint records the snapshot that the task has saved at the epoch.
+func ReportCheckpoint(client *clientv3.Client, job string, epoch uint64, checkpoint taskgraph.TaskCheckpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	_, err = client.Put(context.Background(), CheckpointTaskPath(job, epoch, checkpoint.TaskID), string(b))
+	return err
+}
+
+// GetCheckpoint returns the snapshots reported at the epoch so far.
+func GetCheckpoint(client *clientv3.Client, job string, epoch uint64) (taskgraph.CheckpointManifest, error) {
+	kvs, err := getCheckpointReports(client, job)
+	if err != nil {
+		return taskgraph.CheckpointManifest{}, err
+	}
+	return ParseCheckpoint(kvs, job, epoch)
+}
+
+// CommitCheckpoint marks the checkpoint of the epoch committed, only if it's
+// later than the one committed. Otherwise ErrCompareFailed is returned. Reports
+// of earlier epochs are dropped.
+func CommitCheckpoint(client *clientv3.Client, job string, epoch uint64) error {
+	key := CheckpointPath(job)
+	for {
+		resp, err := client.Get(context.Background(), key)
+		if err != nil {
+			return err
+		}
+		cond := notExist(key)
+		if len(resp.Kvs) > 0 {
+			committed, err := strconv.ParseUint(string(resp.Kvs[0].Value), 10, 64)
+			if err != nil {
+				return err
+			}
+			if committed >= epoch {
+				return ErrCompareFailed
+			}
+			cond = clientv3.Compare(clientv3.ModRevision(key), "=", resp.Kvs[0].ModRevision)
+		}
+		kvs, err := getCheckpointReports(client, job)
+		if err != nil {
+			return err
+		}
+		ops := []clientv3.Op{clientv3.OpPut(key, strconv.FormatUint(epoch, 10))}
+		for k, e := range CheckpointReportEpochs(kvs, job) {
+			if e < epoch {
+				ops = append(ops, clientv3.OpDelete(k))
+			}
+		}
+		txn, err := client.Txn(context.Background()).If(cond).Then(ops...).Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		// Someone else has committed meanwhile. Check again.
+	}
+}
+
+// LatestCheckpoint returns the latest committed checkpoint. ErrKeyNotFound is
+// returned if there is none.
+func LatestCheckpoint(client *clientv3.Client, job string) (taskgraph.CheckpointManifest, error) {
+	value, _, err := GetValue(client, CheckpointPath(job))
+	if err != nil {
+		return taskgraph.CheckpointManifest{}, err
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return taskgraph.CheckpointManifest{}, err
+	}
+	return GetCheckpoint(client, job, epoch)
+}
+
+// dropUncommittedCheckpoints removes reports of checkpoints not committed, e.g.
+// those left by tasks of a job that has stopped.
+func dropUncommittedCheckpoints(client *clientv3.Client, job string) error {
+	committed, _, err := GetValue(client, CheckpointPath(job))
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	kvs, err := getCheckpointReports(client, job)
+	if err != nil {
+		return err
+	}
+	for _, key := range UncommittedCheckpoints(kvs, job, committed) {
+		if _, err := client.Delete(context.Background(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseCheckpoint finds the snapshots reported at the epoch from the reports,
+// which are given as a map from keys to values.
+func ParseCheckpoint(kvs map[string]string, job string, epoch uint64) (taskgraph.CheckpointManifest, error) {
+	manifest := taskgraph.CheckpointManifest{Epoch: epoch}
+	prefix := dirPrefix(CheckpointEpochDir(job, epoch))
+	for key, value := range kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var checkpoint taskgraph.TaskCheckpoint
+		if err := json.Unmarshal([]byte(value), &checkpoint); err != nil {
+			return manifest, err
+		}
+		manifest.Tasks = append(manifest.Tasks, checkpoint)
+	}
+	sort.Sort(byCheckpointTaskID(manifest.Tasks))
+	return manifest, nil
+}
+
+// CheckpointReportEpochs returns the epoch of each report, by key.
+func CheckpointReportEpochs(kvs map[string]string, job string) map[string]uint64 {
+	prefix := dirPrefix(CheckpointDir(job))
+	epochs := make(map[string]uint64)
+	for key := range kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+		epoch, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		epochs[key] = epoch
+	}
+	return epochs
+}
+
+// UncommittedCheckpoints returns keys of the reports that aren't of the committed
+// epoch, which is empty if nothing is committed.
+func UncommittedCheckpoints(kvs map[string]string, job, committed string) []string {
+	var keys []string
+	for key, epoch := range CheckpointReportEpochs(kvs, job) {
+		if strconv.FormatUint(epoch, 10) != committed {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func getCheckpointReports(client *clientv3.Client, job string) (map[string]string, error) {
+	resp, err := client.Get(context.Background(), dirPrefix(CheckpointDir(job)), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs, nil
+}
+
+type byCheckpointTaskID []taskgraph.TaskCheckpoint
+
+func (s byCheckpointTaskID) Len() int           { return len(s) }
+func (s byCheckpointTaskID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byCheckpointTaskID) Less(i, j int) bool { return s[i].TaskID < s[j].TaskID }
@@ -0,0 +1,95 @@
+package etcdutil
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+)
+
+func TestCheckpoint(t *testing.T) {
+	job := "TestCheckpoint"
+	client := newTestJob(t, job, 2)
+	defer client.Close()
+
+	if _, err := LatestCheckpoint(client, job); err != ErrKeyNotFound {
+		t.Errorf("LatestCheckpoint before commit, error want = %v, get = %v", ErrKeyNotFound, err)
+	}
+	for _, epoch := range []uint64{2, 4} {
+		for _, taskID := range []uint64{1, 0} {
+			cp := taskgraph.TaskCheckpoint{TaskID: taskID, Path: fmt.Sprintf("%d-%d", taskID, epoch), Checksum: "0"}
+			if err := ReportCheckpoint(client, job, epoch, cp); err != nil {
+				t.Fatalf("ReportCheckpoint failed: %v", err)
+			}
+		}
+	}
+	manifest, err := GetCheckpoint(client, job, 2)
+	if err != nil {
+		t.Fatalf("GetCheckpoint failed: %v", err)
+	}
+	want := taskgraph.CheckpointManifest{Epoch: 2, Tasks: []taskgraph.TaskCheckpoint{
+		{TaskID: 0, Path: "0-2", Checksum: "0"},
+		{TaskID: 1, Path: "1-2", Checksum: "0"},
+	}}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("GetCheckpoint want = %+v, get = %+v", want, manifest)
+	}
+
+	if err := CommitCheckpoint(client, job, 4); err != nil {
+		t.Fatalf("CommitCheckpoint failed: %v", err)
+	}
+	if err := CommitCheckpoint(client, job, 2); err != ErrCompareFailed {
+		t.Errorf("CommitCheckpoint of earlier epoch, error want = %v, get = %v", ErrCompareFailed, err)
+	}
+	if err := CommitCheckpoint(client, job, 4); err != ErrCompareFailed {
+		t.Errorf("CommitCheckpoint of the same epoch, error want = %v, get = %v", ErrCompareFailed, err)
+	}
+	if manifest, err := LatestCheckpoint(client, job); err != nil || manifest.Epoch != 4 || len(manifest.Tasks) != 2 {
+		t.Errorf("LatestCheckpoint want epoch 4 of 2 tasks, get = (%+v, %v)", manifest, err)
+	}
+	// reports of earlier epochs are dropped on commit.
+	if manifest, _ := GetCheckpoint(client, job, 2); len(manifest.Tasks) != 0 {
+		t.Errorf("reports of epoch 2 want none, get = %+v", manifest.Tasks)
+	}
+
+	// reports not committed are dropped, e.g. on resume.
+	if err := ReportCheckpoint(client, job, 6, taskgraph.TaskCheckpoint{TaskID: 0}); err != nil {
+		t.Fatalf("ReportCheckpoint failed: %v", err)
+	}
+	if err := dropUncommittedCheckpoints(client, job); err != nil {
+		t.Fatalf("dropUncommittedCheckpoints failed: %v", err)
+	}
+	if manifest, _ := GetCheckpoint(client, job, 6); len(manifest.Tasks) != 0 {
+		t.Errorf("reports of epoch 6 want none, get = %+v", manifest.Tasks)
+	}
+	if manifest, _ := LatestCheckpoint(client, job); len(manifest.Tasks) != 2 {
+		t.Errorf("committed reports want kept, get = %+v", manifest.Tasks)
+	}
+}
+
+func TestUncommittedCheckpoints(t *testing.T) {
+	job := "TestUncommittedCheckpoints"
+	kvs := map[string]string{
+		CheckpointPath(job):           "4",
+		CheckpointTaskPath(job, 4, 0): "{}",
+		CheckpointTaskPath(job, 6, 0): "{}",
+		CheckpointTaskPath(job, 2, 1): "{}",
+	}
+	tests := []struct {
+		committed string
+		want      map[string]bool
+	}{
+		{"4", map[string]bool{CheckpointTaskPath(job, 6, 0): true, CheckpointTaskPath(job, 2, 1): true}},
+		{"", map[string]bool{CheckpointTaskPath(job, 4, 0): true, CheckpointTaskPath(job, 6, 0): true, CheckpointTaskPath(job, 2, 1): true}},
+	}
+	for i, tt := range tests {
+		get := make(map[string]bool)
+		for _, key := range UncommittedCheckpoints(kvs, job, tt.committed) {
+			get[key] = true
+		}
+		if !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("#%d: UncommittedCheckpoints want = %v, get = %v", i, tt.want, get)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package etcdutil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
+)
+
+// In a stale-synchronous job, each task has its own clock, i.e. the epoch it's at,
+// and runs ahead of the slowest task by at most the staleness. The job's epoch is
+// the clock of the slowest task, so that everything depending on it, e.g. resume,
+// starts from where all tasks have got to.
+
+// ReportClock sets the clock of the task, which might go back once the task is
+// taken over. The job's epoch moves on to the slowest clock of the tasks at the
+// epoch, and never goes back.
+func ReportClock(client *clientv3.Client, job string, taskID, clock uint64) error {
+	if _, err := client.Put(context.Background(), ClockPath(job, taskID), strconv.FormatUint(clock, 10)); err != nil {
+		return err
+	}
+	key := EpochPath(job)
+	for {
+		value, _, err := GetValue(client, key)
+		if err != nil {
+			return err
+		}
+		epoch, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if epoch == taskgraph.ExitEpoch {
+			return nil
+		}
+		size, err := GetJobSize(client, job, epoch)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Get(context.Background(), dirPrefix(ClocksDirPath(job)), clientv3.WithPrefix())
+		if err != nil {
+			return err
+		}
+		clocks := make(map[string]string, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			clocks[string(kv.Key)] = string(kv.Value)
+		}
+		slowest := SlowestClock(clocks, job, epoch, size)
+		if slowest <= epoch {
+			return nil
+		}
+		txn, err := client.Txn(context.Background()).
+			If(clientv3.Compare(clientv3.Value(key), "=", value)).
+			Then(clientv3.OpPut(key, strconv.FormatUint(slowest, 10))).
+			Commit()
+		if err != nil {
+			return err
+		}
+		if txn.Succeeded {
+			return nil
+		}
+		// Someone else has moved the epoch, maybe not as far as the clocks
+		// we have seen. Check again.
+	}
+}
+
+// SlowestClock returns the slowest clock of the first size tasks, which are given
+// as a map from keys to values. Tasks that haven't reported are at the epoch.
+func SlowestClock(kvs map[string]string, job string, epoch, size uint64) uint64 {
+	clocks := make(map[uint64]uint64)
+	prefix := dirPrefix(ClocksDirPath(job))
+	for key, value := range kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		taskID, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		clock, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		clocks[taskID] = clock
+	}
+	var slowest uint64
+	for i := uint64(0); i < size; i++ {
+		clock, ok := clocks[i]
+		if !ok || clock < epoch {
+			return epoch
+		}
+		if i == 0 || clock < slowest {
+			slowest = clock
+		}
+	}
+	if size == 0 {
+		return epoch
+	}
+	return slowest
+}
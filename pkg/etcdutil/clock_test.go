@@ -0,0 +1,86 @@
+package etcdutil
+
+import (
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+)
+
+func TestReportClock(t *testing.T) {
+	job := "TestReportClock"
+	client := newTestJob(t, job, 2)
+	defer client.Close()
+	epoch := func() uint64 {
+		e, err := GetEpoch(client, job)
+		if err != nil {
+			t.Fatalf("GetEpoch failed: %v", err)
+		}
+		return e
+	}
+
+	// task 1 hasn't reported, which is at the job's epoch.
+	if err := ReportClock(client, job, 0, 3); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 0 {
+		t.Errorf("epoch want = 0, get = %d", e)
+	}
+	if err := ReportClock(client, job, 1, 2); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch want = slowest clock 2, get = %d", e)
+	}
+	// a task taken over goes back, but the job doesn't.
+	if err := ReportClock(client, job, 1, 1); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch want = 2, get = %d", e)
+	}
+	if err := ReportClock(client, job, 1, 5); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 3 {
+		t.Errorf("epoch want = slowest clock 3, get = %d", e)
+	}
+
+	// clocks don't move a job that has exited.
+	if err := SetEpoch(client, job, taskgraph.ExitEpoch); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+	if err := ReportClock(client, job, 0, 6); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != taskgraph.ExitEpoch {
+		t.Errorf("epoch want = %d, get = %d", uint64(taskgraph.ExitEpoch), e)
+	}
+}
+
+func TestSlowestClock(t *testing.T) {
+	job := "TestSlowestClock"
+	tests := []struct {
+		clocks      map[uint64]string
+		epoch, size uint64
+		want        uint64
+	}{
+		{map[uint64]string{0: "3", 1: "2"}, 1, 2, 2},
+		// task 1 hasn't reported.
+		{map[uint64]string{0: "3"}, 1, 2, 1},
+		// task 1 is taken over and goes back.
+		{map[uint64]string{0: "3", 1: "0"}, 1, 2, 1},
+		// task 2 is beyond the size.
+		{map[uint64]string{0: "3", 1: "4", 2: "1"}, 1, 2, 3},
+		{map[uint64]string{0: "bad", 1: "4"}, 1, 2, 1},
+		{nil, 1, 0, 1},
+	}
+	for i, tt := range tests {
+		kvs := make(map[string]string)
+		for taskID, clock := range tt.clocks {
+			kvs[ClockPath(job, taskID)] = clock
+		}
+		if get := SlowestClock(kvs, job, tt.epoch, tt.size); get != tt.want {
+			t.Errorf("#%d: SlowestClock want = %d, get = %d", i, tt.want, get)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package etcdutil
+
+import (
+	"log"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
+)
+
+// coordinator implements taskgraph.Coordinator on etcd. The session is a lease,
+// and the layout is described in layout.go.
+type coordinator struct {
+	client *clientv3.Client
+	lease  clientv3.LeaseID
+}
+
+// NewCoordinator returns a coordinator that works on the etcd client. The client
+// is closed along with the coordinator.
+func NewCoordinator(client *clientv3.Client) taskgraph.Coordinator {
+	return &coordinator{client: client}
+}
+
+func (c *coordinator) StartSession(heartbeatInterval time.Duration, ttlMultiplier uint64) error {
+	lease, err := GrantLease(c.client, heartbeatInterval, ttlMultiplier)
+	if err != nil {
+		return err
+	}
+	c.lease = lease
+	return nil
+}
+
+func (c *coordinator) Heartbeat(stop chan struct{}) error {
+	return KeepAlive(c.client, c.lease, stop)
+}
+
+func (c *coordinator) GetAndWatchEpoch(job string, epochC chan uint64, stop chan bool) (uint64, error) {
+	return GetAndWatchEpoch(c.client, job, epochC, stop)
+}
+
+func (c *coordinator) GetEpoch(job string) (uint64, error) {
+	return GetEpoch(c.client, job)
+}
+
+func (c *coordinator) CASEpoch(job string, prevEpoch, epoch uint64) error {
+	return CASEpoch(c.client, job, prevEpoch, epoch)
+}
+
+func (c *coordinator) ReportEpochDone(job string, epoch, taskID uint64) error {
+	return ReportEpochDone(c.client, job, epoch, taskID)
+}
+
+func (c *coordinator) ReportClock(job string, taskID, clock uint64) error {
+	return ReportClock(c.client, job, taskID, clock)
+}
+
+func (c *coordinator) SetEpoch(job string, epoch uint64) error {
+	return SetEpoch(c.client, job, epoch)
+}
+
+func (c *coordinator) WaitFreeTask(job string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return WaitFreeTask(c.client, job, stop, logger)
+}
+
+func (c *coordinator) TryOccupyTask(job string, taskID uint64, addr string) (bool, error) {
+	return TryOccupyTask(c.client, job, taskID, c.lease, addr)
+}
+
+func (c *coordinator) TryOccupyBackup(job string, taskID, replicaID uint64, addr string) (bool, error) {
+	return TryOccupyBackup(c.client, job, taskID, replicaID, c.lease, addr)
+}
+
+func (c *coordinator) ReleaseBackup(job string, taskID, replicaID uint64) error {
+	return ReleaseBackup(c.client, job, taskID, replicaID)
+}
+
+func (c *coordinator) DetectFailure(job string, stop chan bool) error {
+	return DetectFailure(c.client, job, stop)
+}
+
+func (c *coordinator) ReportFailure(job string, taskID uint64) error {
+	return ReportFailure(c.client, job, strconv.FormatUint(taskID, 10))
+}
+
+func (c *coordinator) RegisterMaster(job, addr string) error {
+	return RegisterMaster(c.client, job, addr)
+}
+
+func (c *coordinator) GetMasterAddress(job string) (string, error) {
+	return GetMasterAddress(c.client, job)
+}
+
+func (c *coordinator) TryOccupyWorker(job string, workerID uint64, addr string) (bool, error) {
+	return TryOccupyWorker(c.client, job, workerID, c.lease, addr)
+}
+
+func (c *coordinator) GetWorkerAddress(job string, workerID uint64) (string, error) {
+	return GetWorkerAddress(c.client, job, workerID)
+}
+
+func (c *coordinator) WaitFreeWorker(job string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return WaitFreeWorker(c.client, job, stop, logger)
+}
+
+func (c *coordinator) WatchWorkers(job string, stop chan bool, handler func(workerID uint64)) ([]uint64, error) {
+	return WatchWorkers(c.client, job, stop, handler)
+}
+
+func (c *coordinator) DetectWorkerFailure(job string, stop chan bool, handler func(workerID uint64)) error {
+	return DetectWorkerFailure(c.client, job, stop, handler)
+}
+
+func (c *coordinator) ReportWorkerFailure(job string, workerID uint64) error {
+	return ReportWorkerFailure(c.client, job, strconv.FormatUint(workerID, 10))
+}
+
+func (c *coordinator) SetMeta(job, linkType string, taskID, epoch uint64, meta string) error {
+	return SetMeta(c.client, job, linkType, taskID, epoch, meta)
+}
+
+func (c *coordinator) WatchMeta(job, linkType string, taskID, epoch uint64, stop chan bool, handler func(taskID uint64, meta string)) error {
+	return WatchMeta(c.client, job, linkType, taskID, epoch, stop, handler)
+}
+
+func (c *coordinator) GetAddress(job string, taskID uint64) (string, error) {
+	return GetAddress(c.client, job, taskID)
+}
+
+func (c *coordinator) WatchTaskAddress(job string, stop chan bool, handler func(taskID uint64, addr string)) error {
+	return WatchTaskAddress(c.client, job, stop, handler)
+}
+
+func (c *coordinator) InitJob(job string, numOfTasks uint64) error {
+	// Initilize the job epoch to 0
+	if _, err := Create(c.client, EpochPath(job), "0"); err != nil {
+		return err
+	}
+	if err := TouchJob(c.client, job); err != nil {
+		return err
+	}
+	if _, err := Create(c.client, JobStatusPath(job), ""); err != nil {
+		return err
+	}
+	if _, err := Create(c.client, JobSizePath(job, 0), strconv.FormatUint(numOfTasks, 10)); err != nil {
+		return err
+	}
+	// currently it creates as many unassigned tasks as task masters.
+	for i := uint64(0); i < numOfTasks; i++ {
+		if _, err := Create(c.client, FreeTaskPath(job, strconv.FormatUint(i, 10)), ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *coordinator) ResumeJob(job string, numOfTasks uint64, linkTypes []string) (uint64, error) {
+	value, _, err := GetValue(c.client, EpochPath(job))
+	if err != nil {
+		return 0, err
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	// the recorded size, if any, wins over the given one, as the job might
+	// have been resized.
+	sizes, err := getJobSizes(c.client, job)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := NewTasksFrom(sizes, job, epoch); err == nil {
+		numOfTasks = n
+	}
+	if err := TouchJob(c.client, job); err != nil {
+		return 0, err
+	}
+	// Healthy keys and addresses left are of dead processes. They go away once
+	// the leases expire, but we don't wait for that.
+	if _, err := c.client.Delete(context.Background(), dirPrefix(HealthyPath(job)), clientv3.WithPrefix()); err != nil {
+		return 0, err
+	}
+	if err := dropUncommittedCheckpoints(c.client, job); err != nil {
+		return 0, err
+	}
+	// tasks redo the epoch.
+	for _, dir := range []string{DoneDirPath(job), ClocksDirPath(job)} {
+		if _, err := c.client.Delete(context.Background(), dirPrefix(dir), clientv3.WithPrefix()); err != nil {
+			return 0, err
+		}
+	}
+	for i := uint64(0); i < numOfTasks; i++ {
+		if _, err := c.client.Delete(context.Background(), TaskMasterPath(job, i)); err != nil {
+			return 0, err
+		}
+		if _, err := c.client.Put(context.Background(), FreeTaskPath(job, strconv.FormatUint(i, 10)), ""); err != nil {
+			return 0, err
+		}
+		for _, linkType := range linkTypes {
+			if _, err := c.client.Delete(context.Background(), dirPrefix(MetaDirPath(linkType, job, i)), clientv3.WithPrefix()); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return epoch, nil
+}
+
+func (c *coordinator) ReportCheckpoint(job string, epoch uint64, checkpoint taskgraph.TaskCheckpoint) error {
+	return ReportCheckpoint(c.client, job, epoch, checkpoint)
+}
+
+func (c *coordinator) GetCheckpoint(job string, epoch uint64) (taskgraph.CheckpointManifest, error) {
+	return GetCheckpoint(c.client, job, epoch)
+}
+
+func (c *coordinator) CommitCheckpoint(job string, epoch uint64) error {
+	return CommitCheckpoint(c.client, job, epoch)
+}
+
+func (c *coordinator) LatestCheckpoint(job string) (taskgraph.CheckpointManifest, error) {
+	return LatestCheckpoint(c.client, job)
+}
+
+func (c *coordinator) SetConfig(job, key, value string) error {
+	return SetConfig(c.client, job, key, value)
+}
+
+func (c *coordinator) GetConfig(job, key string) (string, error) {
+	return GetConfig(c.client, job, key)
+}
+
+func (c *coordinator) ResizeJob(job string, epoch, numOfTasks uint64) error {
+	return ResizeJob(c.client, job, epoch, numOfTasks)
+}
+
+func (c *coordinator) GetJobSize(job string, epoch uint64) (uint64, error) {
+	return GetJobSize(c.client, job, epoch)
+}
+
+func (c *coordinator) GetAndWatchJobSizes(job string, stop chan bool, handler func(key, value string)) (map[string]string, error) {
+	return GetAndWatchJobSizes(c.client, job, stop, handler)
+}
+
+func (c *coordinator) DestroyJob(job string) error {
+	_, err := c.client.Delete(context.Background(), dirPrefix(path.Join("/", job)), clientv3.WithPrefix())
+	return err
+}
+
+func (c *coordinator) TouchJob(job string) error {
+	return TouchJob(c.client, job)
+}
+
+func (c *coordinator) ListJobs() ([]taskgraph.JobInfo, error) {
+	return ListJobs(c.client)
+}
+
+func (c *coordinator) DescribeJob(job string) (taskgraph.JobDetail, error) {
+	return DescribeJob(c.client, job)
+}
+
+func (c *coordinator) SetJobStatus(job string, status taskgraph.JobStatus) error {
+	if err := SetJobStatus(c.client, job, status); err != nil {
+		return err
+	}
+	return TouchJob(c.client, job)
+}
+
+func (c *coordinator) WatchJobStatus(job string, stop chan bool, handler func(status taskgraph.JobStatus)) error {
+	value, rev, err := GetValue(c.client, JobStatusPath(job))
+	if err != nil {
+		return err
+	}
+	if status := DecodeJobStatus(value); status.Done() {
+		go handler(status)
+		return nil
+	}
+	wch := watch(c.client, JobStatusPath(job), rev+1, stop)
+	go func() {
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				if status := DecodeJobStatus(string(ev.Kv.Value)); status.Done() {
+					handler(status)
+					return
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *coordinator) Close() error {
+	return c.client.Close()
+}
@@ -4,39 +4,121 @@ import (
 	"log"
 	"strconv"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
 )
 
-func GetAndWatchEpoch(client *etcd.Client, appname string, epochC chan uint64, stop chan bool) (uint64, error) {
-	resp, err := client.Get(EpochPath(appname), false, false)
+func GetAndWatchEpoch(client *clientv3.Client, appname string, epochC chan uint64, stop chan bool) (uint64, error) {
+	value, rev, err := GetValue(client, EpochPath(appname))
 	if err != nil {
-		log.Fatal("etcdutil: can not get epoch from etcd")
+		return 0, err
 	}
-	ep, err := strconv.ParseUint(resp.Node.Value, 10, 64)
+	ep, err := strconv.ParseUint(value, 10, 64)
 	if err != nil {
 		return 0, err
 	}
-	receiver := make(chan *etcd.Response, 1)
-	go client.Watch(EpochPath(appname), resp.EtcdIndex+1, false, receiver, stop)
+	wch := watch(client, EpochPath(appname), rev+1, stop)
 	go func() {
-		for resp := range receiver {
-			if resp.Action != "compareAndSwap" && resp.Action != "set" {
-				continue
-			}
-			epoch, err := strconv.ParseUint(resp.Node.Value, 10, 64)
-			if err != nil {
-				log.Fatal("etcdutil: can't parse epoch from etcd")
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				epoch, err := strconv.ParseUint(string(ev.Kv.Value), 10, 64)
+				if err != nil {
+					log.Printf("etcdutil: can't parse epoch from etcd: %v", err)
+					continue
+				}
+				epochC <- epoch
 			}
-			epochC <- epoch
 		}
 	}()
 
 	return ep, nil
 }
 
-func CASEpoch(client *etcd.Client, appname string, prevEpoch, epoch uint64) error {
-	prevEpochStr := strconv.FormatUint(prevEpoch, 10)
-	epochStr := strconv.FormatUint(epoch, 10)
-	_, err := client.CompareAndSwap(EpochPath(appname), epochStr, 0, prevEpochStr, 0)
+// GetEpoch returns current epoch of the job.
+func GetEpoch(client *clientv3.Client, appname string) (uint64, error) {
+	value, _, err := GetValue(client, EpochPath(appname))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// CASEpoch sets epoch only if current one is prevEpoch. Otherwise ErrCompareFailed
+// is returned.
+func CASEpoch(client *clientv3.Client, appname string, prevEpoch, epoch uint64) error {
+	key := EpochPath(appname)
+	resp, err := client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", strconv.FormatUint(prevEpoch, 10))).
+		Then(clientv3.OpPut(key, strconv.FormatUint(epoch, 10))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrCompareFailed
+	}
+	return nil
+}
+
+// SetEpoch sets the epoch regardless of current one, e.g. to shutdown the job.
+func SetEpoch(client *clientv3.Client, appname string, epoch uint64) error {
+	_, err := client.Put(context.Background(), EpochPath(appname), strconv.FormatUint(epoch, 10))
+	return err
+}
+
+// ReportEpochDone records that the task is done with the epoch, only if the job is
+// still at the epoch. Otherwise ErrCompareFailed is returned. Once a quorum of the
+// tasks at the epoch have reported, the job moves to the next epoch, and reports
+// of the epoch are dropped. A task reporting twice, e.g. after it's taken over,
+// counts once.
+func ReportEpochDone(client *clientv3.Client, job string, epoch, taskID uint64) error {
+	key := EpochPath(job)
+	current := clientv3.Compare(clientv3.Value(key), "=", strconv.FormatUint(epoch, 10))
+	resp, err := client.Txn(context.Background()).
+		If(current).
+		Then(clientv3.OpPut(EpochDonePath(job, epoch, taskID), "")).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrCompareFailed
+	}
+
+	done, _, err := getDir(client, EpochDoneDir(job, epoch))
+	if err != nil {
+		return err
+	}
+	size, err := GetJobSize(client, job, epoch)
+	if err != nil {
+		return err
+	}
+	quorum, _, err := GetValue(client, ConfigPath(job, EpochQuorum))
+	if err != nil && err != ErrKeyNotFound {
+		return err
+	}
+	if uint64(len(done)) < QuorumOf(size, quorum) {
+		return nil
+	}
+	// Someone else might have moved the job on. That's fine.
+	_, err = client.Txn(context.Background()).
+		If(current).
+		Then(clientv3.OpPut(key, strconv.FormatUint(epoch+1, 10)),
+			clientv3.OpDelete(dirPrefix(EpochDoneDir(job, epoch)), clientv3.WithPrefix())).
+		Commit()
 	return err
 }
+
+// QuorumOf returns the number of tasks that have to be done with an epoch of size
+// tasks, given the quorum config. It's all of them if the quorum is never set, or
+// beyond the size.
+func QuorumOf(size uint64, quorum string) uint64 {
+	n, err := strconv.ParseUint(quorum, 10, 64)
+	if err != nil || n == 0 || n > size {
+		return size
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package etcdutil
+
+import (
+	"testing"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// etcd needs to be running at localhost:2379 for tests of the transactions.
+func newTestJob(t *testing.T, job string, numOfTasks uint64) *clientv3.Client {
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{"http://localhost:2379"}})
+	if err != nil {
+		t.Fatalf("clientv3.New failed: %v", err)
+	}
+	if _, err := client.Delete(context.Background(), "/"+job, clientv3.WithPrefix()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := NewCoordinator(client).InitJob(job, numOfTasks); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	return client
+}
+
+func TestReportEpochDone(t *testing.T) {
+	job := "TestReportEpochDone"
+	client := newTestJob(t, job, 3)
+	defer client.Close()
+	epoch := func() uint64 {
+		e, err := GetEpoch(client, job)
+		if err != nil {
+			t.Fatalf("GetEpoch failed: %v", err)
+		}
+		return e
+	}
+
+	// task 1 reports twice, e.g. it's taken over.
+	for _, taskID := range []uint64{0, 1, 1} {
+		if err := ReportEpochDone(client, job, 0, taskID); err != nil {
+			t.Fatalf("ReportEpochDone failed: %v", err)
+		}
+	}
+	if e := epoch(); e != 0 {
+		t.Errorf("epoch before all tasks are done want = 0, get = %d", e)
+	}
+	if err := ReportEpochDone(client, job, 0, 2); err != nil {
+		t.Fatalf("ReportEpochDone failed: %v", err)
+	}
+	if e := epoch(); e != 1 {
+		t.Errorf("epoch after all tasks are done want = 1, get = %d", e)
+	}
+	// reports of the epoch are dropped once the job moves on.
+	if done, _, err := getDir(client, EpochDoneDir(job, 0)); err != nil || len(done) != 0 {
+		t.Errorf("reports of epoch 0 want none, get = (%d, %v)", len(done), err)
+	}
+	if err := ReportEpochDone(client, job, 0, 2); err != ErrCompareFailed {
+		t.Errorf("ReportEpochDone of past epoch, error want = %v, get = %v", ErrCompareFailed, err)
+	}
+
+	// a quorum of two moves the job on without the third.
+	if err := SetConfig(client, job, EpochQuorum, "2"); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	for _, taskID := range []uint64{2, 0} {
+		if err := ReportEpochDone(client, job, 1, taskID); err != nil {
+			t.Fatalf("ReportEpochDone failed: %v", err)
+		}
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch after a quorum is done want = 2, get = %d", e)
+	}
+}
+
+func TestCASEpoch(t *testing.T) {
+	job := "TestCASEpoch"
+	client := newTestJob(t, job, 1)
+	defer client.Close()
+
+	if err := CASEpoch(client, job, 1, 2); err != ErrCompareFailed {
+		t.Errorf("CASEpoch with wrong epoch, error want = %v, get = %v", ErrCompareFailed, err)
+	}
+	if err := CASEpoch(client, job, 0, 1); err != nil {
+		t.Fatalf("CASEpoch failed: %v", err)
+	}
+	if e, err := GetEpoch(client, job); e != 1 || err != nil {
+		t.Errorf("GetEpoch want = (1, nil), get = (%d, %v)", e, err)
+	}
+}
+
+func TestQuorumOf(t *testing.T) {
+	tests := []struct {
+		size   uint64
+		quorum string
+		want   uint64
+	}{
+		{3, "", 3},
+		{3, "0", 3},
+		{3, "2", 2},
+		{3, "4", 3},
+		{3, "bad", 3},
+	}
+	for i, tt := range tests {
+		if get := QuorumOf(tt.size, tt.quorum); get != tt.want {
+			t.Errorf("#%d: QuorumOf(%d, %q) want = %d, get = %d", i, tt.size, tt.quorum, tt.want, get)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package etcdutil
+
+import "errors"
+
+var (
+	// ErrKeyNotFound is returned when the key doesn't exist.
+	ErrKeyNotFound = errors.New("etcdutil: key not found")
+	// ErrKeyExists is returned when creating a key that already exists.
+	ErrKeyExists = errors.New("etcdutil: key already exists")
+	// ErrCompareFailed is returned when a transaction fails its compare,
+	// e.g. CASEpoch on a changed epoch. Retrying it won't help.
+	ErrCompareFailed = errors.New("etcdutil: compare failed")
+	// ErrStopped is returned when the caller stops waiting, e.g. WaitFreeTask.
+	ErrStopped = errors.New("etcdutil: stopped")
+)
+
+// IsCompareFailed tells whether the error is from a failed compare-and-swap.
+// Retrying it won't help.
+func IsCompareFailed(err error) bool {
+	return err == ErrCompareFailed
+}
+
+// IsKeyNotFound tells whether the error is because the key doesn't exist.
+func IsKeyNotFound(err error) bool {
+	return err == ErrKeyNotFound
+}
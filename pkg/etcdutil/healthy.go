@@ -1,109 +1,184 @@
 package etcdutil
 
 import (
+	"fmt"
 	"log"
 	"math/rand"
 	"path"
 	"strconv"
 	"time"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
 )
 
-// heartbeat to etcd cluster until stop
-func Heartbeat(client *etcd.Client, name string, taskID uint64, interval time.Duration, stop chan struct{}) error {
+// GrantLease grants the lease of a process. Keys owned by the process, e.g. its
+// healthy key and address, are attached to it. The lease expires if it isn't kept
+// alive for ttlMultiplier heartbeat intervals, and all the keys are gone at once.
+func GrantLease(client *clientv3.Client, interval time.Duration, ttlMultiplier uint64) (clientv3.LeaseID, error) {
+	resp, err := client.Grant(context.Background(), computeTTL(interval, ttlMultiplier))
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return resp.ID, nil
+}
+
+// KeepAlive refreshes the lease until stop. It returns error if the lease is lost,
+// i.e. the process has been considered failed.
+func KeepAlive(client *clientv3.Client, lease clientv3.LeaseID, stop chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := client.KeepAlive(ctx, lease)
+	if err != nil {
+		return err
+	}
 	for {
-		_, err := client.Set(TaskHealthyPath(name, taskID), "health", computeTTL(interval))
-		if err != nil {
-			return err
-		}
 		select {
-		case <-time.After(interval):
+		case _, ok := <-ch:
+			if !ok {
+				return fmt.Errorf("lease %x expired", lease)
+			}
 		case <-stop:
 			return nil
 		}
 	}
 }
 
-// detect failure of the given taskID
-func DetectFailure(client *etcd.Client, name string, stop chan bool) error {
-	receiver := make(chan *etcd.Response, 1)
-	go client.Watch(HealthyPath(name), 0, true, receiver, stop)
-	for resp := range receiver {
-		if resp.Action != "expire" && resp.Action != "delete" {
-			continue
+// detect failure of the given taskID. Tasks retired by resize aren't reported.
+func DetectFailure(client *clientv3.Client, name string, stop chan bool) error {
+	return detectFailure(client, HealthyPath(name), stop, func(idStr string) error {
+		retired, err := isRetired(client, name, idStr)
+		if err != nil || retired {
+			return err
+		}
+		return reportFailureIfFree(client, name, idStr)
+	})
+}
+
+// reportFailureIfFree is ReportFailure, but only if no one has taken over the task
+// yet, e.g. a backup of it. Otherwise the stale free key would keep standbys
+// trying to occupy the task.
+func reportFailureIfFree(client *clientv3.Client, name, failedTask string) error {
+	id, err := strconv.ParseUint(failedTask, 10, 64)
+	if err != nil {
+		return err
+	}
+	_, err = client.Txn(context.Background()).
+		If(notExist(TaskHealthyPath(name, id))).
+		Then(clientv3.OpPut(FreeTaskPath(name, failedTask), "failed")).
+		Commit()
+	return err
+}
+
+// DetectWorkerFailure watches workers' healthy keys. Once a worker fails, its ID is
+// reported free so that a standby worker can take over. Then onFailure is called.
+func DetectWorkerFailure(client *clientv3.Client, job string, stop chan bool, onFailure func(workerID uint64)) error {
+	return detectFailure(client, WorkerHealthyDir(job), stop, func(idStr string) error {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			return err
 		}
-		if err := ReportFailure(client, name, path.Base(resp.Node.Key)); err != nil {
+		if err := ReportWorkerFailure(client, job, idStr); err != nil {
 			return err
 		}
+		onFailure(id)
+		return nil
+	})
+}
+
+// A healthy key is deleted when the lease of its owner expires.
+func detectFailure(client *clientv3.Client, dir string, stop chan bool, report func(idStr string) error) error {
+	_, rev, err := getDir(client, dir)
+	if err != nil {
+		return err
+	}
+	for resp := range watch(client, dirPrefix(dir), rev+1, stop, clientv3.WithPrefix()) {
+		for _, ev := range resp.Events {
+			if ev.Type != clientv3.EventTypeDelete {
+				continue
+			}
+			if err := report(path.Base(string(ev.Kv.Key))); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
 // report failure to etcd cluster
 // If a framework detects a failure, it tries to report failure to /FreeTasks/{taskID}
-func ReportFailure(client *etcd.Client, name, failedTask string) error {
-	_, err := client.Set(FreeTaskPath(name, failedTask), "failed", 0)
+func ReportFailure(client *clientv3.Client, name, failedTask string) error {
+	_, err := client.Put(context.Background(), FreeTaskPath(name, failedTask), "failed")
 	return err
 }
 
-// WaitFreeTask blocks until it gets a hint of free task
-func WaitFreeTask(client *etcd.Client, name string, logger *log.Logger) (uint64, error) {
-	slots, err := client.Get(FreeTaskDir(name), false, true)
+// ReportWorkerFailure marks the worker ID free under /{job}/freeWorkers/{workerID}.
+func ReportWorkerFailure(client *clientv3.Client, job, failedWorker string) error {
+	_, err := client.Put(context.Background(), FreeWorkerPath(job, failedWorker), "failed")
+	return err
+}
+
+// WaitFreeTask blocks until it gets a hint of free task, or stop is closed, when
+// ErrStopped is returned.
+func WaitFreeTask(client *clientv3.Client, name string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return waitFree(client, FreeTaskDir(name), stop, logger)
+}
+
+// WaitFreeWorker blocks until it gets a hint of free worker ID, or stop is closed,
+// when ErrStopped is returned.
+func WaitFreeWorker(client *clientv3.Client, job string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return waitFree(client, FreeWorkerDir(job), stop, logger)
+}
+
+func waitFree(client *clientv3.Client, dir string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	slots, rev, err := getDir(client, dir)
 	if err != nil {
 		return 0, err
 	}
-	if total := len(slots.Node.Nodes); total > 0 {
+	if total := len(slots); total > 0 {
 		ri := rand.Intn(total)
-		s := slots.Node.Nodes[ri]
-		idStr := path.Base(s.Key)
+		idStr := path.Base(string(slots[ri].Key))
 		id, err := strconv.ParseUint(idStr, 0, 64)
 		if err != nil {
 			return 0, err
 		}
-		logger.Printf("got free task %v, randomly choose %d to try...", ListKeys(slots.Node.Nodes), ri)
+		logger.Printf("got free task %v, randomly choose %d to try...", ListKeys(slots), ri)
 		return id, nil
 	}
 
-	watchIndex := slots.EtcdIndex + 1
-	respChan := make(chan *etcd.Response, 1)
-	go func() {
-		for {
-			logger.Printf("start to wait failure at index %d", watchIndex)
-			resp, err := client.Watch(FreeTaskDir(name), watchIndex, true, nil, nil)
-			if err != nil {
-				logger.Printf("WARN: WaitFailure watch failed: %v", err)
-				return
-			}
-			if resp.Action == "set" {
-				respChan <- resp
-				return
-			}
-			watchIndex = resp.EtcdIndex + 1
-		}
-	}()
-	var resp *etcd.Response
+	logger.Printf("start to wait failure at revision %d", rev+1)
+	watchStop := make(chan bool, 1)
+	defer close(watchStop)
+	wch := watch(client, dirPrefix(dir), rev+1, watchStop, clientv3.WithPrefix())
 	var waitTime uint64 = 0
 	for {
 		select {
-		case resp = <-respChan:
-			idStr := path.Base(resp.Node.Key)
-			id, err := strconv.ParseUint(idStr, 10, 64)
-			if err != nil {
+		case resp, ok := <-wch:
+			if !ok {
+				return 0, fmt.Errorf("watch on %s is closed", dir)
+			}
+			if err := resp.Err(); err != nil {
 				return 0, err
 			}
-			return id, nil
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				return strconv.ParseUint(path.Base(string(ev.Kv.Key)), 10, 64)
+			}
 		case <-time.After(10 * time.Second):
 			waitTime++
 			logger.Printf("Node already wait failure for %d0s", waitTime)
+		case <-stop:
+			return 0, ErrStopped
 		}
 	}
-
 }
 
-func computeTTL(interval time.Duration) uint64 {
+// etcd TTL is in seconds, so interval less than a second is counted as one.
+func computeTTL(interval time.Duration, ttlMultiplier uint64) int64 {
 	if interval/time.Second < 1 {
-		return 3
+		return int64(ttlMultiplier)
 	}
-	return 3 * uint64(interval/time.Second)
+	return int64(ttlMultiplier) * int64(interval/time.Second)
 }
@@ -0,0 +1,209 @@
+package etcdutil
+
+import (
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
+)
+
+// TouchJob records now as the last time the job is known alive. Expired healthy
+// keys leave nothing behind in v3, so this is how we tell how long a job has
+// been abandoned.
+func TouchJob(client *clientv3.Client, job string) error {
+	_, err := client.Put(context.Background(), JobHeartbeatPath(job), time.Now().Format(time.RFC3339Nano))
+	return err
+}
+
+// SetConfig sets the job config of key.
+func SetConfig(client *clientv3.Client, job, key, value string) error {
+	_, err := client.Put(context.Background(), ConfigPath(job, key), value)
+	return err
+}
+
+// GetConfig returns the job config of key. ErrKeyNotFound is returned if it's
+// never set.
+func GetConfig(client *clientv3.Client, job, key string) (string, error) {
+	value, _, err := GetValue(client, ConfigPath(job, key))
+	return value, err
+}
+
+// ListJobs returns all jobs found in etcd. Only keys are read to find the jobs,
+// and then the status and heartbeat of each of them.
+func ListJobs(client *clientv3.Client) ([]taskgraph.JobInfo, error) {
+	resp, err := client.Get(context.Background(), "/", clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = ""
+	}
+	for _, job := range ParseJobs(kvs) {
+		txn, err := client.Txn(context.Background()).
+			Then(
+				clientv3.OpGet(JobStatusPath(job.Name)),
+				clientv3.OpGet(JobHeartbeatPath(job.Name)),
+			).
+			Commit()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range txn.Responses {
+			for _, kv := range r.GetResponseRange().Kvs {
+				kvs[string(kv.Key)] = string(kv.Value)
+			}
+		}
+	}
+	return ParseJobs(kvs), nil
+}
+
+// ParseJobs finds jobs in the key space, which is given as a map from keys to
+// values. A job is a top level directory that has an epoch or a master. Jobs are
+// sorted by name.
+func ParseJobs(kvs map[string]string) []taskgraph.JobInfo {
+	jobs := make(map[string]*taskgraph.JobInfo)
+	live := make(map[string]int)
+	for key := range kvs {
+		parts := strings.SplitN(strings.TrimPrefix(key, "/"), "/", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		name := parts[0]
+		switch {
+		case len(parts) == 2 && parts[1] == Epoch, parts[1] == MasterDir:
+			if _, ok := jobs[name]; !ok {
+				jobs[name] = &taskgraph.JobInfo{Name: name}
+			}
+		case len(parts) == 3 && (parts[1] == Healthy || parts[1] == WorkerHealthy):
+			live[name]++
+		}
+	}
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]taskgraph.JobInfo, 0, len(names))
+	for _, name := range names {
+		job := jobs[name]
+		job.LiveTasks = live[name]
+		job.Status = DecodeJobStatus(kvs[JobStatusPath(name)])
+		// zero time if it's never touched or can't be parsed.
+		job.LastHeartbeat, _ = time.Parse(time.RFC3339Nano, kvs[JobHeartbeatPath(name)])
+		res = append(res, *job)
+	}
+	return res
+}
+
+// DescribeJob returns a snapshot of the job and its tasks.
+func DescribeJob(client *clientv3.Client, job string) (taskgraph.JobDetail, error) {
+	resp, err := client.Get(context.Background(), dirPrefix(path.Join("/", job)), clientv3.WithPrefix())
+	if err != nil {
+		return taskgraph.JobDetail{}, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return ParseJobDetail(job, kvs)
+}
+
+// ParseJobDetail makes a snapshot of the job from its keys, which are given as a
+// map from keys to values. ErrKeyNotFound is returned if the job isn't there.
+func ParseJobDetail(job string, kvs map[string]string) (taskgraph.JobDetail, error) {
+	var detail taskgraph.JobDetail
+	for _, info := range ParseJobs(kvs) {
+		if info.Name == job {
+			detail.JobInfo = info
+		}
+	}
+	if detail.Name == "" {
+		return detail, ErrKeyNotFound
+	}
+	if value, ok := kvs[EpochPath(job)]; ok {
+		epoch, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return detail, err
+		}
+		detail.Epoch = epoch
+	}
+	// zero if the size is never recorded.
+	detail.NumOfTasks, _ = JobSizeAt(kvs, job, detail.Epoch)
+
+	tasks := make(map[uint64]*taskgraph.TaskInfo)
+	getTask := func(idStr string) *taskgraph.TaskInfo {
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			return nil
+		}
+		if _, ok := tasks[id]; !ok {
+			tasks[id] = &taskgraph.TaskInfo{
+				ID:      id,
+				Metas:   make(map[string]string),
+				Backups: make(map[uint64]string),
+			}
+		}
+		return tasks[id]
+	}
+	metaEpochs := make(map[string]uint64)
+	prefix := dirPrefix(path.Join("/", job))
+	for key, value := range kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+		switch {
+		case len(parts) == 3 && parts[0] == TasksDir:
+			task := getTask(parts[1])
+			if task == nil {
+				continue
+			}
+			replicaID, err := strconv.ParseUint(parts[2], 10, 64)
+			switch {
+			case parts[2] == TaskMaster:
+				task.Address = value
+			case err == nil:
+				task.Backups[replicaID] = value
+			}
+		case len(parts) == 4 && parts[0] == TasksDir:
+			task := getTask(parts[1])
+			epoch, err := strconv.ParseUint(parts[3], 10, 64)
+			if task == nil || err != nil {
+				continue
+			}
+			// the latest one of the link type.
+			metaKey := path.Join(parts[1], parts[2])
+			if latest, ok := metaEpochs[metaKey]; ok && latest > epoch {
+				continue
+			}
+			metaEpochs[metaKey] = epoch
+			task.Metas[parts[2]] = parts[3] + "-" + value
+		case len(parts) == 2 && parts[0] == Healthy:
+			if task := getTask(parts[1]); task != nil {
+				task.Healthy = true
+			}
+		case len(parts) == 2 && parts[0] == FreeDir:
+			if task := getTask(parts[1]); task != nil {
+				task.Free = true
+			}
+		}
+	}
+	detail.Tasks = make([]taskgraph.TaskInfo, 0, len(tasks))
+	for _, task := range tasks {
+		detail.Tasks = append(detail.Tasks, *task)
+	}
+	sort.Sort(byTaskID(detail.Tasks))
+	return detail, nil
+}
+
+type byTaskID []taskgraph.TaskInfo
+
+func (s byTaskID) Len() int           { return len(s) }
+func (s byTaskID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byTaskID) Less(i, j int) bool { return s[i].ID < s[j].ID }
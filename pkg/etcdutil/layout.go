@@ -7,41 +7,124 @@ import (
 
 // The directory layout we going to define in etcd:
 //   /{app}/config -> application configuration
+//   /{app}/config/size/{epoch} -> number of tasks since the epoch, see ResizeJob
+//   /{app}/config/backups -> number of backups of each task, see TryOccupyBackup
+//   /{app}/config/epochQuorum -> number of tasks to be done with an epoch, see ReportEpochDone
+//   /{app}/config/staleness -> how many epochs a task can run ahead of the slowest, see ReportClock
 //   /{app}/epoch -> global value for epoch
+//   /{app}/done/{epoch}/{taskID} -> the task is done with the epoch
+//   /{app}/clocks/{taskID} -> epoch of the task in a stale-synchronous job
 //   /{app}/tasks/: register tasks under this directory
 //   /{app}/tasks/{taskID}/{replicaID} -> pointer to nodes, 0 replicaID means master
-//   /{app}/tasks/{taskID}/parentMeta
-//   /{app}/tasks/{taskID}/childMeta
+//   /{app}/tasks/{taskID}/{linkType}/{epoch} -> meta flagged by the task at the epoch, see SetMeta
 //   /{app}/healthy/{taskID} -> tasks' healthy condition
 //   /{app}/nodes/: register nodes under this directory
 //   /{app}/nodes/{nodeID}/address -> scheme://host:port/{path(if http)}
 //   /{app}/nodes/{nodeID}/ttl -> keep alive timeout
 //   /{app}/FreeTasks/{taskID}
+//   /{app}/heartbeat -> last time the job is known alive, see TouchJob
+//   /{app}/checkpoints/{epoch}/{taskID} -> snapshot reported by the task, see ReportCheckpoint
+//   /{app}/checkpoint -> epoch of the latest committed checkpoint
 
-// /{job}/master/{replicaID}
-// /{job}/worker/{workerID}
+// For master-worker paradigm:
+//   /{job}/master/{replicaID} -> master address
+//   /{job}/worker/{workerID} -> worker address
+//   /{job}/workerHealthy/{workerID} -> workers' healthy condition
+//   /{job}/freeWorkers/{workerID} -> worker ID left by failed worker
+
+// cmd/taskgraphctl shows jobs in this layout.
+
+// There is no directory in etcd v3. A directory is simply the prefix of its keys.
+// Each process grants one lease. Its healthy key and address are attached to
+// the lease, so they are deleted at once when the process fails.
 
 const (
-	TasksDir   = "tasks"
-	NodesDir   = "nodes"
-	ConfigDir  = "config"
-	FreeDir    = "freeTasks"
-	Epoch      = "epoch"
-	Status     = "status"
-	TaskMaster = "0"
-	NodeAddr   = "address"
-	NodeTTL    = "ttl"
-	Healthy    = "healthy"
+	TasksDir       = "tasks"
+	NodesDir       = "nodes"
+	ConfigDir      = "config"
+	SizeDir        = "size"
+	Backups        = "backups"
+	EpochQuorum    = "epochQuorum"
+	DoneDir        = "done"
+	Staleness      = "staleness"
+	ClocksDir      = "clocks"
+	FreeDir        = "freeTasks"
+	Epoch          = "epoch"
+	Status         = "status"
+	TaskMaster     = "0"
+	NodeAddr       = "address"
+	NodeTTL        = "ttl"
+	Healthy        = "healthy"
+	Heartbeat      = "heartbeat"
+	Checkpoint     = "checkpoint"
+	CheckpointsDir = "checkpoints"
+
+	MasterDir     = "master"
+	WorkerDir     = "worker"
+	WorkerHealthy = "workerHealthy"
+	FreeWorkers   = "freeWorkers"
 )
 
 func EpochPath(appName string) string {
 	return path.Join("/", appName, Epoch)
 }
 
+func DoneDirPath(appName string) string {
+	return path.Join("/", appName, DoneDir)
+}
+
+func EpochDoneDir(appName string, epoch uint64) string {
+	return path.Join(DoneDirPath(appName), strconv.FormatUint(epoch, 10))
+}
+
+func EpochDonePath(appName string, epoch, taskID uint64) string {
+	return path.Join(EpochDoneDir(appName, epoch), strconv.FormatUint(taskID, 10))
+}
+
+func ClocksDirPath(appName string) string {
+	return path.Join("/", appName, ClocksDir)
+}
+
+func ClockPath(appName string, taskID uint64) string {
+	return path.Join(ClocksDirPath(appName), strconv.FormatUint(taskID, 10))
+}
+
 func JobStatusPath(appName string) string {
 	return path.Join("/", appName, Status)
 }
 
+func JobHeartbeatPath(appName string) string {
+	return path.Join("/", appName, Heartbeat)
+}
+
+func JobSizeDir(appName string) string {
+	return path.Join("/", appName, ConfigDir, SizeDir)
+}
+
+func JobSizePath(appName string, epoch uint64) string {
+	return path.Join(JobSizeDir(appName), strconv.FormatUint(epoch, 10))
+}
+
+func CheckpointPath(appName string) string {
+	return path.Join("/", appName, Checkpoint)
+}
+
+func CheckpointDir(appName string) string {
+	return path.Join("/", appName, CheckpointsDir)
+}
+
+func CheckpointEpochDir(appName string, epoch uint64) string {
+	return path.Join(CheckpointDir(appName), strconv.FormatUint(epoch, 10))
+}
+
+func CheckpointTaskPath(appName string, epoch, taskID uint64) string {
+	return path.Join(CheckpointEpochDir(appName, epoch), strconv.FormatUint(taskID, 10))
+}
+
+func ConfigPath(appName, key string) string {
+	return path.Join("/", appName, ConfigDir, key)
+}
+
 func HealthyPath(appName string) string {
 	return path.Join("/", appName, Healthy)
 }
@@ -64,7 +147,17 @@ func TaskMasterPath(appName string, taskID uint64) string {
 	return path.Join("/", appName, TasksDir, strconv.FormatUint(taskID, 10), TaskMaster)
 }
 
-func MetaPath(linkType, appName string, taskID uint64) string {
+// TaskReplicaPath is where a backup of the task registers its address. replicaID
+// starts from 1, as 0 is the primary, see TaskMasterPath.
+func TaskReplicaPath(appName string, taskID, replicaID uint64) string {
+	return path.Join("/", appName, TasksDir, strconv.FormatUint(taskID, 10), strconv.FormatUint(replicaID, 10))
+}
+
+func MetaPath(linkType, appName string, taskID, epoch uint64) string {
+	return path.Join(MetaDirPath(linkType, appName, taskID), strconv.FormatUint(epoch, 10))
+}
+
+func MetaDirPath(linkType, appName string, taskID uint64) string {
 	return path.Join("/",
 		appName,
 		TasksDir,
@@ -73,8 +166,29 @@ func MetaPath(linkType, appName string, taskID uint64) string {
 }
 
 func MasterPath(job string) string {
-	return path.Join("/", job, "master/0")
+	return path.Join("/", job, MasterDir, TaskMaster)
 }
+
+func WorkerDirPath(job string) string {
+	return path.Join("/", job, WorkerDir)
+}
+
 func WorkerPath(job string, id uint64) string {
-	return path.Join("/", job, strconv.FormatUint(id, 10))
+	return path.Join(WorkerDirPath(job), strconv.FormatUint(id, 10))
+}
+
+func WorkerHealthyDir(job string) string {
+	return path.Join("/", job, WorkerHealthy)
+}
+
+func WorkerHealthyPath(job string, id uint64) string {
+	return path.Join(WorkerHealthyDir(job), strconv.FormatUint(id, 10))
+}
+
+func FreeWorkerDir(job string) string {
+	return path.Join("/", job, FreeWorkers)
+}
+
+func FreeWorkerPath(job, idStr string) string {
+	return path.Join(FreeWorkerDir(job), idStr)
 }
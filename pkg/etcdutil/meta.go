@@ -1,22 +1,89 @@
 package etcdutil
 
-import "github.com/coreos/go-etcd/etcd"
+import (
+	"path"
+	"strconv"
 
-func WatchMeta(c *etcd.Client, taskID uint64, path string, stop chan bool, responseHandler func(*etcd.Response, uint64)) error {
-	resp, err := c.Get(path, false, false)
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// Metas are keyed by the epoch they are flagged at. In a stale-synchronous job,
+// a task might flag metas of later epochs before a slower neighbor watches the
+// one of its epoch, which would be lost if they shared a key.
+
+// SetMeta flags the meta of the task at the epoch. Metas the task has flagged
+// before the job's epoch are dropped, as no task is at those epochs any more.
+func SetMeta(client *clientv3.Client, job, linkType string, taskID, epoch uint64, meta string) error {
+	resp, err := client.Txn(context.Background()).Then(
+		clientv3.OpGet(EpochPath(job)),
+		clientv3.OpGet(dirPrefix(MetaDirPath(linkType, job, taskID)), clientv3.WithPrefix(), clientv3.WithKeysOnly()),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	epochKvs := resp.Responses[0].GetResponseRange().Kvs
+	if len(epochKvs) == 0 {
+		return ErrKeyNotFound
+	}
+	jobEpoch, err := strconv.ParseUint(string(epochKvs[0].Value), 10, 64)
 	if err != nil {
 		return err
 	}
+	var keys []string
+	for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	ops := []clientv3.Op{clientv3.OpPut(MetaPath(linkType, job, taskID, epoch), meta)}
+	for _, key := range StaleMetas(keys, jobEpoch) {
+		ops = append(ops, clientv3.OpDelete(key))
+	}
+	_, err = client.Txn(context.Background()).Then(ops...).Commit()
+	return err
+}
+
+// WatchMeta calls handler with the meta flagged by the task at the epoch,
+// including the one flagged before the watch. ErrKeyNotFound is returned if there
+// is no such job.
+func WatchMeta(client *clientv3.Client, job, linkType string, taskID, epoch uint64, stop chan bool, handler func(taskID uint64, meta string)) error {
+	key := MetaPath(linkType, job, taskID, epoch)
+	resp, err := client.Txn(context.Background()).Then(
+		clientv3.OpGet(EpochPath(job)),
+		clientv3.OpGet(key),
+	).Commit()
+	if err != nil {
+		return err
+	}
+	if len(resp.Responses[0].GetResponseRange().Kvs) == 0 {
+		return ErrKeyNotFound
+	}
 	// Get previous meta. We need to handle it.
-	if resp.Node.Value != "" {
-		go responseHandler(resp, taskID)
-	}
-	receiver := make(chan *etcd.Response, 1)
-	go c.Watch(path, resp.EtcdIndex+1, false, receiver, stop)
-	go func(receiver chan *etcd.Response) {
-		for resp := range receiver {
-			responseHandler(resp, taskID)
+	if kvs := resp.Responses[1].GetResponseRange().Kvs; len(kvs) > 0 {
+		go handler(taskID, string(kvs[0].Value))
+	}
+	wch := watch(client, key, resp.Header.Revision+1, stop)
+	go func() {
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				handler(taskID, string(ev.Kv.Value))
+			}
 		}
-	}(receiver)
+	}()
 	return nil
 }
+
+// StaleMetas returns the keys of metas, of a task and link type, flagged before
+// the job's epoch.
+func StaleMetas(keys []string, jobEpoch uint64) []string {
+	var stale []string
+	for _, key := range keys {
+		epoch, err := strconv.ParseUint(path.Base(key), 10, 64)
+		if err == nil && epoch < jobEpoch {
+			stale = append(stale, key)
+		}
+	}
+	return stale
+}
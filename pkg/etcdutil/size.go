@@ -0,0 +1,166 @@
+package etcdutil
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
+)
+
+// A job is resized only at epoch boundaries. Each resize records the number of
+// tasks since the epoch it takes effect, so that the size at any epoch can be
+// told, including the current one while a resize is pending for the next.
+
+// GetJobSize returns the number of tasks at the epoch. ExitEpoch gives the latest
+// size, pending or not. ErrKeyNotFound is returned if the job never records its
+// size, e.g. it's set up by earlier versions.
+func GetJobSize(client *clientv3.Client, job string, epoch uint64) (uint64, error) {
+	kvs, err := getJobSizes(client, job)
+	if err != nil {
+		return 0, err
+	}
+	return JobSizeAt(kvs, job, epoch)
+}
+
+// GetAndWatchJobSizes returns the recorded sizes of the job, and calls handler with
+// each size recorded later until stop.
+func GetAndWatchJobSizes(client *clientv3.Client, job string, stop chan bool, handler func(key, value string)) (map[string]string, error) {
+	dir := dirPrefix(JobSizeDir(job))
+	resp, err := client.Get(context.Background(), dir, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	wch := watch(client, dir, resp.Header.Revision+1, stop, clientv3.WithPrefix())
+	go func() {
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				if ev.Type == clientv3.EventTypePut {
+					handler(string(ev.Kv.Key), string(ev.Kv.Value))
+				}
+			}
+		}
+	}()
+	return kvs, nil
+}
+
+// ResizeJob makes the job have numOfTasks tasks from epoch+1 on, only if the job
+// is still at epoch. Otherwise ErrCompareFailed is returned. New tasks are set up
+// free at once, so that bootstraps can get ready before they join. Tasks beyond
+// the new size retire themselves at epoch+1.
+func ResizeJob(client *clientv3.Client, job string, epoch, numOfTasks uint64) error {
+	kvs, err := getJobSizes(client, job)
+	if err != nil {
+		return err
+	}
+	from, err := NewTasksFrom(kvs, job, epoch)
+	if err != nil {
+		return err
+	}
+	ops := []clientv3.Op{clientv3.OpPut(JobSizePath(job, epoch+1), strconv.FormatUint(numOfTasks, 10))}
+	for i := from; i < numOfTasks; i++ {
+		ops = append(ops, clientv3.OpPut(FreeTaskPath(job, strconv.FormatUint(i, 10)), ""))
+	}
+	key := EpochPath(job)
+	resp, err := client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", strconv.FormatUint(epoch, 10))).
+		Then(ops...).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrCompareFailed
+	}
+	return nil
+}
+
+// JobSizeAt finds the number of tasks at the epoch from the recorded sizes, which
+// are given as a map from keys to values.
+func JobSizeAt(kvs map[string]string, job string, epoch uint64) (uint64, error) {
+	prefix := dirPrefix(JobSizeDir(job))
+	found := false
+	var since, size uint64
+	for key, value := range kvs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		e, err := strconv.ParseUint(strings.TrimPrefix(key, prefix), 10, 64)
+		if err != nil || e > epoch || (found && e < since) {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		found, since, size = true, e, n
+	}
+	if !found {
+		return 0, ErrKeyNotFound
+	}
+	return size, nil
+}
+
+// NewTasksFrom returns the first task ID that a resize at the epoch has to set up.
+// Tasks below it are either running, or already set up by an earlier resize
+// pending for the same epoch.
+func NewTasksFrom(kvs map[string]string, job string, epoch uint64) (uint64, error) {
+	size, err := JobSizeAt(kvs, job, epoch)
+	if err != nil {
+		return 0, err
+	}
+	latest, err := JobSizeAt(kvs, job, taskgraph.ExitEpoch)
+	if err != nil {
+		return 0, err
+	}
+	if latest > size {
+		return latest, nil
+	}
+	return size, nil
+}
+
+// IsRetired tells whether the task has left the job for good, i.e. it's beyond
+// the size at the epoch and the size of any pending resize. Its failure shouldn't
+// be reported then. Tasks of jobs that never record the size don't retire.
+func IsRetired(kvs map[string]string, job string, epoch, taskID uint64) bool {
+	from, err := NewTasksFrom(kvs, job, epoch)
+	return err == nil && taskID >= from
+}
+
+// isRetired is IsRetired with the epoch and sizes read from etcd.
+func isRetired(client *clientv3.Client, job, idStr string) (bool, error) {
+	taskID, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	value, _, err := GetValue(client, EpochPath(job))
+	if err != nil {
+		return false, err
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return false, err
+	}
+	kvs, err := getJobSizes(client, job)
+	if err != nil {
+		return false, err
+	}
+	return IsRetired(kvs, job, epoch, taskID), nil
+}
+
+func getJobSizes(client *clientv3.Client, job string) (map[string]string, error) {
+	resp, err := client.Get(context.Background(), dirPrefix(JobSizeDir(job)), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	kvs := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		kvs[string(kv.Key)] = string(kv.Value)
+	}
+	return kvs, nil
+}
@@ -0,0 +1,86 @@
+package etcdutil
+
+import (
+	"testing"
+
+	"github.com/taskgraph/taskgraph"
+)
+
+func TestResizeJob(t *testing.T) {
+	job := "TestResizeJob"
+	client := newTestJob(t, job, 2)
+	defer client.Close()
+
+	if err := ResizeJob(client, job, 1, 3); err != ErrCompareFailed {
+		t.Errorf("ResizeJob at wrong epoch, error want = %v, get = %v", ErrCompareFailed, err)
+	}
+	if err := ResizeJob(client, job, 0, 3); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	tests := []struct {
+		epoch, size uint64
+	}{
+		{0, 2},
+		{1, 3},
+		{taskgraph.ExitEpoch, 3},
+	}
+	for i, tt := range tests {
+		if size, err := GetJobSize(client, job, tt.epoch); size != tt.size || err != nil {
+			t.Errorf("#%d: GetJobSize want = (%d, nil), get = (%d, %v)", i, tt.size, size, err)
+		}
+	}
+	// the new task is free before it joins.
+	if _, _, err := GetValue(client, FreeTaskPath(job, "2")); err != nil {
+		t.Errorf("free task 2 want set, get error %v", err)
+	}
+
+	// task 2 retires once the job shrinks back at epoch 2.
+	if err := SetEpoch(client, job, 1); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+	if err := ResizeJob(client, job, 1, 2); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	if retired, err := isRetired(client, job, "2"); err != nil || retired {
+		t.Errorf("isRetired of task 2 at epoch 1 want = (false, nil), get = (%v, %v)", retired, err)
+	}
+	if err := SetEpoch(client, job, 2); err != nil {
+		t.Fatalf("SetEpoch failed: %v", err)
+	}
+	if retired, err := isRetired(client, job, "2"); err != nil || !retired {
+		t.Errorf("isRetired of task 2 at epoch 2 want = (true, nil), get = (%v, %v)", retired, err)
+	}
+}
+
+func TestJobSizeAt(t *testing.T) {
+	job := "TestJobSizeAt"
+	kvs := map[string]string{
+		JobSizePath(job, 0): "2",
+		JobSizePath(job, 3): "4",
+	}
+	tests := []struct {
+		epoch uint64
+		size  uint64
+		from  uint64
+	}{
+		// the resize to 4 tasks is pending before epoch 3.
+		{0, 2, 4},
+		{2, 2, 4},
+		{3, 4, 4},
+		{taskgraph.ExitEpoch, 4, 4},
+	}
+	for i, tt := range tests {
+		if size, err := JobSizeAt(kvs, job, tt.epoch); size != tt.size || err != nil {
+			t.Errorf("#%d: JobSizeAt want = (%d, nil), get = (%d, %v)", i, tt.size, size, err)
+		}
+		if from, err := NewTasksFrom(kvs, job, tt.epoch); from != tt.from || err != nil {
+			t.Errorf("#%d: NewTasksFrom want = (%d, nil), get = (%d, %v)", i, tt.from, from, err)
+		}
+	}
+	if _, err := JobSizeAt(nil, job, 0); err != ErrKeyNotFound {
+		t.Errorf("JobSizeAt of no sizes, error want = %v, get = %v", ErrKeyNotFound, err)
+	}
+	if IsRetired(nil, job, 0, 5) {
+		t.Errorf("tasks of jobs that never record the size shouldn't retire")
+	}
+}
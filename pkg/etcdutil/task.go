@@ -1,42 +1,123 @@
 package etcdutil
 
 import (
+	"path"
 	"strconv"
-	"strings"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/taskgraph/taskgraph"
+	"golang.org/x/net/context"
 )
 
-func TryOccupyTask(client *etcd.Client, name string, taskID uint64, connection string) (bool, error) {
-	_, err := client.Create(TaskHealthyPath(name, taskID), "health", 3)
-	if err != nil {
-		if strings.Contains(err.Error(), "Key already exists") {
-			return false, nil
-		}
-		return false, err
-	}
+// TryOccupyTask registers the process as the task in one transaction, if no one else
+// has done so. Healthy key and address are attached to the process's lease.
+func TryOccupyTask(client *clientv3.Client, name string, taskID uint64, lease clientv3.LeaseID, connection string) (bool, error) {
 	idStr := strconv.FormatUint(taskID, 10)
-	client.Delete(FreeTaskPath(name, idStr), false)
-	_, err = client.Set(TaskMasterPath(name, taskID), connection, 0)
+	return tryOccupy(client, TaskHealthyPath(name, taskID), FreeTaskPath(name, idStr), TaskMasterPath(name, taskID), lease, connection)
+}
+
+// TryOccupyWorker is like TryOccupyTask but for worker in master-worker paradigm.
+// On success, the worker's address is registered so that others can find it.
+func TryOccupyWorker(client *clientv3.Client, job string, workerID uint64, lease clientv3.LeaseID, connection string) (bool, error) {
+	idStr := strconv.FormatUint(workerID, 10)
+	return tryOccupy(client, WorkerHealthyPath(job, workerID), FreeWorkerPath(job, idStr), WorkerPath(job, workerID), lease, connection)
+}
+
+func tryOccupy(client *clientv3.Client, healthyKey, freeKey, addrKey string, lease clientv3.LeaseID, connection string) (bool, error) {
+	resp, err := client.Txn(context.Background()).
+		If(notExist(healthyKey)).
+		Then(
+			clientv3.OpPut(healthyKey, "health", clientv3.WithLease(lease)),
+			clientv3.OpDelete(freeKey),
+			clientv3.OpPut(addrKey, connection, clientv3.WithLease(lease)),
+		).
+		// Someone still holds it, so the free hint is stale, e.g. reported by
+		// hand. Drop it, or standbys would keep trying it.
+		Else(clientv3.OpDelete(freeKey)).
+		Commit()
 	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return resp.Succeeded, nil
 }
 
 // getAddress will return the host:port address of the service taking care of
 // the task that we want to talk to.
 // Currently we grab the information from etcd every time. Local cache could be used.
 // If it failed, e.g. network failure, it should return error.
-func GetAddress(client *etcd.Client, name string, id uint64) (string, error) {
-	resp, err := client.Get(TaskMasterPath(name, id), false, false)
+func GetAddress(client *clientv3.Client, name string, id uint64) (string, error) {
+	addr, _, err := GetValue(client, TaskMasterPath(name, id))
+	return addr, err
+}
+
+// RegisterMaster puts the address of the master at /{job}/master.
+func RegisterMaster(client *clientv3.Client, job, addr string) error {
+	_, err := client.Put(context.Background(), MasterPath(job), addr)
+	return err
+}
+
+// GetMasterAddress returns the address of the master.
+func GetMasterAddress(client *clientv3.Client, job string) (string, error) {
+	addr, _, err := GetValue(client, MasterPath(job))
+	return addr, err
+}
+
+// GetWorkerAddress returns the address of the worker.
+func GetWorkerAddress(client *clientv3.Client, job string, workerID uint64) (string, error) {
+	addr, _, err := GetValue(client, WorkerPath(job, workerID))
+	return addr, err
+}
+
+// WatchTaskAddress watches address changes of all tasks. It happens when a node
+// takes over a failed task. The handler is called with the task and new address.
+// Address is empty if the task's node has failed and no one takes over yet.
+func WatchTaskAddress(client *clientv3.Client, name string, stop chan bool, handler func(taskID uint64, addr string)) error {
+	_, rev, err := getDir(client, TaskDirPath(name))
 	if err != nil {
-		return "", err
+		return err
 	}
-	return resp.Node.Value, nil
+	wch := watch(client, dirPrefix(TaskDirPath(name)), rev+1, stop, clientv3.WithPrefix())
+	go func() {
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				key := string(ev.Kv.Key)
+				// Meta keys are also under the task directory.
+				if path.Base(key) != TaskMaster {
+					continue
+				}
+				id, err := strconv.ParseUint(path.Base(path.Dir(key)), 10, 64)
+				if err != nil {
+					continue
+				}
+				handler(id, string(ev.Kv.Value))
+			}
+		}
+	}()
+	return nil
 }
 
-func SetJobStatus(client *etcd.Client, name string, status int) error {
-	_, err := client.Set(JobStatusPath(name), "done", 0)
-	return err
+// SetJobStatus sets the status only if the job is still running.
+func SetJobStatus(client *clientv3.Client, name string, status taskgraph.JobStatus) error {
+	key := JobStatusPath(name)
+	resp, err := client.Txn(context.Background()).
+		If(clientv3.Compare(clientv3.Value(key), "=", "")).
+		Then(clientv3.OpPut(key, status.String())).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !resp.Succeeded {
+		return ErrCompareFailed
+	}
+	return nil
+}
+
+// DecodeJobStatus parses the stored job status. A status that can't be parsed is
+// taken as failed, with itself as the reason.
+func DecodeJobStatus(value string) taskgraph.JobStatus {
+	status, err := taskgraph.ParseJobStatus(value)
+	if err != nil {
+		return taskgraph.JobStatus{State: taskgraph.JobFailed, Reason: value}
+	}
+	return status
 }
@@ -3,21 +3,80 @@ package etcdutil
 import (
 	"log"
 
-	"github.com/coreos/go-etcd/etcd"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
 )
 
-func ListKeys(nodes []*etcd.Node) []string {
-	res := make([]string, len(nodes))
-	for i, n := range nodes {
-		res[i] = n.Key
+func ListKeys(kvs []*mvccpb.KeyValue) []string {
+	res := make([]string, len(kvs))
+	for i, kv := range kvs {
+		res[i] = string(kv.Key)
 	}
 	return res
 }
 
-func MustCreate(c *etcd.Client, logger *log.Logger, key, value string, ttl uint64) *etcd.Response {
-	resp, err := c.Create(key, value, ttl)
+// Create creates the key, which shouldn't exist before. It returns the revision
+// of creation so that caller can watch changes after it.
+func Create(c *clientv3.Client, key, value string) (int64, error) {
+	resp, err := c.Txn(context.Background()).
+		If(notExist(key)).
+		Then(clientv3.OpPut(key, value)).
+		Commit()
+	if err != nil {
+		return 0, err
+	}
+	if !resp.Succeeded {
+		return 0, ErrKeyExists
+	}
+	return resp.Header.Revision, nil
+}
+
+func MustCreate(c *clientv3.Client, logger *log.Logger, key, value string) int64 {
+	rev, err := Create(c, key, value)
 	if err != nil {
 		logger.Panicf("Create failed. Key: %s, err: %v", key, err)
 	}
-	return resp
+	return rev
+}
+
+// GetValue returns value of the key and the revision of etcd at the time.
+func GetValue(c *clientv3.Client, key string) (string, int64, error) {
+	resp, err := c.Get(context.Background(), key)
+	if err != nil {
+		return "", 0, err
+	}
+	if len(resp.Kvs) == 0 {
+		return "", 0, ErrKeyNotFound
+	}
+	return string(resp.Kvs[0].Value), resp.Header.Revision, nil
+}
+
+// getDir returns keys under the directory and the revision of etcd at the time.
+func getDir(c *clientv3.Client, dir string) ([]*mvccpb.KeyValue, int64, error) {
+	resp, err := c.Get(context.Background(), dirPrefix(dir), clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Kvs, resp.Header.Revision, nil
+}
+
+// etcd v3 has flat key space. Keys under a directory are gotten or watched by
+// prefix. The trailing slash keeps /{job}/tasks from matching /{job}/tasks2.
+func dirPrefix(dir string) string {
+	return dir + "/"
+}
+
+func notExist(key string) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+}
+
+// watch watches changes of key since rev until stop.
+func watch(c *clientv3.Client, key string, rev int64, stop chan bool, opts ...clientv3.OpOption) clientv3.WatchChan {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stop
+		cancel()
+	}()
+	return c.Watch(ctx, key, append(opts, clientv3.WithRev(rev))...)
 }
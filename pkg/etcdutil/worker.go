@@ -0,0 +1,58 @@
+package etcdutil
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// WatchWorkers returns the workers already registered, and watches for later
+// registration under /{job}/worker. The handler is called with worker ID for each
+// later registration. A worker that takes over a failed one registers again with
+// its own address. Address of a dead worker is there until its lease expires, so
+// only workers whose healthy key is present are returned.
+func WatchWorkers(client *clientv3.Client, job string, stop chan bool, handler func(workerID uint64)) ([]uint64, error) {
+	resp, err := client.Txn(context.Background()).Then(
+		clientv3.OpGet(dirPrefix(WorkerDirPath(job)), clientv3.WithPrefix()),
+		clientv3.OpGet(dirPrefix(WorkerHealthyDir(job)), clientv3.WithPrefix()),
+	).Commit()
+	if err != nil {
+		return nil, err
+	}
+	healthy := make(map[string]bool)
+	for _, kv := range resp.Responses[1].GetResponseRange().Kvs {
+		healthy[path.Base(string(kv.Key))] = true
+	}
+	var workers []uint64
+	for _, kv := range resp.Responses[0].GetResponseRange().Kvs {
+		idStr := path.Base(string(kv.Key))
+		if !healthy[idStr] {
+			continue
+		}
+		id, err := strconv.ParseUint(idStr, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		workers = append(workers, id)
+	}
+	rev := resp.Header.Revision
+	wch := watch(client, dirPrefix(WorkerDirPath(job)), rev+1, stop, clientv3.WithPrefix())
+	go func() {
+		for resp := range wch {
+			for _, ev := range resp.Events {
+				// Address of failed worker is deleted along with its lease.
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				id, err := strconv.ParseUint(path.Base(string(ev.Kv.Key)), 10, 64)
+				if err != nil {
+					continue
+				}
+				handler(id)
+			}
+		}
+	}()
+	return workers, nil
+}
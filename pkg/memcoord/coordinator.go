@@ -0,0 +1,551 @@
+package memcoord
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+var _ taskgraph.Coordinator = (*Coordinator)(nil)
+
+// Coordinator implements taskgraph.Coordinator on a Store. It is meant for tests
+// and for running a whole job in one process.
+type Coordinator struct {
+	store    *Store
+	lease    int64
+	interval time.Duration
+}
+
+func (c *Coordinator) StartSession(heartbeatInterval time.Duration, ttlMultiplier uint64) error {
+	c.interval = heartbeatInterval
+	c.lease = c.store.grant(heartbeatInterval * time.Duration(ttlMultiplier))
+	return nil
+}
+
+func (c *Coordinator) Heartbeat(stop chan struct{}) error {
+	for {
+		if err := c.store.keepAlive(c.lease); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(c.interval):
+		case <-stop:
+			return nil
+		}
+	}
+}
+
+func (c *Coordinator) GetAndWatchEpoch(job string, epochC chan uint64, stop chan bool) (uint64, error) {
+	value, ok := c.store.getAndWatchKey(etcdutil.EpochPath(job), stop, func(ev event) {
+		if ev.deleted {
+			return
+		}
+		epoch, err := strconv.ParseUint(ev.value, 10, 64)
+		if err != nil {
+			log.Printf("memcoord: can't parse epoch: %v", err)
+			return
+		}
+		epochC <- epoch
+	})
+	if !ok {
+		return 0, etcdutil.ErrKeyNotFound
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func (c *Coordinator) GetEpoch(job string) (uint64, error) {
+	value, ok := c.store.get(etcdutil.EpochPath(job))
+	if !ok {
+		return 0, etcdutil.ErrKeyNotFound
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func (c *Coordinator) CASEpoch(job string, prevEpoch, epoch uint64) error {
+	prev := strconv.FormatUint(prevEpoch, 10)
+	if !c.store.cas(etcdutil.EpochPath(job), prev, strconv.FormatUint(epoch, 10)) {
+		return etcdutil.ErrCompareFailed
+	}
+	return nil
+}
+
+func (c *Coordinator) ReportEpochDone(job string, epoch, taskID uint64) error {
+	key := etcdutil.EpochPath(job)
+	current := strconv.FormatUint(epoch, 10)
+	if !c.store.putIf(key, current, map[string]string{etcdutil.EpochDonePath(job, epoch, taskID): ""}) {
+		return etcdutil.ErrCompareFailed
+	}
+	dir := dirPrefix(etcdutil.EpochDoneDir(job, epoch))
+	size, err := c.GetJobSize(job, epoch)
+	if err != nil {
+		return err
+	}
+	quorum, _ := c.store.get(etcdutil.ConfigPath(job, etcdutil.EpochQuorum))
+	if uint64(len(c.store.getPrefix(dir))) < etcdutil.QuorumOf(size, quorum) {
+		return nil
+	}
+	if c.store.putIf(key, current, map[string]string{key: strconv.FormatUint(epoch+1, 10)}) {
+		c.store.deletePrefix(dir)
+	}
+	return nil
+}
+
+func (c *Coordinator) ReportClock(job string, taskID, clock uint64) error {
+	c.store.put(etcdutil.ClockPath(job, taskID), strconv.FormatUint(clock, 10))
+	key := etcdutil.EpochPath(job)
+	for {
+		value, ok := c.store.get(key)
+		if !ok {
+			return etcdutil.ErrKeyNotFound
+		}
+		epoch, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if epoch == taskgraph.ExitEpoch {
+			return nil
+		}
+		size, err := c.GetJobSize(job, epoch)
+		if err != nil {
+			return err
+		}
+		clocks := c.store.getPrefix(dirPrefix(etcdutil.ClocksDirPath(job)))
+		slowest := etcdutil.SlowestClock(clocks, job, epoch, size)
+		if slowest <= epoch || c.store.cas(key, value, strconv.FormatUint(slowest, 10)) {
+			return nil
+		}
+	}
+}
+
+func (c *Coordinator) SetEpoch(job string, epoch uint64) error {
+	c.store.put(etcdutil.EpochPath(job), strconv.FormatUint(epoch, 10))
+	return nil
+}
+
+func (c *Coordinator) WaitFreeTask(job string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return c.waitFree(etcdutil.FreeTaskDir(job), stop, logger)
+}
+
+func (c *Coordinator) waitFree(dir string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	watchStop := make(chan bool)
+	defer close(watchStop)
+	freeC := make(chan string, 1)
+	kvs, _ := c.store.getAndWatch(dirPrefix(dir), true, watchStop, func(ev event) {
+		if ev.deleted {
+			return
+		}
+		select {
+		case freeC <- ev.key:
+		default:
+		}
+	})
+	if len(kvs) > 0 {
+		keys := make([]string, 0, len(kvs))
+		for k := range kvs {
+			keys = append(keys, k)
+		}
+		ri := rand.Intn(len(keys))
+		logger.Printf("got free task %v, randomly choose %d to try...", keys, ri)
+		return strconv.ParseUint(path.Base(keys[ri]), 10, 64)
+	}
+	select {
+	case key := <-freeC:
+		return strconv.ParseUint(path.Base(key), 10, 64)
+	case <-stop:
+		return 0, etcdutil.ErrStopped
+	}
+}
+
+func (c *Coordinator) TryOccupyTask(job string, taskID uint64, addr string) (bool, error) {
+	healthy := etcdutil.TaskHealthyPath(job, taskID)
+	kvs := map[string]string{
+		healthy:                              "health",
+		etcdutil.TaskMasterPath(job, taskID): addr,
+	}
+	free := etcdutil.FreeTaskPath(job, strconv.FormatUint(taskID, 10))
+	return c.store.createWithLease(c.lease, healthy, kvs, []string{free})
+}
+
+func (c *Coordinator) TryOccupyBackup(job string, taskID, replicaID uint64, addr string) (bool, error) {
+	key := etcdutil.TaskReplicaPath(job, taskID, replicaID)
+	return c.store.createWithLease(c.lease, key, map[string]string{key: addr}, nil)
+}
+
+func (c *Coordinator) ReleaseBackup(job string, taskID, replicaID uint64) error {
+	c.store.delete(etcdutil.TaskReplicaPath(job, taskID, replicaID))
+	return nil
+}
+
+func (c *Coordinator) DetectFailure(job string, stop chan bool) error {
+	done := c.store.watch(dirPrefix(etcdutil.HealthyPath(job)), true, stop, func(ev event) {
+		if ev.deleted && !c.retired(job, path.Base(ev.key)) {
+			// unless someone has taken over, e.g. a backup.
+			c.store.putIfAbsent(ev.key, etcdutil.FreeTaskPath(job, path.Base(ev.key)), "failed")
+		}
+	})
+	<-done
+	return nil
+}
+
+func (c *Coordinator) ReportFailure(job string, taskID uint64) error {
+	c.store.put(etcdutil.FreeTaskPath(job, strconv.FormatUint(taskID, 10)), "failed")
+	return nil
+}
+
+func (c *Coordinator) RegisterMaster(job, addr string) error {
+	c.store.put(etcdutil.MasterPath(job), addr)
+	return nil
+}
+
+func (c *Coordinator) GetMasterAddress(job string) (string, error) {
+	return c.address(etcdutil.MasterPath(job))
+}
+
+func (c *Coordinator) TryOccupyWorker(job string, workerID uint64, addr string) (bool, error) {
+	healthy := etcdutil.WorkerHealthyPath(job, workerID)
+	kvs := map[string]string{
+		healthy:                            "health",
+		etcdutil.WorkerPath(job, workerID): addr,
+	}
+	free := etcdutil.FreeWorkerPath(job, strconv.FormatUint(workerID, 10))
+	return c.store.createWithLease(c.lease, healthy, kvs, []string{free})
+}
+
+func (c *Coordinator) GetWorkerAddress(job string, workerID uint64) (string, error) {
+	return c.address(etcdutil.WorkerPath(job, workerID))
+}
+
+func (c *Coordinator) WaitFreeWorker(job string, stop chan struct{}, logger *log.Logger) (uint64, error) {
+	return c.waitFree(etcdutil.FreeWorkerDir(job), stop, logger)
+}
+
+func (c *Coordinator) WatchWorkers(job string, stop chan bool, handler func(workerID uint64)) ([]uint64, error) {
+	kvs, _ := c.store.getAndWatch(dirPrefix(etcdutil.WorkerDirPath(job)), true, stop, func(ev event) {
+		// Address of failed worker is deleted along with its session.
+		if ev.deleted {
+			return
+		}
+		id, err := strconv.ParseUint(path.Base(ev.key), 10, 64)
+		if err != nil {
+			return
+		}
+		handler(id)
+	})
+	healthy := c.store.getPrefix(dirPrefix(etcdutil.WorkerHealthyDir(job)))
+	var workers []uint64
+	for key := range kvs {
+		id, err := strconv.ParseUint(path.Base(key), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := healthy[etcdutil.WorkerHealthyPath(job, id)]; ok {
+			workers = append(workers, id)
+		}
+	}
+	return workers, nil
+}
+
+func (c *Coordinator) DetectWorkerFailure(job string, stop chan bool, handler func(workerID uint64)) error {
+	done := c.store.watch(dirPrefix(etcdutil.WorkerHealthyDir(job)), true, stop, func(ev event) {
+		if !ev.deleted {
+			return
+		}
+		id, err := strconv.ParseUint(path.Base(ev.key), 10, 64)
+		if err != nil {
+			return
+		}
+		c.ReportWorkerFailure(job, id)
+		handler(id)
+	})
+	<-done
+	return nil
+}
+
+func (c *Coordinator) ReportWorkerFailure(job string, workerID uint64) error {
+	c.store.put(etcdutil.FreeWorkerPath(job, strconv.FormatUint(workerID, 10)), "failed")
+	return nil
+}
+
+func (c *Coordinator) SetMeta(job, linkType string, taskID, epoch uint64, meta string) error {
+	jobEpoch, err := c.GetEpoch(job)
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for key := range c.store.getPrefix(dirPrefix(etcdutil.MetaDirPath(linkType, job, taskID))) {
+		keys = append(keys, key)
+	}
+	c.store.put(etcdutil.MetaPath(linkType, job, taskID, epoch), meta)
+	for _, key := range etcdutil.StaleMetas(keys, jobEpoch) {
+		c.store.delete(key)
+	}
+	return nil
+}
+
+func (c *Coordinator) WatchMeta(job, linkType string, taskID, epoch uint64, stop chan bool, handler func(taskID uint64, meta string)) error {
+	if _, ok := c.store.get(etcdutil.EpochPath(job)); !ok {
+		return etcdutil.ErrKeyNotFound
+	}
+	key := etcdutil.MetaPath(linkType, job, taskID, epoch)
+	kvs, _ := c.store.getAndWatch(key, false, stop, func(ev event) {
+		if !ev.deleted {
+			handler(taskID, ev.value)
+		}
+	})
+	// Get previous meta. We need to handle it.
+	if meta, ok := kvs[key]; ok {
+		go handler(taskID, meta)
+	}
+	return nil
+}
+
+func (c *Coordinator) GetAddress(job string, taskID uint64) (string, error) {
+	return c.address(etcdutil.TaskMasterPath(job, taskID))
+}
+
+func (c *Coordinator) address(key string) (string, error) {
+	addr, ok := c.store.get(key)
+	if !ok {
+		return "", etcdutil.ErrKeyNotFound
+	}
+	return addr, nil
+}
+
+func (c *Coordinator) WatchTaskAddress(job string, stop chan bool, handler func(taskID uint64, addr string)) error {
+	c.store.watch(dirPrefix(etcdutil.TaskDirPath(job)), true, stop, func(ev event) {
+		// Meta keys are also under the task directory.
+		if path.Base(ev.key) != etcdutil.TaskMaster {
+			return
+		}
+		id, err := strconv.ParseUint(path.Base(path.Dir(ev.key)), 10, 64)
+		if err != nil {
+			return
+		}
+		handler(id, ev.value)
+	})
+	return nil
+}
+
+func (c *Coordinator) InitJob(job string, numOfTasks uint64) error {
+	keys := []string{etcdutil.EpochPath(job), etcdutil.JobStatusPath(job), etcdutil.JobSizePath(job, 0)}
+	values := []string{"0", "", strconv.FormatUint(numOfTasks, 10)}
+	for i := uint64(0); i < numOfTasks; i++ {
+		keys = append(keys, etcdutil.FreeTaskPath(job, strconv.FormatUint(i, 10)))
+		values = append(values, "")
+	}
+	for i, k := range keys {
+		if !c.store.create(k, values[i]) {
+			return fmt.Errorf("memcoord: %s already exists", k)
+		}
+	}
+	return c.TouchJob(job)
+}
+
+func (c *Coordinator) ResumeJob(job string, numOfTasks uint64, linkTypes []string) (uint64, error) {
+	value, ok := c.store.get(etcdutil.EpochPath(job))
+	if !ok {
+		return 0, etcdutil.ErrKeyNotFound
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := etcdutil.NewTasksFrom(c.jobSizes(job), job, epoch); err == nil {
+		numOfTasks = n
+	}
+	c.TouchJob(job)
+	c.store.deletePrefix(dirPrefix(etcdutil.HealthyPath(job)))
+	committed, _ := c.store.get(etcdutil.CheckpointPath(job))
+	for _, key := range etcdutil.UncommittedCheckpoints(c.checkpointReports(job), job, committed) {
+		c.store.delete(key)
+	}
+	c.store.deletePrefix(dirPrefix(etcdutil.DoneDirPath(job)))
+	c.store.deletePrefix(dirPrefix(etcdutil.ClocksDirPath(job)))
+	for i := uint64(0); i < numOfTasks; i++ {
+		c.store.delete(etcdutil.TaskMasterPath(job, i))
+		c.store.put(etcdutil.FreeTaskPath(job, strconv.FormatUint(i, 10)), "")
+		for _, linkType := range linkTypes {
+			c.store.deletePrefix(dirPrefix(etcdutil.MetaDirPath(linkType, job, i)))
+		}
+	}
+	return epoch, nil
+}
+
+func (c *Coordinator) ReportCheckpoint(job string, epoch uint64, checkpoint taskgraph.TaskCheckpoint) error {
+	b, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	c.store.put(etcdutil.CheckpointTaskPath(job, epoch, checkpoint.TaskID), string(b))
+	return nil
+}
+
+func (c *Coordinator) GetCheckpoint(job string, epoch uint64) (taskgraph.CheckpointManifest, error) {
+	return etcdutil.ParseCheckpoint(c.checkpointReports(job), job, epoch)
+}
+
+func (c *Coordinator) CommitCheckpoint(job string, epoch uint64) error {
+	key := etcdutil.CheckpointPath(job)
+	value := strconv.FormatUint(epoch, 10)
+	for {
+		prev, ok := c.store.get(key)
+		if ok {
+			committed, err := strconv.ParseUint(prev, 10, 64)
+			if err != nil {
+				return err
+			}
+			if committed >= epoch {
+				return etcdutil.ErrCompareFailed
+			}
+			if !c.store.cas(key, prev, value) {
+				continue
+			}
+		} else if !c.store.create(key, value) {
+			continue
+		}
+		break
+	}
+	for key, e := range etcdutil.CheckpointReportEpochs(c.checkpointReports(job), job) {
+		if e < epoch {
+			c.store.delete(key)
+		}
+	}
+	return nil
+}
+
+func (c *Coordinator) LatestCheckpoint(job string) (taskgraph.CheckpointManifest, error) {
+	value, ok := c.store.get(etcdutil.CheckpointPath(job))
+	if !ok {
+		return taskgraph.CheckpointManifest{}, etcdutil.ErrKeyNotFound
+	}
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return taskgraph.CheckpointManifest{}, err
+	}
+	return c.GetCheckpoint(job, epoch)
+}
+
+func (c *Coordinator) checkpointReports(job string) map[string]string {
+	return c.store.getPrefix(dirPrefix(etcdutil.CheckpointDir(job)))
+}
+
+func (c *Coordinator) SetConfig(job, key, value string) error {
+	c.store.put(etcdutil.ConfigPath(job, key), value)
+	return nil
+}
+
+func (c *Coordinator) GetConfig(job, key string) (string, error) {
+	value, ok := c.store.get(etcdutil.ConfigPath(job, key))
+	if !ok {
+		return "", etcdutil.ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (c *Coordinator) ResizeJob(job string, epoch, numOfTasks uint64) error {
+	from, err := etcdutil.NewTasksFrom(c.jobSizes(job), job, epoch)
+	if err != nil {
+		return err
+	}
+	kvs := map[string]string{etcdutil.JobSizePath(job, epoch+1): strconv.FormatUint(numOfTasks, 10)}
+	for i := from; i < numOfTasks; i++ {
+		kvs[etcdutil.FreeTaskPath(job, strconv.FormatUint(i, 10))] = ""
+	}
+	if !c.store.putIf(etcdutil.EpochPath(job), strconv.FormatUint(epoch, 10), kvs) {
+		return etcdutil.ErrCompareFailed
+	}
+	return nil
+}
+
+func (c *Coordinator) GetJobSize(job string, epoch uint64) (uint64, error) {
+	return etcdutil.JobSizeAt(c.jobSizes(job), job, epoch)
+}
+
+func (c *Coordinator) GetAndWatchJobSizes(job string, stop chan bool, handler func(key, value string)) (map[string]string, error) {
+	kvs, _ := c.store.getAndWatch(dirPrefix(etcdutil.JobSizeDir(job)), true, stop, func(ev event) {
+		if !ev.deleted {
+			handler(ev.key, ev.value)
+		}
+	})
+	return kvs, nil
+}
+
+func (c *Coordinator) jobSizes(job string) map[string]string {
+	return c.store.getPrefix(dirPrefix(etcdutil.JobSizeDir(job)))
+}
+
+// retired tells whether the task whose session expired has been retired by resize.
+func (c *Coordinator) retired(job, idStr string) bool {
+	taskID, err := strconv.ParseUint(idStr, 10, 64)
+	if err != nil {
+		return false
+	}
+	value, _ := c.store.get(etcdutil.EpochPath(job))
+	epoch, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return false
+	}
+	return etcdutil.IsRetired(c.jobSizes(job), job, epoch, taskID)
+}
+
+func (c *Coordinator) DestroyJob(job string) error {
+	c.store.deletePrefix(dirPrefix(path.Join("/", job)))
+	return nil
+}
+
+func (c *Coordinator) TouchJob(job string) error {
+	c.store.put(etcdutil.JobHeartbeatPath(job), time.Now().Format(time.RFC3339Nano))
+	return nil
+}
+
+func (c *Coordinator) ListJobs() ([]taskgraph.JobInfo, error) {
+	return etcdutil.ParseJobs(c.store.getPrefix("/")), nil
+}
+
+func (c *Coordinator) DescribeJob(job string) (taskgraph.JobDetail, error) {
+	return etcdutil.ParseJobDetail(job, c.store.getPrefix(dirPrefix(path.Join("/", job))))
+}
+
+func (c *Coordinator) SetJobStatus(job string, status taskgraph.JobStatus) error {
+	if !c.store.cas(etcdutil.JobStatusPath(job), "", status.String()) {
+		return etcdutil.ErrCompareFailed
+	}
+	return c.TouchJob(job)
+}
+
+func (c *Coordinator) WatchJobStatus(job string, stop chan bool, handler func(status taskgraph.JobStatus)) error {
+	key := etcdutil.JobStatusPath(job)
+	var once sync.Once
+	value, ok := c.store.getAndWatchKey(key, stop, func(ev event) {
+		if ev.deleted {
+			return
+		}
+		if status := etcdutil.DecodeJobStatus(ev.value); status.Done() {
+			once.Do(func() { handler(status) })
+		}
+	})
+	if !ok {
+		return etcdutil.ErrKeyNotFound
+	}
+	if status := etcdutil.DecodeJobStatus(value); status.Done() {
+		go once.Do(func() { handler(status) })
+	}
+	return nil
+}
+
+// Close does nothing. The session expires if it isn't kept alive.
+func (c *Coordinator) Close() error {
+	return nil
+}
+
+func dirPrefix(dir string) string {
+	return dir + "/"
+}
@@ -0,0 +1,401 @@
+package memcoord
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/pkg/etcdutil"
+)
+
+func TestEpoch(t *testing.T) {
+	job := "TestEpoch"
+	c := NewStore().NewCoordinator()
+	if err := c.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	epoch, err := c.GetAndWatchEpoch(job, epochC, stop)
+	if err != nil {
+		t.Fatalf("GetAndWatchEpoch failed: %v", err)
+	}
+	if epoch != 0 {
+		t.Errorf("epoch want = 0, get = %d", epoch)
+	}
+
+	if err := c.CASEpoch(job, 1, 2); err != etcdutil.ErrCompareFailed {
+		t.Errorf("CASEpoch with wrong epoch, error want = %v, get = %v", etcdutil.ErrCompareFailed, err)
+	}
+	if err := c.CASEpoch(job, 0, 1); err != nil {
+		t.Fatalf("CASEpoch failed: %v", err)
+	}
+	if epoch := <-epochC; epoch != 1 {
+		t.Errorf("epoch want = 1, get = %d", epoch)
+	}
+}
+
+func TestEpochDone(t *testing.T) {
+	job := "TestEpochDone"
+	c := NewStore().NewCoordinator()
+	if err := c.InitJob(job, 3); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	epoch := func() uint64 {
+		detail, err := c.DescribeJob(job)
+		if err != nil {
+			t.Fatalf("DescribeJob failed: %v", err)
+		}
+		return detail.Epoch
+	}
+
+	// task 1 reports twice, e.g. it's taken over.
+	for _, taskID := range []uint64{0, 1, 1} {
+		if err := c.ReportEpochDone(job, 0, taskID); err != nil {
+			t.Fatalf("ReportEpochDone failed: %v", err)
+		}
+	}
+	if e := epoch(); e != 0 {
+		t.Errorf("epoch before all tasks are done want = 0, get = %d", e)
+	}
+	if err := c.ReportEpochDone(job, 0, 2); err != nil {
+		t.Fatalf("ReportEpochDone failed: %v", err)
+	}
+	if e := epoch(); e != 1 {
+		t.Errorf("epoch after all tasks are done want = 1, get = %d", e)
+	}
+	if err := c.ReportEpochDone(job, 0, 2); err != etcdutil.ErrCompareFailed {
+		t.Errorf("ReportEpochDone of past epoch, error want = %v, get = %v", etcdutil.ErrCompareFailed, err)
+	}
+
+	// a quorum of two moves the job on without the third.
+	if err := c.SetConfig(job, etcdutil.EpochQuorum, "2"); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	for _, taskID := range []uint64{2, 0} {
+		if err := c.ReportEpochDone(job, 1, taskID); err != nil {
+			t.Fatalf("ReportEpochDone failed: %v", err)
+		}
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch after a quorum is done want = 2, get = %d", e)
+	}
+}
+
+func TestReportClock(t *testing.T) {
+	job := "TestReportClock"
+	c := NewStore().NewCoordinator()
+	if err := c.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	epoch := func() uint64 {
+		detail, err := c.DescribeJob(job)
+		if err != nil {
+			t.Fatalf("DescribeJob failed: %v", err)
+		}
+		return detail.Epoch
+	}
+
+	// task 1 hasn't reported, which is at the job's epoch.
+	if err := c.ReportClock(job, 0, 3); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 0 {
+		t.Errorf("epoch want = 0, get = %d", e)
+	}
+	if err := c.ReportClock(job, 1, 2); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch want = slowest clock 2, get = %d", e)
+	}
+	// a task taken over goes back, but the job doesn't.
+	if err := c.ReportClock(job, 1, 1); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 2 {
+		t.Errorf("epoch want = 2, get = %d", e)
+	}
+	if err := c.ReportClock(job, 1, 5); err != nil {
+		t.Fatalf("ReportClock failed: %v", err)
+	}
+	if e := epoch(); e != 3 {
+		t.Errorf("epoch want = slowest clock 3, get = %d", e)
+	}
+}
+
+func TestOccupyAndDetectFailure(t *testing.T) {
+	job := "TestOccupyAndDetectFailure"
+	store := NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	detectStop := make(chan bool)
+	defer close(detectStop)
+	go ctl.DetectFailure(job, detectStop)
+
+	logger := log.New(os.Stdout, "", log.Lshortfile|log.Ltime|log.Ldate)
+	c0 := store.NewCoordinator()
+	c0.StartSession(10*time.Millisecond, 3)
+	heartbeatStop := make(chan struct{})
+	go c0.Heartbeat(heartbeatStop)
+
+	id, err := c0.WaitFreeTask(job, nil, logger)
+	if err != nil {
+		t.Fatalf("WaitFreeTask failed: %v", err)
+	}
+	if ok, err := c0.TryOccupyTask(job, id, "addr0"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+	if addr, err := c0.GetAddress(job, id); addr != "addr0" || err != nil {
+		t.Errorf("GetAddress want = (addr0, nil), get = (%s, %v)", addr, err)
+	}
+
+	c1 := store.NewCoordinator()
+	c1.StartSession(10*time.Millisecond, 3)
+	if ok, err := c1.TryOccupyTask(job, id, "addr1"); ok || err != nil {
+		t.Fatalf("TryOccupyTask on occupied task want = (false, nil), get = (%v, %v)", ok, err)
+	}
+
+	// c0 stops heartbeat. Its task should be freed and taken over by c1.
+	close(heartbeatStop)
+	go c1.Heartbeat(make(chan struct{}))
+	id1, err := c1.WaitFreeTask(job, nil, logger)
+	if err != nil {
+		t.Fatalf("WaitFreeTask failed: %v", err)
+	}
+	if id1 != id {
+		t.Errorf("free task want = %d, get = %d", id, id1)
+	}
+	if ok, err := c1.TryOccupyTask(job, id, "addr1"); !ok || err != nil {
+		t.Fatalf("TryOccupyTask want = (true, nil), get = (%v, %v)", ok, err)
+	}
+}
+
+func TestMetaAndJobStatus(t *testing.T) {
+	job := "TestMetaAndJobStatus"
+	c := NewStore().NewCoordinator()
+	if err := c.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+
+	c.SetMeta(job, "Parents", 1, 0, "before")
+	metaC := make(chan string, 2)
+	stop := make(chan bool)
+	defer close(stop)
+	err := c.WatchMeta(job, "Parents", 1, 0, stop, func(taskID uint64, meta string) {
+		metaC <- meta
+	})
+	if err != nil {
+		t.Fatalf("WatchMeta failed: %v", err)
+	}
+	// meta set before watch should also be handled.
+	if meta := <-metaC; meta != "before" {
+		t.Errorf("meta want = before, get = %s", meta)
+	}
+	// a meta of a later epoch doesn't replace the one of epoch 0.
+	c.SetMeta(job, "Parents", 1, 1, "later")
+	c.SetMeta(job, "Parents", 1, 0, "after")
+	if meta := <-metaC; meta != "after" {
+		t.Errorf("meta want = after, get = %s", meta)
+	}
+	// the one of epoch 0 is dropped once the job has moved past it.
+	c.CASEpoch(job, 0, 2)
+	c.SetMeta(job, "Parents", 1, 2, "next")
+	metas := c.store.getPrefix(dirPrefix(etcdutil.MetaDirPath("Parents", job, 1)))
+	if _, ok := metas[etcdutil.MetaPath("Parents", job, 1, 0)]; ok || len(metas) != 1 {
+		t.Errorf("metas want only the one of epoch 2, get = %v", metas)
+	}
+
+	statusC := make(chan taskgraph.JobStatus, 1)
+	if err := c.WatchJobStatus(job, stop, func(status taskgraph.JobStatus) { statusC <- status }); err != nil {
+		t.Fatalf("WatchJobStatus failed: %v", err)
+	}
+	failed := taskgraph.JobStatus{State: taskgraph.JobFailed, Reason: "diverged"}
+	c.SetJobStatus(job, failed)
+	if status := <-statusC; status != failed {
+		t.Errorf("job status want = %v, get = %v", failed, status)
+	}
+
+	c.DestroyJob(job)
+	if _, err := c.GetAddress(job, 0); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("GetAddress after DestroyJob, error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+}
+
+// Watching a key that doesn't exist should fail without leaving a watcher.
+func TestWatchMissingKey(t *testing.T) {
+	job := "TestWatchMissingKey"
+	store := NewStore()
+	c := store.NewCoordinator()
+	stop := make(chan bool)
+	defer close(stop)
+
+	if _, err := c.GetAndWatchEpoch(job, make(chan uint64, 1), stop); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("GetAndWatchEpoch error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	if err := c.WatchMeta(job, "Parents", 0, 0, stop, func(uint64, string) {}); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("WatchMeta error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	if err := c.WatchJobStatus(job, stop, func(taskgraph.JobStatus) {}); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("WatchJobStatus error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if n := len(store.watchers); n != 0 {
+		t.Errorf("watchers want = 0, get = %d", n)
+	}
+}
+
+func TestResizeJob(t *testing.T) {
+	job := "TestResizeJob"
+	c := NewStore().NewCoordinator()
+	if err := c.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	if err := c.ResizeJob(job, 1, 3); err != etcdutil.ErrCompareFailed {
+		t.Errorf("ResizeJob at wrong epoch, error want = %v, get = %v", etcdutil.ErrCompareFailed, err)
+	}
+	stop := make(chan bool)
+	defer close(stop)
+	watched := make(chan string, 1)
+	kvs, err := c.GetAndWatchJobSizes(job, stop, func(key, value string) { watched <- key + "=" + value })
+	if err != nil || len(kvs) != 1 || kvs[etcdutil.JobSizePath(job, 0)] != "2" {
+		t.Fatalf("GetAndWatchJobSizes want = (size 2 at epoch 0, nil), get = (%v, %v)", kvs, err)
+	}
+	if err := c.ResizeJob(job, 0, 3); err != nil {
+		t.Fatalf("ResizeJob failed: %v", err)
+	}
+	if get, want := <-watched, etcdutil.JobSizePath(job, 1)+"=3"; get != want {
+		t.Errorf("watched size want = %s, get = %s", want, get)
+	}
+	tests := []struct {
+		epoch, size uint64
+	}{
+		{0, 2},
+		{1, 3},
+		{taskgraph.ExitEpoch, 3},
+	}
+	for i, tt := range tests {
+		if size, err := c.GetJobSize(job, tt.epoch); size != tt.size || err != nil {
+			t.Errorf("#%d: GetJobSize want = (%d, nil), get = (%d, %v)", i, tt.size, size, err)
+		}
+	}
+	detail, err := c.DescribeJob(job)
+	if err != nil {
+		t.Fatalf("DescribeJob failed: %v", err)
+	}
+	// the new task is free before it joins.
+	if len(detail.Tasks) != 3 || !detail.Tasks[2].Free {
+		t.Errorf("tasks want 3 with the last free, get = %+v", detail.Tasks)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	job := "TestBackup"
+	store := NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 1); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	if _, err := ctl.GetConfig(job, etcdutil.Backups); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("GetConfig before set, error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	if err := ctl.SetConfig(job, etcdutil.Backups, "1"); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	if value, err := ctl.GetConfig(job, etcdutil.Backups); value != "1" || err != nil {
+		t.Errorf("GetConfig want = (1, nil), get = (%s, %v)", value, err)
+	}
+
+	c0 := store.NewCoordinator()
+	c0.StartSession(time.Second, 3)
+	if ok, err := c0.TryOccupyBackup(job, 0, 1, "addr0"); !ok || err != nil {
+		t.Fatalf("TryOccupyBackup want = (true, nil), get = (%v, %v)", ok, err)
+	}
+	c1 := store.NewCoordinator()
+	c1.StartSession(time.Second, 3)
+	if ok, err := c1.TryOccupyBackup(job, 0, 1, "addr1"); ok || err != nil {
+		t.Fatalf("TryOccupyBackup on occupied replica want = (false, nil), get = (%v, %v)", ok, err)
+	}
+	detail, err := ctl.DescribeJob(job)
+	if err != nil {
+		t.Fatalf("DescribeJob failed: %v", err)
+	}
+	if backups := detail.Tasks[0].Backups; len(backups) != 1 || backups[1] != "addr0" {
+		t.Errorf("backups want = map[1:addr0], get = %v", backups)
+	}
+
+	if err := c0.ReleaseBackup(job, 0, 1); err != nil {
+		t.Fatalf("ReleaseBackup failed: %v", err)
+	}
+	if ok, err := c1.TryOccupyBackup(job, 0, 1, "addr1"); !ok || err != nil {
+		t.Fatalf("TryOccupyBackup on released replica want = (true, nil), get = (%v, %v)", ok, err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	job := "TestCheckpoint"
+	store := NewStore()
+	ctl := store.NewCoordinator()
+	if err := ctl.InitJob(job, 2); err != nil {
+		t.Fatalf("InitJob failed: %v", err)
+	}
+	if _, err := ctl.LatestCheckpoint(job); err != etcdutil.ErrKeyNotFound {
+		t.Errorf("LatestCheckpoint before commit, error want = %v, get = %v", etcdutil.ErrKeyNotFound, err)
+	}
+	for _, epoch := range []uint64{2, 4} {
+		for _, taskID := range []uint64{1, 0} {
+			cp := taskgraph.TaskCheckpoint{TaskID: taskID, Path: fmt.Sprintf("%d-%d", taskID, epoch), Checksum: "0"}
+			if err := ctl.ReportCheckpoint(job, epoch, cp); err != nil {
+				t.Fatalf("ReportCheckpoint failed: %v", err)
+			}
+		}
+	}
+	manifest, err := ctl.GetCheckpoint(job, 2)
+	if err != nil {
+		t.Fatalf("GetCheckpoint failed: %v", err)
+	}
+	want := taskgraph.CheckpointManifest{Epoch: 2, Tasks: []taskgraph.TaskCheckpoint{
+		{TaskID: 0, Path: "0-2", Checksum: "0"},
+		{TaskID: 1, Path: "1-2", Checksum: "0"},
+	}}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("GetCheckpoint want = %+v, get = %+v", want, manifest)
+	}
+
+	if err := ctl.CommitCheckpoint(job, 4); err != nil {
+		t.Fatalf("CommitCheckpoint failed: %v", err)
+	}
+	if err := ctl.CommitCheckpoint(job, 2); err != etcdutil.ErrCompareFailed {
+		t.Errorf("CommitCheckpoint of earlier epoch, error want = %v, get = %v", etcdutil.ErrCompareFailed, err)
+	}
+	if manifest, err := ctl.LatestCheckpoint(job); err != nil || manifest.Epoch != 4 || len(manifest.Tasks) != 2 {
+		t.Errorf("LatestCheckpoint want epoch 4 of 2 tasks, get = (%+v, %v)", manifest, err)
+	}
+	// reports of earlier epochs are dropped on commit.
+	if manifest, _ := ctl.GetCheckpoint(job, 2); len(manifest.Tasks) != 0 {
+		t.Errorf("reports of epoch 2 want none, get = %+v", manifest.Tasks)
+	}
+
+	// reports not committed are dropped on resume.
+	if err := ctl.ReportCheckpoint(job, 6, taskgraph.TaskCheckpoint{TaskID: 0}); err != nil {
+		t.Fatalf("ReportCheckpoint failed: %v", err)
+	}
+	if _, err := ctl.ResumeJob(job, 2, nil); err != nil {
+		t.Fatalf("ResumeJob failed: %v", err)
+	}
+	if manifest, _ := ctl.GetCheckpoint(job, 6); len(manifest.Tasks) != 0 {
+		t.Errorf("reports of epoch 6 want none, get = %+v", manifest.Tasks)
+	}
+	if manifest, _ := ctl.LatestCheckpoint(job); len(manifest.Tasks) != 2 {
+		t.Errorf("committed reports want kept, get = %+v", manifest.Tasks)
+	}
+}
@@ -0,0 +1,338 @@
+package memcoord
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is an in-memory key-value store with watches and leases, as a tiny etcd.
+// It keeps the same layout as etcdutil. Coordinators of all the processes of a job
+// share one store.
+type Store struct {
+	mu        sync.Mutex
+	kvs       map[string]*kv
+	watchers  map[*watcher]bool
+	leases    map[int64]*lease
+	nextLease int64
+}
+
+type kv struct {
+	value string
+	lease int64
+}
+
+type lease struct {
+	ttl   time.Duration
+	timer *time.Timer
+	keys  map[string]bool
+}
+
+type event struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+// watcher delivers events of a key, or keys under a prefix, in order. Events are
+// queued so that a slow handler doesn't block the store.
+type watcher struct {
+	key     string
+	prefix  bool
+	handler func(event)
+
+	mu     sync.Mutex
+	queue  []event
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func NewStore() *Store {
+	return &Store{
+		kvs:      make(map[string]*kv),
+		watchers: make(map[*watcher]bool),
+		leases:   make(map[int64]*lease),
+	}
+}
+
+// NewCoordinator returns a coordinator for one process of a job.
+func (s *Store) NewCoordinator() *Coordinator {
+	return &Coordinator{store: s}
+}
+
+func (s *Store) get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kvs[key]
+	if !ok {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (s *Store) getPrefix(prefix string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getPrefixLocked(prefix)
+}
+
+func (s *Store) getPrefixLocked(prefix string) map[string]string {
+	res := make(map[string]string)
+	for k, v := range s.kvs {
+		if strings.HasPrefix(k, prefix) {
+			res[k] = v.value
+		}
+	}
+	return res
+}
+
+func (s *Store) put(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.putLocked(key, value, 0)
+}
+
+// create puts the key only if it doesn't exist.
+func (s *Store) create(key, value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kvs[key]; ok {
+		return false
+	}
+	s.putLocked(key, value, 0)
+	return true
+}
+
+// cas puts the key only if its current value is prev.
+func (s *Store) cas(key, prev, value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.kvs[key]; !ok || v.value != prev {
+		return false
+	}
+	s.putLocked(key, value, 0)
+	return true
+}
+
+// putIfAbsent puts the key only if absentKey doesn't exist.
+func (s *Store) putIfAbsent(absentKey, key, value string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.kvs[absentKey]; ok {
+		return false
+	}
+	s.putLocked(key, value, 0)
+	return true
+}
+
+// putIf puts all the kvs in one step, only if the current value of key is value.
+func (s *Store) putIf(key, value string, kvs map[string]string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if v, ok := s.kvs[key]; !ok || v.value != value {
+		return false
+	}
+	for k, v := range kvs {
+		s.putLocked(k, v, 0)
+	}
+	return true
+}
+
+func (s *Store) delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteLocked(key)
+}
+
+func (s *Store) deletePrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.getPrefixLocked(prefix) {
+		s.deleteLocked(k)
+	}
+}
+
+func (s *Store) putLocked(key, value string, leaseID int64) {
+	if old, ok := s.kvs[key]; ok && old.lease != 0 {
+		if l, ok := s.leases[old.lease]; ok {
+			delete(l.keys, key)
+		}
+	}
+	s.kvs[key] = &kv{value: value, lease: leaseID}
+	if l, ok := s.leases[leaseID]; ok {
+		l.keys[key] = true
+	}
+	s.notifyLocked(event{key: key, value: value})
+}
+
+func (s *Store) deleteLocked(key string) {
+	old, ok := s.kvs[key]
+	if !ok {
+		return
+	}
+	if l, ok := s.leases[old.lease]; ok {
+		delete(l.keys, key)
+	}
+	delete(s.kvs, key)
+	s.notifyLocked(event{key: key, deleted: true})
+}
+
+func (s *Store) notifyLocked(ev event) {
+	for w := range s.watchers {
+		if w.key == ev.key || (w.prefix && strings.HasPrefix(ev.key, w.key)) {
+			w.push(ev)
+		}
+	}
+}
+
+// watch calls handler on changes of key, or keys under key as prefix, until stop.
+// The returned channel is closed once the watch stops.
+func (s *Store) watch(key string, prefix bool, stop chan bool, handler func(event)) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.watchLocked(key, prefix, stop, handler)
+}
+
+// getAndWatch returns current kvs of the key, or keys under key as prefix, and
+// watches later changes. No change is missed in between.
+func (s *Store) getAndWatch(key string, prefix bool, stop chan bool, handler func(event)) (map[string]string, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kvs map[string]string
+	if prefix {
+		kvs = s.getPrefixLocked(key)
+	} else {
+		kvs = make(map[string]string)
+		if v, ok := s.kvs[key]; ok {
+			kvs[key] = v.value
+		}
+	}
+	return kvs, s.watchLocked(key, prefix, stop, handler)
+}
+
+// getAndWatchKey returns current value of the key, and watches later changes of it,
+// like getAndWatch. Nothing is watched if the key doesn't exist, and ok is false.
+func (s *Store) getAndWatchKey(key string, stop chan bool, handler func(event)) (value string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.kvs[key]
+	if !ok {
+		return "", false
+	}
+	s.watchLocked(key, false, stop, handler)
+	return v.value, true
+}
+
+func (s *Store) watchLocked(key string, prefix bool, stop chan bool, handler func(event)) <-chan struct{} {
+	w := &watcher{
+		key:     key,
+		prefix:  prefix,
+		handler: handler,
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	s.watchers[w] = true
+	go func() {
+		w.run(stop)
+		s.mu.Lock()
+		delete(s.watchers, w)
+		s.mu.Unlock()
+		close(w.done)
+	}()
+	return w.done
+}
+
+func (w *watcher) push(ev event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, ev)
+	w.mu.Unlock()
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) run(stop chan bool) {
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.notify:
+				continue
+			case <-stop:
+				return
+			}
+		}
+		ev := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		default:
+			w.handler(ev)
+		}
+	}
+}
+
+func (s *Store) grant(ttl time.Duration) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextLease++
+	id := s.nextLease
+	s.leases[id] = &lease{
+		ttl:   ttl,
+		timer: time.AfterFunc(ttl, func() { s.expire(id) }),
+		keys:  make(map[string]bool),
+	}
+	return id
+}
+
+func (s *Store) keepAlive(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	if !ok {
+		return fmt.Errorf("memcoord: lease %d expired", id)
+	}
+	l.timer.Reset(l.ttl)
+	return nil
+}
+
+// expire deletes all keys attached to the lease.
+func (s *Store) expire(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[id]
+	if !ok {
+		return
+	}
+	delete(s.leases, id)
+	for k := range l.keys {
+		s.deleteLocked(k)
+	}
+}
+
+// createWithLease puts all the kvs attached to the lease in one step, only if key
+// doesn't exist. Keys in deletes are removed at the same time, whether key is
+// created or not.
+func (s *Store) createWithLease(id int64, key string, kvs map[string]string, deletes []string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[id]; !ok {
+		return false, fmt.Errorf("memcoord: lease %d not found", id)
+	}
+	for _, k := range deletes {
+		s.deleteLocked(k)
+	}
+	if _, ok := s.kvs[key]; ok {
+		return false, nil
+	}
+	for k, v := range kvs {
+		s.putLocked(k, v, id)
+	}
+	return true, nil
+}
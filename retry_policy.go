@@ -0,0 +1,23 @@
+package taskgraph
+
+import "time"
+
+// RetryPolicy specifies how framework retries a failed data request. The delay
+// before each retry starts from InitialDelay and grows by Multiplier up to MaxDelay,
+// with a random Jitter fraction applied. Framework gives up once MaxAttempts or
+// Deadline is reached, and informs the task via DataFailed. Zero MaxAttempts or
+// Deadline means no such limit, and the request is retried until epoch changes.
+// Other zero fields take the defaults of framework.
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	// Jitter is between 0 and 1. A delay d becomes a random one in [d-Jitter*d, d+Jitter*d].
+	// A negative one turns jitter off, e.g. for deterministic tests.
+	Jitter float64
+
+	// Max number of attempts, including the first one.
+	MaxAttempts int
+	// Max time since the request is first sent.
+	Deadline time.Duration
+}
@@ -30,29 +30,69 @@ type Task interface {
 	// This is the callback when data from server is ready.
 	DataReady(ctx context.Context, fromID uint64, method string, output proto.Message)
 
+	// This is the callback when framework gives up a data request after retries
+	// according to the retry policy. The task can decide to request again, or fail.
+	DataFailed(ctx context.Context, toID uint64, method string, err error)
+
+	// This is the callback when framework fails at background work, e.g. watching
+	// meta, even after retries. err is *FrameworkError. The task decides whether to
+	// continue, or shutdown the job.
+	OnFrameworkError(err error)
+
 	CreateOutputMessage(methodName string) proto.Message
 	CreateServer() *grpc.Server
 }
 
+// UpdateLog is a change to the state of a task. The primary copy of the task hands
+// it to framework, which sends it to backups in order. It goes over the wire, so it
+// has to be a proto message.
 type UpdateLog interface {
-	UpdateID()
+	proto.Message
 }
 
 // Backupable is an interface that task need to implement if they want to have
-// hot standby copy. This is another can of beans.
+// hot standby copy. A backup is set up by Init like a primary, and then kept up
+// to date by update logs, so that it can take over at once if the primary fails.
+// Update logs are compacted into snapshots from time to time if the task is
+// Checkpointable too. Otherwise they're trimmed once all backups have applied
+// them, so a backup coming later can only join if it needs none trimmed.
 type Backupable interface {
-	// Some hooks that need for master slave etc.
+	// Some hooks that need for master slave etc. BecameBackup is called after
+	// Init on backups. BecamePrimary is called once the backup takes over, right
+	// before it enters current epoch.
 	BecamePrimary()
 	BecameBackup()
 
 	// Framework notify this copy to update. This should be the only way that
 	// one update the state of copy.
 	Update(log UpdateLog)
+	// Create an empty update log for framework to decode one into.
+	CreateUpdateLog() UpdateLog
 }
 
+// Checkpointable is an interface that task can implement to have its state saved
+// by framework every epoch, see framework.WithCheckpoint. A node taking over
+// the task restores the latest snapshot, instead of rebuilding all the state.
+type Checkpointable interface {
+	// Snapshot returns the state of the task at the start of current epoch, i.e.
+	// before EnterEpoch.
+	Snapshot() ([]byte, error)
+	// Restore sets the state of the task from a snapshot. It's called after Init,
+	// before the task enters current epoch, only with the snapshot taken at the
+	// start of current epoch. A node taking over the task gets OnFrameworkError
+	// instead if that one is lost. Backups of a Backupable task restore the
+	// snapshot its update logs are compacted into, before applying later logs.
+	Restore(snapshot []byte)
+}
+
+// GRPCHelper is used by framework to (de)serialize application messages. Framework
+// serves a generic grpc service and routes each call to task's handlers by method name,
+// so task doesn't need to create its own grpc server.
 type GRPCHelper interface {
+	// Create the input message of given method when serving requests.
+	CreateInputMessage(methodName string) proto.Message
+	// Create the reply message of given method when sending requests.
 	CreateOutputMessage(methodName string) proto.Message
-	CreateServer() *grpc.Server
 }
 
 // Master task is assumed to be fault tolerant.
@@ -63,6 +103,9 @@ type MasterTask interface {
 
 	// Corresponds to NotifyMaster
 	OnNotify(ctx context.Context, workerID uint64, method string, input proto.Message) (proto.Message, error)
+	// Framework tells master that a worker failed. Another worker will take over
+	// the worker ID and start from Setup. Master should reset the worker's state.
+	OnWorkerFailure(ctx context.Context, workerID uint64)
 	GRPCHelper
 }
 
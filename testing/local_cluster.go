@@ -0,0 +1,312 @@
+// Package testing runs a whole taskgraph job inside one process, so that job
+// behavior, including failure and recovery, can be checked by plain unit tests
+// without etcd. Import it with another name to keep the standard one, e.g.
+//
+//	import tgtesting "github.com/taskgraph/taskgraph/testing"
+package testing
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/controller"
+	"github.com/taskgraph/taskgraph/framework"
+	"github.com/taskgraph/taskgraph/pkg/memcoord"
+)
+
+const (
+	localJobName             = "localcluster"
+	defaultHeartbeatInterval = 50 * time.Millisecond
+)
+
+// LocalCluster runs a controller and one bootstrap per task on loopback
+// listeners. All of them coordinate through an in-memory store.
+//
+//	c := &LocalCluster{NumTasks: 3, Topology: newTopo, TaskBuilder: builder}
+//	c.Start()
+//	defer c.Stop()
+//	c.WaitDone()
+type LocalCluster struct {
+	NumTasks uint64
+	// Topology returns a new topology for each bootstrap, since a topology
+	// is bound to the task it's set to.
+	Topology    func() taskgraph.Topology
+	TaskBuilder taskgraph.TaskBuilder
+	// HeartbeatInterval is used by all tasks. A killed task is taken over after
+	// a few of them. It's 50ms by default.
+	HeartbeatInterval time.Duration
+	// Options are given to every bootstrap, e.g. framework.WithFaults.
+	Options []framework.Option
+	// RestartKilled starts a new bootstrap whenever one stops before the job is
+	// done, unless it's killed by KillTask. Tasks killed by injected faults are
+	// then taken over as in a real cluster. The new bootstrap is a backup if
+	// tasks have backups.
+	RestartKilled bool
+	// Backups is the number of hot-standby backups of each task, see
+	// framework.AsBackup. Tasks have to be Backupable then.
+	Backups uint64
+	// EpochQuorum is the number of tasks to be done with an epoch before the job
+	// moves on, see controller.SetEpochQuorum. All tasks by default.
+	EpochQuorum uint64
+	// Staleness makes the job stale-synchronous, see controller.SetStaleness.
+	Staleness uint64
+
+	store     *memcoord.Store
+	ctl       *controller.Controller
+	watchStop chan bool
+
+	mu      sync.Mutex
+	running map[uint64]*node
+	// bootstraps not stopped yet, by address.
+	live    map[string]*node
+	nodes   sync.WaitGroup
+	errs    []error
+	stopped bool
+}
+
+// node is a bootstrap, which works for some task once it gets one. The task is
+// found by the address it registers.
+type node struct {
+	framework taskgraph.Framework
+	addr      string
+	taskID    uint64
+	done      chan struct{}
+	// killed by KillTask or Stop.
+	killed bool
+}
+
+// Start sets up the job and starts a bootstrap for each task.
+func (c *LocalCluster) Start() error {
+	if c.HeartbeatInterval == 0 {
+		c.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	c.store = memcoord.NewStore()
+	c.running = make(map[uint64]*node)
+	c.live = make(map[string]*node)
+	c.ctl = controller.NewWithCoordinator(localJobName, c.store.NewCoordinator(), c.NumTasks, c.Topology().GetLinkTypes())
+	c.ctl.SetBackups(c.Backups)
+	c.ctl.SetEpochQuorum(c.EpochQuorum)
+	c.ctl.SetStaleness(c.Staleness)
+	if err := c.ctl.Start(); err != nil {
+		return err
+	}
+	c.watchStop = make(chan bool, 1)
+	if err := c.store.NewCoordinator().WatchTaskAddress(localJobName, c.watchStop, c.taskMoved); err != nil {
+		return err
+	}
+	for i := uint64(0); i < c.NumTasks; i++ {
+		if err := c.startNode(false); err != nil {
+			return err
+		}
+	}
+	for i := uint64(0); i < c.NumTasks*c.Backups; i++ {
+		if err := c.startNode(true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// KillTask kills the bootstrap working for the task, as if its process crashed.
+// It returns after the bootstrap has stopped. The task is freed once its session
+// expires, or taken over by a backup.
+func (c *LocalCluster) KillTask(taskID uint64) error {
+	c.mu.Lock()
+	n, ok := c.running[taskID]
+	delete(c.running, taskID)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("task %d isn't running", taskID)
+	}
+	c.kill(n)
+	<-n.done
+	return nil
+}
+
+// RestartTask starts a new bootstrap to take over the killed task. The new one
+// grabs the task once it's freed.
+func (c *LocalCluster) RestartTask(taskID uint64) error {
+	c.mu.Lock()
+	_, ok := c.running[taskID]
+	c.mu.Unlock()
+	if ok {
+		return fmt.Errorf("task %d is still running", taskID)
+	}
+	return c.startNode(false)
+}
+
+// Resize changes the number of tasks from the next epoch on, and starts a
+// bootstrap for each new task. Bootstraps of tasks beyond the new size exit at
+// the next epoch.
+func (c *LocalCluster) Resize(numOfTasks uint64) error {
+	if err := c.ctl.Resize(numOfTasks); err != nil {
+		return err
+	}
+	for i := c.NumTasks; i < numOfTasks; i++ {
+		if err := c.startNode(false); err != nil {
+			return err
+		}
+	}
+	c.NumTasks = numOfTasks
+	return nil
+}
+
+// WaitEpoch blocks until the job has got into the epoch, or a later one.
+func (c *LocalCluster) WaitEpoch(epoch uint64) error {
+	epochC := make(chan uint64, 1)
+	stop := make(chan bool)
+	defer close(stop)
+	current, err := c.store.NewCoordinator().GetAndWatchEpoch(localJobName, epochC, stop)
+	if err != nil {
+		return err
+	}
+	for current < epoch {
+		current = <-epochC
+	}
+	return nil
+}
+
+// Epoch returns current epoch of the job.
+func (c *LocalCluster) Epoch() (uint64, error) {
+	return c.currentEpoch()
+}
+
+// WaitDone blocks until the job is done and all bootstraps have stopped. It
+// returns the terminal status of the job. Error is returned if the job didn't
+// succeed, or a bootstrap failed to start.
+func (c *LocalCluster) WaitDone() (taskgraph.JobStatus, error) {
+	status, err := c.ctl.WaitForJobDone()
+	c.nodes.Wait()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.errs) > 0 {
+		return status, c.errs[0]
+	}
+	return status, err
+}
+
+// Stop kills all bootstraps still running and tears down the job.
+func (c *LocalCluster) Stop() {
+	c.mu.Lock()
+	c.stopped = true
+	for _, n := range c.live {
+		c.killLocked(n)
+	}
+	c.running = make(map[uint64]*node)
+	c.mu.Unlock()
+	c.nodes.Wait()
+	c.watchStop <- true
+	c.ctl.Stop()
+}
+
+func (c *LocalCluster) startNode(backup bool) error {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	opts := append([]framework.Option{
+		framework.WithCoordinator(c.store.NewCoordinator()),
+		framework.WithHeartbeatInterval(c.HeartbeatInterval),
+	}, c.Options...)
+	if backup {
+		opts = append(opts, framework.AsBackup())
+	}
+	bootstrap := framework.NewBootStrapWithOptions(localJobName, nil, ln, nil, opts...)
+	bootstrap.SetTaskBuilder(c.TaskBuilder)
+	bootstrap.SetTopology(c.Topology())
+	// The bootstrap is the framework of the task it gets. Keep it now, so that it
+	// can be killed before it gets a task.
+	n := &node{
+		framework: bootstrap.(taskgraph.Framework),
+		addr:      ln.Addr().String(),
+		done:      make(chan struct{}),
+	}
+	c.mu.Lock()
+	c.live[n.addr] = n
+	c.mu.Unlock()
+
+	c.nodes.Add(1)
+	go func() {
+		defer c.nodes.Done()
+		defer close(n.done)
+		err := bootstrap.Start()
+		c.removeNode(n)
+		if err != nil {
+			c.mu.Lock()
+			c.errs = append(c.errs, err)
+			c.mu.Unlock()
+			return
+		}
+		if c.RestartKilled && c.shouldRestart(n) {
+			// With backups, one of them takes over the task, and the new
+			// bootstrap backs up some task instead.
+			if err := c.startNode(c.Backups > 0); err != nil {
+				c.mu.Lock()
+				c.errs = append(c.errs, err)
+				c.mu.Unlock()
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *LocalCluster) kill(n *node) {
+	c.mu.Lock()
+	c.killLocked(n)
+	c.mu.Unlock()
+}
+
+func (c *LocalCluster) killLocked(n *node) {
+	n.killed = true
+	n.framework.Kill()
+}
+
+// taskMoved finds out which bootstrap works for the task by its new address,
+// e.g. a backup has taken over the task.
+func (c *LocalCluster) taskMoved(taskID uint64, addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n, ok := c.live[addr]; ok {
+		n.taskID = taskID
+		c.running[taskID] = n
+	}
+}
+
+func (c *LocalCluster) removeNode(n *node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.live, n.addr)
+	if c.running[n.taskID] == n {
+		delete(c.running, n.taskID)
+	}
+}
+
+// shouldRestart tells whether the stopped node has crashed, i.e. it isn't killed
+// by the cluster, which isn't stopping, and the job isn't done.
+func (c *LocalCluster) shouldRestart(n *node) bool {
+	c.mu.Lock()
+	killed := n.killed || c.stopped
+	taskID := n.taskID
+	c.mu.Unlock()
+	if killed {
+		return false
+	}
+	epoch, err := c.currentEpoch()
+	if err != nil || epoch == taskgraph.ExitEpoch {
+		return false
+	}
+	// a task retired by Resize is gone for good.
+	latest, err := c.store.NewCoordinator().GetJobSize(localJobName, taskgraph.ExitEpoch)
+	if err != nil {
+		return false
+	}
+	size, err := c.store.NewCoordinator().GetJobSize(localJobName, epoch)
+	return err == nil && (taskID < size || taskID < latest)
+}
+
+func (c *LocalCluster) currentEpoch() (uint64, error) {
+	return c.store.NewCoordinator().GetEpoch(localJobName)
+}
@@ -0,0 +1,709 @@
+package testing_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/taskgraph/taskgraph"
+	"github.com/taskgraph/taskgraph/example/topo"
+	"github.com/taskgraph/taskgraph/filesystem"
+	"github.com/taskgraph/taskgraph/framework"
+	tgtesting "github.com/taskgraph/taskgraph/testing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// TestLocalClusterKillTask kills the task that drives epochs and checks that
+// the job continues once the task is restarted.
+func TestLocalClusterKillTask(t *testing.T) {
+	b := &testTaskBuilder{lastEpoch: 2, step: make(chan bool)}
+	c := &tgtesting.LocalCluster{NumTasks: 3, Topology: treeTopology(3), TaskBuilder: b}
+	runCluster(t, c, func() {
+		stepTo(t, c, b.step, 1)
+		if err := c.RestartTask(0); err == nil {
+			t.Errorf("RestartTask on running task should fail")
+		}
+		if err := c.KillTask(0); err != nil {
+			t.Fatalf("KillTask failed: %v", err)
+		}
+		if err := c.KillTask(0); err == nil {
+			t.Errorf("KillTask on killed task should fail")
+		}
+		if err := c.RestartTask(0); err != nil {
+			t.Fatalf("RestartTask failed: %v", err)
+		}
+		// the new task 0 continues from epoch 1.
+		stepTo(t, c, b.step, 2)
+		b.step <- true
+	})
+}
+
+// TestLocalClusterFailJob checks that a job failed by a task ends with the reason.
+func TestLocalClusterFailJob(t *testing.T) {
+	b := &testTaskBuilder{lastEpoch: 1, step: make(chan bool), failReason: "diverged"}
+	c := &tgtesting.LocalCluster{NumTasks: 3, Topology: treeTopology(3), TaskBuilder: b}
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	stepTo(t, c, b.step, 1)
+	b.step <- true
+	status, err := c.WaitDone()
+	if err == nil {
+		t.Errorf("WaitDone of failed job should fail")
+	}
+	if want := (taskgraph.JobStatus{State: taskgraph.JobFailed, Reason: "diverged"}); status != want {
+		t.Errorf("job status want = %v, get = %v", want, status)
+	}
+}
+
+// TestLocalClusterResize grows the job from 3 to 5 tasks, then shrinks it to 2,
+// and checks that each epoch is entered by exactly the tasks of its size.
+func TestLocalClusterResize(t *testing.T) {
+	b := &testTaskBuilder{lastEpoch: 3, step: make(chan bool), entered: make(chan taskEpoch, 100)}
+	c := &tgtesting.LocalCluster{NumTasks: 3, Topology: treeTopology(3), TaskBuilder: b}
+
+	wantTasks := []uint64{3, 5, 5, 2}
+	checkEntered := func(epoch uint64) {
+		get := make(map[uint64]bool)
+		for uint64(len(get)) < wantTasks[epoch] {
+			te := <-b.entered
+			if te.epoch != epoch {
+				t.Fatalf("task %d entered epoch %d, want epoch %d", te.taskID, te.epoch, epoch)
+			}
+			get[te.taskID] = true
+		}
+		for id := range get {
+			if id >= wantTasks[epoch] {
+				t.Errorf("task %d entered epoch %d of %d tasks", id, epoch, wantTasks[epoch])
+			}
+		}
+	}
+
+	runCluster(t, c, func() {
+		checkEntered(0)
+		if err := c.Resize(5); err != nil {
+			t.Fatalf("Resize failed: %v", err)
+		}
+		b.step <- true
+		checkEntered(1)
+		b.step <- true
+		checkEntered(2)
+		if err := c.Resize(2); err != nil {
+			t.Fatalf("Resize failed: %v", err)
+		}
+		b.step <- true
+		checkEntered(3)
+		b.step <- true
+	})
+	if len(b.entered) > 0 {
+		t.Errorf("unexpected epoch entered: %+v", <-b.entered)
+	}
+}
+
+// TestLocalClusterBackup kills the primary of a task, and checks that its backup
+// takes over with the updates made so far.
+func TestLocalClusterBackup(t *testing.T) {
+	b := &testTaskBuilder{
+		lastEpoch: 3,
+		step:      make(chan bool),
+		entered:   make(chan taskEpoch, 100),
+		applied:   make(chan taskEpoch, 100),
+		promoted:  make(chan []uint64, 2),
+	}
+	c := &tgtesting.LocalCluster{NumTasks: 2, Backups: 1, Topology: treeTopology(2), TaskBuilder: b}
+	runCluster(t, c, func() {
+		waitStarted(b.entered, 2)
+		stepTo(t, c, b.step, 1)
+		stepTo(t, c, b.step, 2)
+		// the backup of task 1 is up to date.
+		for te := range b.applied {
+			if te.taskID == 1 && te.epoch == 2 {
+				break
+			}
+		}
+		if err := c.KillTask(1); err != nil {
+			t.Fatalf("KillTask failed: %v", err)
+		}
+		epochs := <-b.promoted
+		if want := []uint64{0, 1, 2}; !reflect.DeepEqual(epochs, want) {
+			t.Errorf("epochs of backup taking over want = %v, get = %v", want, epochs)
+		}
+		stepTo(t, c, b.step, 3)
+		b.step <- true
+	})
+}
+
+// TestLocalClusterCheckpoint kills a task, and checks that the new one restores
+// the snapshot taken at the start of current epoch.
+func TestLocalClusterCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLocalClusterCheckpoint")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fs := &renameNotifier{Client: filesystem.NewLocalFSClient(), renamed: make(chan string, 20)}
+	b := &testTaskBuilder{
+		lastEpoch: 3,
+		step:      make(chan bool),
+		entered:   make(chan taskEpoch, 100),
+		restored:  make(chan []uint64, 1),
+	}
+	c := &tgtesting.LocalCluster{
+		NumTasks:    2,
+		Topology:    treeTopology(2),
+		TaskBuilder: b,
+		Options:     []framework.Option{framework.WithCheckpoint(fs, dir)},
+	}
+	runCluster(t, c, func() {
+		waitStarted(b.entered, 2)
+		stepTo(t, c, b.step, 1)
+		stepTo(t, c, b.step, 2)
+		// both tasks have saved checkpoints of epoch 1 and 2, once the manifest
+		// of epoch 2 is written.
+		var manifest string
+		for manifests := 0; manifests < 2; {
+			if name := <-fs.renamed; strings.Contains(name, "-manifest-") {
+				manifest = name
+				manifests++
+			}
+		}
+		data, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		var committed taskgraph.CheckpointManifest
+		if err := json.Unmarshal(data, &committed); err != nil {
+			t.Fatalf("Unmarshal manifest failed: %v", err)
+		}
+		if committed.Epoch != 2 || len(committed.Tasks) != 2 {
+			t.Errorf("manifest want epoch 2 of 2 tasks, get = %+v", committed)
+		}
+		if err := c.KillTask(1); err != nil {
+			t.Fatalf("KillTask failed: %v", err)
+		}
+		if err := c.RestartTask(1); err != nil {
+			t.Fatalf("RestartTask failed: %v", err)
+		}
+		epochs := <-b.restored
+		if want := []uint64{0, 1}; !reflect.DeepEqual(epochs, want) {
+			t.Errorf("restored epochs want = %v, get = %v", want, epochs)
+		}
+		stepTo(t, c, b.step, 3)
+		b.step <- true
+	})
+}
+
+// TestLocalClusterCheckpointChecksum breaks a committed snapshot, and checks that
+// the task taking over is told so, instead of restoring it.
+func TestLocalClusterCheckpointChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestLocalClusterCheckpointChecksum")
+	if err != nil {
+		t.Fatalf("TempDir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	fs := &renameNotifier{Client: filesystem.NewLocalFSClient(), renamed: make(chan string, 20)}
+	b := &testTaskBuilder{
+		lastEpoch: 3,
+		step:      make(chan bool),
+		entered:   make(chan taskEpoch, 100),
+		restored:  make(chan []uint64, 1),
+		errs:      make(chan error, 10),
+	}
+	c := &tgtesting.LocalCluster{
+		NumTasks:    2,
+		Topology:    treeTopology(2),
+		TaskBuilder: b,
+		Options:     []framework.Option{framework.WithCheckpoint(fs, dir)},
+	}
+	runCluster(t, c, func() {
+		waitStarted(b.entered, 2)
+		stepTo(t, c, b.step, 1)
+		stepTo(t, c, b.step, 2)
+		name := ""
+		for !strings.HasSuffix(name, fmt.Sprintf("-manifest-%020d", 2)) {
+			name = <-fs.renamed
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile failed: %v", err)
+		}
+		var committed taskgraph.CheckpointManifest
+		if err := json.Unmarshal(data, &committed); err != nil {
+			t.Fatalf("Unmarshal manifest failed: %v", err)
+		}
+		for _, task := range committed.Tasks {
+			if task.TaskID == 1 {
+				if err := ioutil.WriteFile(task.Path, []byte("broken"), 0644); err != nil {
+					t.Fatalf("WriteFile failed: %v", err)
+				}
+			}
+		}
+		if err := c.KillTask(1); err != nil {
+			t.Fatalf("KillTask failed: %v", err)
+		}
+		if err := c.RestartTask(1); err != nil {
+			t.Fatalf("RestartTask failed: %v", err)
+		}
+		if err := <-b.errs; !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("framework error want about checksum, get = %v", err)
+		}
+		if len(b.restored) > 0 {
+			t.Errorf("broken checkpoint restored: %v", <-b.restored)
+		}
+		stepTo(t, c, b.step, 3)
+		b.step <- true
+	})
+}
+
+// TestLocalClusterEpochDone checks that the job moves on once all tasks are done
+// with the epoch, without any task counting others, or once a quorum of them are.
+func TestLocalClusterEpochDone(t *testing.T) {
+	for _, quorum := range []uint64{0, 2} {
+		b := &testTaskBuilder{
+			lastEpoch: 2,
+			epochDone: true,
+			slow:      2,
+			release:   make(chan bool),
+			reported:  make(chan taskEpoch, 100),
+		}
+		c := &tgtesting.LocalCluster{NumTasks: 3, Topology: treeTopology(3), TaskBuilder: b, EpochQuorum: quorum}
+		// with a quorum, the slow task is left behind at each epoch.
+		runCluster(t, c, func() {
+			if quorum > 0 {
+				return
+			}
+			for epoch := uint64(0); epoch < 2; epoch++ {
+				// the job waits for the slow task.
+				for i := 0; i < 2; i++ {
+					<-b.reported
+				}
+				if current, err := c.Epoch(); err != nil || current != epoch {
+					t.Errorf("epoch before the slow task is done want = (%d, nil), get = (%d, %v)", epoch, current, err)
+				}
+				b.release <- true
+				if err := c.WaitEpoch(epoch + 1); err != nil {
+					t.Fatalf("WaitEpoch failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestLocalClusterStaleness checks that tasks of a stale-synchronous job run ahead
+// of the slow one, but not beyond the staleness.
+func TestLocalClusterStaleness(t *testing.T) {
+	b := &testTaskBuilder{
+		lastEpoch: 4,
+		entered:   make(chan taskEpoch, 100),
+		epochDone: true,
+		slow:      2,
+		release:   make(chan bool),
+		reported:  make(chan taskEpoch, 100),
+	}
+	c := &tgtesting.LocalCluster{NumTasks: 3, Topology: treeTopology(3), TaskBuilder: b, Staleness: 2}
+	runCluster(t, c, func() {
+		// the fast tasks get to epoch 2 while the slow one is at 0, and then wait.
+		fastest := make(map[uint64]uint64)
+		for fastest[0] < 2 || fastest[1] < 2 {
+			e := <-b.entered
+			if e.epoch > 2 {
+				t.Fatalf("task %d entered epoch %d with the slowest at 0", e.taskID, e.epoch)
+			}
+			fastest[e.taskID] = e.epoch
+		}
+		if fastest[2] > 0 {
+			t.Errorf("slow task want at epoch 0, get = %d", fastest[2])
+		}
+		select {
+		case e := <-b.entered:
+			if e.taskID != 2 {
+				t.Fatalf("task %d entered epoch %d with the slowest at 0", e.taskID, e.epoch)
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+		if epoch, err := c.Epoch(); err != nil || epoch != 0 {
+			t.Errorf("epoch of job want = (0, nil), get = (%d, %v)", epoch, err)
+		}
+
+		// the job moves on with the slow task, and so do the fast ones.
+		b.release <- true
+		if err := c.WaitEpoch(1); err != nil {
+			t.Fatalf("WaitEpoch failed: %v", err)
+		}
+		b.release <- true
+	})
+}
+
+// TestLocalClusterStalenessMetas lets a task run ahead of a slow one that waits
+// for its metas, and checks that the slow one still gets the meta of each epoch,
+// though the fast one has flagged those of later epochs.
+func TestLocalClusterStalenessMetas(t *testing.T) {
+	b := &testTaskBuilder{
+		lastEpoch: 4,
+		metas:     true,
+		heard:     make(chan taskEpoch, 100),
+		errs:      make(chan error, 100),
+		slow:      1,
+		release:   make(chan bool),
+	}
+	c := &tgtesting.LocalCluster{
+		NumTasks:    2,
+		Topology:    func() taskgraph.Topology { return &leaderTopology{} },
+		TaskBuilder: b,
+		Staleness:   2,
+	}
+	runCluster(t, c, func() {
+		// the leader gets to epoch 2 while the slow task is at 0.
+		for e := <-b.heard; e.taskID != 0 || e.epoch != 2; e = <-b.heard {
+		}
+		b.release <- true
+		timeout := time.After(time.Second)
+		for e := <-b.heard; e.taskID != 1 || e.epoch != 1; {
+			select {
+			case e = <-b.heard:
+			case <-timeout:
+				t.Fatalf("slow task missed the meta of epoch 1")
+			}
+		}
+		b.release <- true
+	})
+	for len(b.errs) > 0 {
+		t.Error(<-b.errs)
+	}
+}
+
+// TestLocalClusterDynamicTopology runs topologies whose neighbors change every
+// epoch, and checks that each task gets metas from exactly the neighbors of each
+// epoch, i.e. meta watches are set up again at every epoch.
+func TestLocalClusterDynamicTopology(t *testing.T) {
+	topologies := map[string]func() taskgraph.Topology{
+		"row/column":      func() taskgraph.Topology { return topo.NewRowColumnTopology(4) },
+		"ring all-reduce": func() taskgraph.Topology { return topo.NewRingAllReduceTopology(4) },
+		"gossip":          func() taskgraph.Topology { return topo.NewGossipTopology(2, 4, 1) },
+	}
+	for name, newTopology := range topologies {
+		b := &testTaskBuilder{
+			lastEpoch: 4,
+			metas:     true,
+			heard:     make(chan taskEpoch, 100),
+			errs:      make(chan error, 100),
+		}
+		// the job can't move on if a task misses a meta.
+		runCluster(t, &tgtesting.LocalCluster{NumTasks: 4, Topology: newTopology, TaskBuilder: b}, func() {})
+		if len(b.heard) != 16 {
+			t.Errorf("%s: epochs heard from all neighbors want = 16, get = %d", name, len(b.heard))
+		}
+		for len(b.errs) > 0 {
+			t.Errorf("%s: %v", name, <-b.errs)
+		}
+	}
+}
+
+// runCluster starts the cluster, runs test against it, and then waits for the job
+// to succeed before stopping the cluster.
+func runCluster(t *testing.T, c *tgtesting.LocalCluster, test func()) {
+	if err := c.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+	test()
+	status, err := c.WaitDone()
+	if err != nil {
+		t.Errorf("WaitDone failed: %v", err)
+	}
+	if status.State != taskgraph.JobSucceeded {
+		t.Errorf("job state want = %v, get = %v", taskgraph.JobSucceeded, status.State)
+	}
+}
+
+// stepTo steps task 0 once, and waits until the job gets to the epoch.
+func stepTo(t *testing.T, c *tgtesting.LocalCluster, step chan bool, epoch uint64) {
+	step <- true
+	if err := c.WaitEpoch(epoch); err != nil {
+		t.Fatalf("WaitEpoch failed: %v", err)
+	}
+}
+
+// waitStarted waits until n tasks have entered epoch 0, so that none of them
+// starts at a later epoch.
+func waitStarted(entered chan taskEpoch, n int) {
+	for i := 0; i < n; i++ {
+		<-entered
+	}
+}
+
+func treeTopology(numOfTasks uint64) func() taskgraph.Topology {
+	return func() taskgraph.Topology { return topo.NewTreeTopology(2, numOfTasks) }
+}
+
+// leaderTopology has every task but 0 wait for metas of task 0, which waits for
+// no one.
+type leaderTopology struct {
+	taskID uint64
+}
+
+func (t *leaderTopology) SetTaskID(taskID uint64) { t.taskID = taskID }
+
+func (t *leaderTopology) GetLinkTypes() []string { return []string{"Leader"} }
+
+func (t *leaderTopology) GetNeighbors(linkType string, epoch uint64) []uint64 {
+	if t.taskID == 0 {
+		return nil
+	}
+	return []uint64{0}
+}
+
+type taskEpoch struct {
+	taskID, epoch uint64
+}
+
+// testTaskBuilder builds testTasks, which all report to it. What the tasks do is
+// set by its fields; those of features not under test are left zero.
+type testTaskBuilder struct {
+	// task 0 shuts the job down at lastEpoch, or fails it if failReason is set.
+	lastEpoch  uint64
+	failReason string
+	// if not nil, each task sends the epochs it enters.
+	entered chan taskEpoch
+
+	// if not nil, task 0 moves the job to the next epoch each time test steps.
+	step chan bool
+
+	// if set, each task reports done with each epoch and sends it to reported.
+	// The slow task is done only once released.
+	epochDone bool
+	slow      uint64
+	release   chan bool
+	reported  chan taskEpoch
+
+	// if set, each task flags a meta on each link at each epoch, and is done with
+	// the epoch once it has got metas from all neighbors of the epoch, or also
+	// released if it's the slow task. It sends the epochs it has heard from all
+	// neighbors at.
+	metas bool
+	heard chan taskEpoch
+	// if not nil, each task sends what goes wrong, including framework errors.
+	errs chan error
+
+	// backups send each update they apply, and their epochs once they take over.
+	applied  chan taskEpoch
+	promoted chan []uint64
+	// a task sends its epochs once it restores them from a checkpoint.
+	restored chan []uint64
+}
+
+func (b *testTaskBuilder) GetTask(taskID uint64) taskgraph.Task {
+	return &testTask{builder: b}
+}
+
+// testTask records the epochs it has entered, which are backed up and
+// checkpointed, and does at each epoch what its builder sets.
+type testTask struct {
+	builder   *testTaskBuilder
+	taskID    uint64
+	framework taskgraph.Framework
+	epochs    []uint64
+
+	ctx   context.Context
+	epoch uint64
+	// neighbors not heard from yet, by link type.
+	waiting map[string]map[uint64]bool
+}
+
+func (t *testTask) Init(taskID uint64, framework taskgraph.Framework) {
+	t.taskID = taskID
+	t.framework = framework
+}
+
+func (t *testTask) Exit() {}
+
+func (t *testTask) EnterEpoch(ctx context.Context, epoch uint64) {
+	b := t.builder
+	// a backup taking over enters current epoch again.
+	if t.add(epoch) && b.applied != nil {
+		t.framework.(taskgraph.BackedUpFramework).Update(t.taskID, &epochLog{Epoch: epoch})
+	}
+	if b.entered != nil {
+		b.entered <- taskEpoch{taskID: t.taskID, epoch: epoch}
+	}
+	switch {
+	case b.step != nil:
+		if t.taskID == 0 {
+			go t.waitStep(ctx, epoch)
+		}
+	case epoch == b.lastEpoch:
+		if t.taskID == 0 {
+			t.endJob()
+		}
+	case b.metas:
+		t.flagMetas(ctx, epoch)
+	case b.epochDone:
+		go t.epochDone(ctx, epoch)
+	}
+}
+
+func (t *testTask) add(epoch uint64) bool {
+	if n := len(t.epochs); n > 0 && t.epochs[n-1] >= epoch {
+		return false
+	}
+	t.epochs = append(t.epochs, epoch)
+	return true
+}
+
+// waitStep moves the job to next epoch, or ends it at last epoch, once test steps.
+func (t *testTask) waitStep(ctx context.Context, epoch uint64) {
+	select {
+	case <-t.builder.step:
+	case <-ctx.Done():
+		// killed, or epoch changed.
+		return
+	}
+	if epoch == t.builder.lastEpoch {
+		t.endJob()
+		return
+	}
+	t.framework.IncEpoch(ctx)
+}
+
+func (t *testTask) endJob() {
+	if t.builder.failReason != "" {
+		t.framework.FailJob(t.builder.failReason)
+		return
+	}
+	t.framework.ShutdownJob()
+}
+
+func (t *testTask) epochDone(ctx context.Context, epoch uint64) {
+	if t.taskID == t.builder.slow {
+		select {
+		case <-t.builder.release:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := t.framework.EpochDone(ctx); err == nil {
+		t.builder.reported <- taskEpoch{taskID: t.taskID, epoch: epoch}
+	}
+}
+
+func (t *testTask) flagMetas(ctx context.Context, epoch uint64) {
+	t.ctx, t.epoch = ctx, epoch
+	t.waiting = make(map[string]map[uint64]bool)
+	topology := t.framework.GetTopology()
+	for _, linkType := range topology.GetLinkTypes() {
+		t.waiting[linkType] = make(map[uint64]bool)
+		for _, id := range topology.GetNeighbors(linkType, epoch) {
+			t.waiting[linkType][id] = true
+		}
+		// The same meta of a task is delivered only once an epoch, so each link
+		// has its own.
+		if err := t.framework.FlagMeta(ctx, linkType, linkType); err != nil {
+			t.builder.errs <- err
+		}
+	}
+	t.checkMetasDone()
+}
+
+func (t *testTask) MetaReady(ctx context.Context, fromID uint64, linkType, meta string) {
+	if !t.builder.metas {
+		return
+	}
+	if !t.waiting[linkType][fromID] || meta != linkType {
+		t.builder.errs <- fmt.Errorf("task %d got meta %q by %s from task %d at epoch %d", t.taskID, meta, linkType, fromID, t.epoch)
+		return
+	}
+	delete(t.waiting[linkType], fromID)
+	t.checkMetasDone()
+}
+
+func (t *testTask) checkMetasDone() {
+	for _, ids := range t.waiting {
+		if len(ids) > 0 {
+			return
+		}
+	}
+	t.builder.heard <- taskEpoch{taskID: t.taskID, epoch: t.epoch}
+	go func(ctx context.Context) {
+		if t.taskID == t.builder.slow && t.builder.release != nil {
+			select {
+			case <-t.builder.release:
+			case <-ctx.Done():
+				return
+			}
+		}
+		t.framework.EpochDone(ctx)
+	}(t.ctx)
+}
+
+func (t *testTask) DataReady(ctx context.Context, fromID uint64, method string, output proto.Message) {
+}
+
+func (t *testTask) DataFailed(ctx context.Context, toID uint64, method string, err error) {}
+
+func (t *testTask) OnFrameworkError(err error) {
+	if t.builder.errs != nil {
+		t.builder.errs <- err
+	}
+}
+
+func (t *testTask) CreateOutputMessage(methodName string) proto.Message { return nil }
+
+func (t *testTask) CreateServer() *grpc.Server { return grpc.NewServer() }
+
+func (t *testTask) BecamePrimary() {
+	if t.builder.promoted != nil {
+		t.builder.promoted <- append([]uint64(nil), t.epochs...)
+	}
+}
+
+func (t *testTask) BecameBackup() {}
+
+func (t *testTask) Update(log taskgraph.UpdateLog) {
+	epoch := log.(*epochLog).Epoch
+	t.add(epoch)
+	t.builder.applied <- taskEpoch{taskID: t.taskID, epoch: epoch}
+}
+
+func (t *testTask) CreateUpdateLog() taskgraph.UpdateLog { return new(epochLog) }
+
+func (t *testTask) Snapshot() ([]byte, error) { return json.Marshal(t.epochs) }
+
+func (t *testTask) Restore(snapshot []byte) {
+	if err := json.Unmarshal(snapshot, &t.epochs); err != nil {
+		panic(err)
+	}
+	if t.builder.restored != nil {
+		t.builder.restored <- append([]uint64(nil), t.epochs...)
+	}
+}
+
+type epochLog struct {
+	Epoch uint64 `protobuf:"varint,1,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *epochLog) Reset()         { *m = epochLog{} }
+func (m *epochLog) String() string { return proto.CompactTextString(m) }
+func (*epochLog) ProtoMessage()    {}
+
+// renameNotifier tells each file renamed, i.e. each checkpoint saved.
+type renameNotifier struct {
+	filesystem.Client
+	renamed chan string
+}
+
+func (c *renameNotifier) Rename(oldpath, newpath string) error {
+	if err := c.Client.Rename(oldpath, newpath); err != nil {
+		return err
+	}
+	c.renamed <- newpath
+	return nil
+}
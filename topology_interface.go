@@ -25,3 +25,12 @@ type Topology interface {
 	// This returns the neighbors of given link for this node at this epoch.
 	GetNeighbors(linkType string, epoch uint64) []uint64
 }
+
+// ResizableTopology is a topology whose job can be resized, see
+// controller.Resize. The framework calls Resize with the new number of tasks
+// before the epoch where it takes effect, so that GetNeighbors reflects the new
+// membership from that epoch on.
+type ResizableTopology interface {
+	Topology
+	Resize(numOfTasks uint64)
+}